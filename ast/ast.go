@@ -104,6 +104,300 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+type AssignStatement struct {
+	Token   token.Token  // the first '=' token
+	Targets []Expression // a = b = 1 has two targets, assigned from left to right
+	Value   Expression
+}
+
+func (as *AssignStatement) statementNode()       {}
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignStatement) String() string {
+	var out bytes.Buffer
+
+	for _, target := range as.Targets {
+		out.WriteString(target.String())
+		out.WriteString(" " + token.Assign + " ")
+	}
+	out.WriteString(as.Value.String())
+
+	return out.String()
+}
+
+type AnnotatedAssignStatement struct {
+	Token token.Token // the ':' token
+	Name  *Identifier
+	Type  Expression
+	Value Expression // nil for a bare declaration, x: int
+}
+
+func (as *AnnotatedAssignStatement) statementNode()       {}
+func (as *AnnotatedAssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AnnotatedAssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(as.Name.String())
+	out.WriteString(token.Colon + " ")
+	out.WriteString(as.Type.String())
+
+	if as.Value != nil {
+		out.WriteString(" " + token.Assign + " ")
+		out.WriteString(as.Value.String())
+	}
+
+	return out.String()
+}
+
+type AugmentedAssignStatement struct {
+	Token    token.Token // the augmented assignment token, e.g. +=
+	Target   Expression
+	Operator string // the binary operator applied, e.g. +
+	Value    Expression
+}
+
+func (as *AugmentedAssignStatement) statementNode()       {}
+func (as *AugmentedAssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AugmentedAssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(as.Target.String())
+	out.WriteString(" " + as.TokenLiteral() + " ")
+	out.WriteString(as.Value.String())
+
+	return out.String()
+}
+
+type GlobalStatement struct {
+	Token token.Token // the 'global' token
+	Names []*Identifier
+}
+
+func (gs *GlobalStatement) statementNode()       {}
+func (gs *GlobalStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GlobalStatement) String() string {
+	var names []string
+	for _, name := range gs.Names {
+		names = append(names, name.String())
+	}
+
+	return gs.TokenLiteral() + " " + strings.Join(names, token.Comma+" ")
+}
+
+type NonlocalStatement struct {
+	Token token.Token // the 'nonlocal' token
+	Names []*Identifier
+}
+
+func (ns *NonlocalStatement) statementNode()       {}
+func (ns *NonlocalStatement) TokenLiteral() string { return ns.Token.Literal }
+func (ns *NonlocalStatement) String() string {
+	var names []string
+	for _, name := range ns.Names {
+		names = append(names, name.String())
+	}
+
+	return ns.TokenLiteral() + " " + strings.Join(names, token.Comma+" ")
+}
+
+type ClassStatement struct {
+	Token token.Token // the 'class' token
+	Name  *Identifier
+	Base  Expression // a class name, e.g. Shape or shapes.Shape, nil for a class without a base class
+	Body  *BlockStatement
+}
+
+func (cs *ClassStatement) statementNode()       {}
+func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ClassStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(cs.TokenLiteral() + " ")
+	out.WriteString(cs.Name.String())
+	if cs.Base != nil {
+		out.WriteString(token.LeftParen + cs.Base.String() + token.RightParen)
+	}
+	out.WriteString(token.Colon)
+	out.WriteString(token.ENDL)
+	out.WriteString(cs.Body.String())
+
+	return out.String()
+}
+
+type TryStatement struct {
+	Token    token.Token // the 'try' token
+	Body     *BlockStatement
+	Handlers []*ExceptClause
+	Else     *BlockStatement // nil without an else clause
+	Finally  *BlockStatement // nil without a finally clause
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+
+	// the clauses are indented like the try statement, one level less than its body
+	indent := token.ENDL + strings.Repeat("\t", ts.Body.Level-1)
+
+	out.WriteString(ts.TokenLiteral() + token.Colon + token.ENDL)
+	out.WriteString(ts.Body.String())
+	for _, handler := range ts.Handlers {
+		out.WriteString(indent + handler.String())
+	}
+	if ts.Else != nil {
+		out.WriteString(indent + "else" + token.Colon + token.ENDL)
+		out.WriteString(ts.Else.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString(indent + token.Finally + token.Colon + token.ENDL)
+		out.WriteString(ts.Finally.String())
+	}
+
+	return out.String()
+}
+
+type ExceptClause struct {
+	Token token.Token // the 'except' token
+	Type  Expression  // nil for a bare except, a tuple to handle several types
+	Name  *Identifier // nil without 'as name'
+	Body  *BlockStatement
+}
+
+func (ec *ExceptClause) TokenLiteral() string { return ec.Token.Literal }
+func (ec *ExceptClause) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ec.TokenLiteral())
+	if ec.Type != nil {
+		out.WriteString(" " + ec.Type.String())
+	}
+	if ec.Name != nil {
+		out.WriteString(" " + token.As + " " + ec.Name.String())
+	}
+	out.WriteString(token.Colon + token.ENDL)
+	out.WriteString(ec.Body.String())
+
+	return out.String()
+}
+
+type RaiseStatement struct {
+	Token     token.Token // the 'raise' token
+	Exception Expression  // nil for a bare raise
+}
+
+func (rs *RaiseStatement) statementNode()       {}
+func (rs *RaiseStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *RaiseStatement) String() string {
+	if rs.Exception == nil {
+		return rs.TokenLiteral()
+	}
+
+	return rs.TokenLiteral() + " " + rs.Exception.String()
+}
+
+// A name an import statement binds, e.g. 'path as p'
+type ImportName struct {
+	Name  *Identifier
+	Alias *Identifier // nil when the name is bound as is
+}
+
+// The name the import binds in the importing module
+func (in *ImportName) Bound() string {
+	if in.Alias != nil {
+		return in.Alias.Value
+	}
+
+	return in.Name.Value
+}
+
+func (in *ImportName) String() string {
+	if in.Alias == nil {
+		return in.Name.String()
+	}
+
+	return in.Name.String() + " " + token.As + " " + in.Alias.String()
+}
+
+func importNames(names []*ImportName) string {
+	var out []string
+	for _, name := range names {
+		out = append(out, name.String())
+	}
+
+	return strings.Join(out, token.Comma+" ")
+}
+
+type ImportStatement struct {
+	Token   token.Token // the 'import' token
+	Modules []*ImportName
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string {
+	return is.TokenLiteral() + " " + importNames(is.Modules)
+}
+
+type FromImportStatement struct {
+	Token  token.Token // the 'from' token
+	Module *Identifier
+	Names  []*ImportName
+}
+
+func (fs *FromImportStatement) statementNode()       {}
+func (fs *FromImportStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FromImportStatement) String() string {
+	return fs.TokenLiteral() + " " + fs.Module.String() + " " + token.Import + " " + importNames(fs.Names)
+}
+
+// extern def name(params) -> type declares a C function defined outside the
+// program, a trailing ... parameter makes it variadic
+type ExternStatement struct {
+	Token      token.Token // the 'extern' token
+	Name       *Identifier
+	Parameters []*FunctionParameter
+	ReturnType Expression
+	Variadic   bool
+}
+
+func (es *ExternStatement) statementNode()       {}
+func (es *ExternStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExternStatement) String() string {
+	var params []string
+	for _, p := range es.Parameters {
+		params = append(params, p.String())
+	}
+	if es.Variadic {
+		params = append(params, token.Ellipsis)
+	}
+
+	return es.TokenLiteral() + " def " + es.Name.String() + token.LeftParen + strings.Join(params, token.Comma+" ") + token.RightParen + " " + token.Arrow + " " + es.ReturnType.String()
+}
+
+type PassStatement struct {
+	Token token.Token // the 'pass' token
+}
+
+func (ps *PassStatement) statementNode()       {}
+func (ps *PassStatement) TokenLiteral() string { return ps.Token.Literal }
+func (ps *PassStatement) String() string       { return ps.TokenLiteral() }
+
+type AssertStatement struct {
+	Token     token.Token // the 'assert' token
+	Condition Expression
+	Message   Expression // nil when the assert has no message
+}
+
+func (as *AssertStatement) statementNode()       {}
+func (as *AssertStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssertStatement) String() string {
+	if as.Message == nil {
+		return as.TokenLiteral() + " " + as.Condition.String()
+	}
+
+	return as.TokenLiteral() + " " + as.Condition.String() + ", " + as.Message.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
 	Operator string
@@ -188,6 +482,27 @@ func (we *WhileExpression) String() string {
 	return out.String()
 }
 
+type LambdaExpression struct {
+	Token      token.Token // the 'lambda' token
+	Parameters []*Identifier
+	Body       Expression
+}
+
+func (le *LambdaExpression) expressionNode()      {}
+func (le *LambdaExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LambdaExpression) String() string {
+	var params []string
+	for _, param := range le.Parameters {
+		params = append(params, param.String())
+	}
+
+	if len(params) == 0 {
+		return le.TokenLiteral() + token.Colon + " " + le.Body.String()
+	}
+
+	return le.TokenLiteral() + " " + strings.Join(params, token.Comma+" ") + token.Colon + " " + le.Body.String()
+}
+
 type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
@@ -259,7 +574,7 @@ func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 type FunctionParameter struct {
 	Token        token.Token
-	Type         *Identifier
+	Type         Expression
 	DefaultValue *ExpressionStatement
 }
 
@@ -269,8 +584,12 @@ func (fp *FunctionParameter) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(fp.TokenLiteral())
-	out.WriteString(token.Colon + " ")
-	out.WriteString(fp.Type.String())
+
+	// the self parameter of a method has no annotation
+	if fp.Type != nil {
+		out.WriteString(token.Colon + " ")
+		out.WriteString(fp.Type.String())
+	}
 
 	if fp.DefaultValue != nil {
 		out.WriteString(" " + token.Assign + " ")
@@ -282,8 +601,9 @@ func (fp *FunctionParameter) String() string {
 
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
+	Decorators []*Identifier
 	Parameters []*FunctionParameter
-	ReturnType *Identifier
+	ReturnType Expression
 	Body       *BlockStatement
 }
 
@@ -297,6 +617,10 @@ func (fl *FunctionLiteral) String() string {
 		params = append(params, p.String())
 	}
 
+	for _, decorator := range fl.Decorators {
+		out.WriteString(token.At + decorator.String() + token.ENDL)
+	}
+
 	out.WriteString("def ")
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString(token.LeftParen)
@@ -304,7 +628,7 @@ func (fl *FunctionLiteral) String() string {
 	out.WriteString(token.RightParen)
 
 	if fl.ReturnType != nil {
-		out.WriteString(" " + token.Arrow + " " + fl.ReturnType.Value)
+		out.WriteString(" " + token.Arrow + " " + fl.ReturnType.String())
 	}
 
 	out.WriteString(token.Colon)
@@ -357,9 +681,51 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+type TupleLiteral struct {
+	Token    token.Token // the first token of the tuple
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string {
+	var out bytes.Buffer
+
+	var elements []string
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+
+	out.WriteString(token.LeftParen)
+	out.WriteString(strings.Join(elements, token.Comma+" "))
+	if len(tl.Elements) == 1 {
+		out.WriteString(token.Comma)
+	}
+	out.WriteString(token.RightParen)
+
+	return out.String()
+}
+
+type AttributeExpression struct {
+	Token     token.Token // The . token
+	Object    Expression
+	Attribute *Identifier
+}
+
+func (ae *AttributeExpression) expressionNode()      {}
+func (ae *AttributeExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AttributeExpression) String() string {
+	return ae.Object.String() + token.Dot + ae.Attribute.String()
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
 type HashLiteral struct {
 	Token token.Token // The '{' token
-	Pairs map[Expression]Expression
+	Pairs []HashPair  // in source order
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -368,8 +734,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+token.Colon+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+token.Colon+" "+pair.Value.String())
 	}
 
 	out.WriteString(token.LeftBrace)
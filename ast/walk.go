@@ -0,0 +1,145 @@
+package ast
+
+// Inspect traverses the tree rooted at node in depth first order and calls f
+// for every node, the children of a node are skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	for _, child := range children(node) {
+		Inspect(child, f)
+	}
+}
+
+// The direct children of a node, in source order
+func children(node Node) []Node {
+	var nodes []Node
+	add := func(children ...Node) {
+		nodes = append(nodes, children...)
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, stmt := range node.Statements {
+			add(stmt)
+		}
+	case *BlockStatement:
+		for _, stmt := range node.Statements {
+			add(stmt)
+		}
+	case *ReturnStatement:
+		if node.ReturnValue != nil {
+			add(node.ReturnValue)
+		}
+	case *ExpressionStatement:
+		if node.Expression != nil {
+			add(node.Expression)
+		}
+	case *AssignStatement:
+		for _, target := range node.Targets {
+			add(target)
+		}
+		add(node.Value)
+	case *AnnotatedAssignStatement:
+		add(node.Name, node.Type)
+		if node.Value != nil {
+			add(node.Value)
+		}
+	case *AugmentedAssignStatement:
+		add(node.Target, node.Value)
+	case *GlobalStatement:
+		for _, name := range node.Names {
+			add(name)
+		}
+	case *NonlocalStatement:
+		for _, name := range node.Names {
+			add(name)
+		}
+	case *ClassStatement:
+		add(node.Name)
+		if node.Base != nil {
+			add(node.Base)
+		}
+		add(node.Body)
+	case *TryStatement:
+		add(node.Body)
+		for _, handler := range node.Handlers {
+			add(handler)
+		}
+		if node.Else != nil {
+			add(node.Else)
+		}
+		if node.Finally != nil {
+			add(node.Finally)
+		}
+	case *ExceptClause:
+		if node.Type != nil {
+			add(node.Type)
+		}
+		if node.Name != nil {
+			add(node.Name)
+		}
+		add(node.Body)
+	case *RaiseStatement:
+		if node.Exception != nil {
+			add(node.Exception)
+		}
+	case *AssertStatement:
+		add(node.Condition)
+		if node.Message != nil {
+			add(node.Message)
+		}
+	case *PrefixExpression:
+		add(node.Right)
+	case *InfixExpression:
+		add(node.Left, node.Right)
+	case *IfExpression:
+		add(node.Condition, node.Consequence)
+		if node.Alternative != nil {
+			add(node.Alternative)
+		}
+	case *WhileExpression:
+		add(node.Condition, node.Consequence)
+	case *CallExpression:
+		add(node.Function)
+		for _, arg := range node.Arguments {
+			add(arg)
+		}
+	case *FunctionParameter:
+		if node.Type != nil {
+			add(node.Type)
+		}
+		if node.DefaultValue != nil {
+			add(node.DefaultValue)
+		}
+	case *LambdaExpression:
+		for _, param := range node.Parameters {
+			add(param)
+		}
+		add(node.Body)
+	case *FunctionLiteral:
+		for _, param := range node.Parameters {
+			add(param)
+		}
+		add(node.ReturnType, node.Body)
+	case *ArrayLiteral:
+		for _, el := range node.Elements {
+			add(el)
+		}
+	case *TupleLiteral:
+		for _, el := range node.Elements {
+			add(el)
+		}
+	case *IndexExpression:
+		add(node.Left, node.Index)
+	case *AttributeExpression:
+		add(node.Object, node.Attribute)
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			add(pair.Key, pair.Value)
+		}
+	}
+
+	return nodes
+}
@@ -1,8 +1,11 @@
 #! /usr/bin/bash
 
-go run main.go test.sp
+# The optimisation level, 0 keeps the code easy to debug
+OPT=${OPT:-2}
 
-llc -O=3 --filetype=obj code.ll
+go run main.go -O $OPT test.sp
+
+llc -O=$OPT --filetype=obj code.ll
 
 clang code.o -o run
 
@@ -13,4 +16,4 @@ chmod +x run
 
 # Run
 ./run
-echo $?
\ No newline at end of file
+echo $?
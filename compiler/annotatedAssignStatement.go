@@ -0,0 +1,147 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileAnnotatedAssignStatement(assignStat *ast.AnnotatedAssignStatement) error {
+	varName := assignStat.Name.TokenLiteral()
+
+	typ, err := c.resolveType(assignStat.Type, assignStat.Token)
+	if err != nil {
+		return err
+	}
+
+	if typ.Equal(None) {
+		return newError(fmt.Sprintf("variable %s can not be declared as None", varName), TypeError, assignStat.Token)
+	}
+
+	// Re-declaring a variable is allowed only with the same type
+	vr, declared := c.binding().vars[varName]
+	if declared && !vr.Type().(*types.PointerType).ElemType.Equal(typ) {
+		return newError(fmt.Sprintf("variable %s is already declared with type %s", varName, c.compiler.displayType(vr.Type().(*types.PointerType).ElemType)), TypeError, assignStat.Token)
+	}
+
+	if !declared {
+		vr = c.newVariable(varName, typ)
+		c.createVar(varName, vr)
+	}
+
+	if assignStat.Value == nil {
+		if !declared {
+			c.NewStore(constant.NewZeroInitializer(typ), vr)
+		}
+		return nil
+	}
+
+	reg, err := c.compileExpected(assignStat.Value, typ, assignStat.Token)
+	if err != nil {
+		return err
+	}
+	if err := checkHasValue(reg, varName, assignStat.Token); err != nil {
+		return err
+	}
+
+	if !reg.Type().Equal(typ) {
+		return newError(fmt.Sprintf("can not assign type %s into %s", c.compiler.displayType(reg.Type()), varName), TypeError, assignStat.Token)
+	}
+
+	c.store(reg, vr)
+	if isSome(reg) {
+		c.narrow(varName, true)
+	} else if isOptional(typ) {
+		c.widen(varName)
+	}
+	return nil
+}
+
+// Compile an expression whose type is known from an annotation, literals are
+// converted into that type and any other value is left for the caller to check
+func (c *context) compileExpected(exp ast.Expression, typ types.Type, tok token.Token) (value.Value, error) {
+	if arrayLit, ok := exp.(*ast.ArrayLiteral); ok {
+		if elem, ok := c.compiler.listElem(typ); ok {
+			if err := c.compileListLiteral(arrayLit, elem); err != nil {
+				return nil, err
+			}
+			return c.popReg(), nil
+		}
+	}
+
+	if hashLit, ok := exp.(*ast.HashLiteral); ok {
+		if keyTyp, valTyp, ok := c.compiler.dictElems(typ); ok {
+			if err := c.compileDictLiteral(hashLit, keyTyp, valTyp); err != nil {
+				return nil, err
+			}
+			return c.popReg(), nil
+		}
+	}
+
+	if tupleLit, ok := exp.(*ast.TupleLiteral); ok {
+		if elemTyps, ok := tupleElems(typ); ok && len(elemTyps) == len(tupleLit.Elements) {
+			if err := c.compileTuple(tupleLit, elemTyps); err != nil {
+				return nil, err
+			}
+			return c.popReg(), nil
+		}
+	}
+
+	if lambda, ok := exp.(*ast.LambdaExpression); ok {
+		return c.compileLambda(lambda, typ)
+	}
+
+	if err := c.compile(exp); err != nil {
+		return nil, err
+	}
+
+	reg := c.upcast(c.popReg(), typ)
+	if reg == noneValue && typ.Equal(Ptr) {
+		// the null pointer of C
+		return constant.NewNull(Ptr), nil
+	}
+	if reg == noneValue {
+		return nil, newError(fmt.Sprintf("can not use None as %s, only Optional types can be None", c.compiler.displayType(typ)), TypeError, tok)
+	}
+	if err := checkNotNone(reg, typ, exp.String(), tok); err != nil {
+		return nil, err
+	}
+	return convertLiteral(reg, exp, typ, tok)
+}
+
+// Convert a literal into the expected type when no precision is lost
+func convertLiteral(reg value.Value, exp ast.Expression, typ types.Type, tok token.Token) (value.Value, error) {
+	if reg.Type().Equal(typ) {
+		return reg, nil
+	}
+
+	if intVal, ok := intLiteralValue(exp); ok && types.IsFloat(typ) {
+		floatVal := float32(intVal)
+		if math.Abs(float64(floatVal)) < math.MaxInt64 && int64(floatVal) == intVal {
+			return constant.NewFloat(typ.(*types.FloatType), float64(floatVal)), nil
+		}
+
+		return nil, newError(fmt.Sprintf("can not convert %d into float without losing precision", intVal), TypeError, tok)
+	}
+
+	return reg, nil
+}
+
+// Get the value of an integer literal, including a negated one
+func intLiteralValue(exp ast.Expression) (int64, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return exp.Value, true
+	case *ast.PrefixExpression:
+		if lit, ok := exp.Right.(*ast.IntegerLiteral); ok && exp.Operator == token.Minus {
+			return -lit.Value, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileArrayLiteral(arrayLit *ast.ArrayLiteral) error {
+	return c.compileListLiteral(arrayLit, nil)
+}
+
+// Compile a list literal, the element type is taken from the first element
+// unless it is known from an annotation
+func (c *context) compileListLiteral(arrayLit *ast.ArrayLiteral, elemTyp types.Type) error {
+	elements := make([]value.Value, 0, len(arrayLit.Elements))
+	for i, element := range arrayLit.Elements {
+		reg, err := c.compileElement(element, elemTyp, arrayLit.Token)
+		if err != nil {
+			return err
+		}
+
+		if elemTyp == nil {
+			elemTyp = reg.Type()
+		}
+
+		if !reg.Type().Equal(elemTyp) {
+			return newError(fmt.Sprintf("can not add type %s into a list of %s", c.compiler.displayType(reg.Type()), c.compiler.displayType(elemTyp)), TypeError, arrayLit.Token)
+		}
+		elements = append(elements, c.hold(reg, arrayLit.Elements[i+1:]...))
+	}
+
+	if elemTyp == nil {
+		return newError("can not infer the type of an empty list, annotate it e.g. xs: list[int] = []", TypeError, arrayLit.Token)
+	}
+
+	list := c.temp(c.NewCall(c.compiler.listNewFunc(elemTyp), constant.NewInt(Int, int64(len(elements)))))
+	appendFn := c.compiler.listAppendFunc(elemTyp)
+	for _, element := range elements {
+		c.NewCall(appendFn, list, element)
+	}
+
+	c.pushReg(list)
+	return nil
+}
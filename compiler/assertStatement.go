@@ -0,0 +1,44 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/value"
+)
+
+// An assert raises AssertionError when its condition is false, the message
+// is only evaluated then. Asserts are not compiled with Options.NoAsserts
+func (c *context) compileAssertStatement(assertStat *ast.AssertStatement) error {
+	if c.compiler.options.NoAsserts {
+		return nil
+	}
+
+	if err := c.compile(assertStat.Condition); err != nil {
+		return err
+	}
+	cond := c.popReg()
+	if !cond.Type().Equal(Bool) {
+		return newError(fmt.Sprintf("assert condition must be a bool, got %s", c.compiler.displayType(cond.Type())), TypeError, assertStat.Token)
+	}
+
+	fail := c.newContext("assert.fail")
+	ok := c.newContext("assert.ok")
+	c.NewCondBr(cond, ok.Block, fail.Block)
+	fail.temps = c.temps
+
+	var msg value.Value = c.compiler.cString("")
+	if assertStat.Message != nil {
+		if err := fail.compile(assertStat.Message); err != nil {
+			return err
+		}
+		msg = fail.popReg()
+		if !msg.Type().Equal(Str) {
+			return newError(fmt.Sprintf("assert message must be a str, got %s", c.compiler.displayType(msg.Type())), TypeError, assertStat.Token)
+		}
+	}
+
+	fail.raiseError("AssertionError", msg, assertStat.Token)
+	c.Block = ok.Block
+	return nil
+}
@@ -0,0 +1,166 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileAssignStatement(assignStat *ast.AssignStatement) error {
+	// Evaluate the value once before assigning it into the targets,
+	// a tuple literal is evaluated as a whole so a, b = b, a works
+	if err := c.compile(assignStat.Value); err != nil {
+		return err
+	}
+	reg := c.popReg()
+
+	for _, target := range assignStat.Targets {
+		if err := c.assign(target, reg, assignStat.Value, assignStat.Token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Assign every element of a tuple value into the matching target of a, b = ...
+func (c *context) unpackAssign(targets *ast.TupleLiteral, tuple value.Value, tok token.Token) error {
+	elemTyps, ok := tupleElems(tuple.Type())
+	if !ok {
+		return newError(fmt.Sprintf("can not unpack non tuple value into %s", targets.String()), TypeError, tok)
+	}
+
+	if len(elemTyps) != len(targets.Elements) {
+		return newError(fmt.Sprintf("can not unpack %d values into %d targets", len(elemTyps), len(targets.Elements)), TypeError, tok)
+	}
+
+	for i, target := range targets.Elements {
+		if err := c.assign(target, c.NewExtractValue(tuple, uint64(i)), nil, tok); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Store value into an assignment target, creating the variable on first assignment.
+// exp is the expression the value was compiled from, nil when it has none
+func (c *context) assign(target ast.Expression, reg value.Value, exp ast.Expression, tok token.Token) error {
+	if err := checkHasValue(reg, target.String(), tok); err != nil {
+		return err
+	}
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		return c.assignIdentifier(target, reg, exp, tok)
+	case *ast.TupleLiteral:
+		return c.unpackAssign(target, reg, tok)
+	case *ast.IndexExpression:
+		if err := c.compile(target.Left); err != nil {
+			return err
+		}
+		container := c.popReg()
+
+		// Assigning into an object index calls its __setitem__ method
+		if _, ok := c.compiler.classOf(container.Type()); ok {
+			if err := c.compile(target.Index); err != nil {
+				return err
+			}
+			_, ok, err := c.callSpecialMethod(container, "__setitem__", []value.Value{c.popReg(), reg}, tok)
+			if err == nil && !ok {
+				err = newError(fmt.Sprintf("type %s does not support item assignment", c.compiler.displayType(container.Type())), TypeError, tok)
+			}
+			return err
+		}
+
+		ptr, err := c.indexAddress(container, target, true)
+		if err != nil {
+			return err
+		}
+
+		return c.assignAddress(ptr, reg, exp, target, tok)
+	case *ast.AttributeExpression:
+		ptr, err := c.compileAttributeAddress(target)
+		if err != nil {
+			return err
+		}
+
+		return c.assignAddress(ptr, reg, exp, target, tok)
+	default:
+		return newError(fmt.Sprintf("can not assign into %s", target.String()), TypeError, tok)
+	}
+}
+
+func (c *context) assignIdentifier(identifier *ast.Identifier, reg value.Value, exp ast.Expression, tok token.Token) error {
+	varName := identifier.TokenLiteral()
+
+	vr := c.getVar(varName)
+	if vr == nil && reg == noneValue {
+		return newError(fmt.Sprintf("can not infer the type of None, declare the variable e.g. %s: Optional[T] = None", varName), TypeError, tok)
+	}
+	if vr == nil {
+		// Create new variable
+		vr := c.newVariable(varName, reg.Type())
+		c.store(reg, vr)
+		c.createVar(varName, vr)
+		return nil
+	}
+
+	if err := c.checkGlobalWrite(varName, vr, tok); err != nil {
+		return err
+	}
+
+	// Check if reg type is identical to var type
+	if ptrTyp, ok := vr.Type().(*types.PointerType); ok {
+		converted, err := convertLiteral(c.upcast(reg, ptrTyp.ElemType), exp, ptrTyp.ElemType, tok)
+		if err != nil {
+			return err
+		}
+		reg = converted
+	}
+	if !types.IsPointer(vr.Type()) || !vr.Type().(*types.PointerType).ElemType.Equal(reg.Type()) {
+		return newError(fmt.Sprintf("can not assign type %s into %s", c.compiler.displayType(reg.Type()), varName), TypeError, tok)
+	}
+	if err := checkNotNone(reg, vr.Type().(*types.PointerType).ElemType, "the value assigned to "+varName, tok); err != nil {
+		return err
+	}
+	c.store(reg, vr)
+	if isSome(reg) {
+		c.narrow(varName, true)
+	} else if isOptional(reg.Type()) {
+		c.widen(varName)
+	}
+
+	return nil
+}
+
+// Store into a list item, dict value or field
+func (c *context) assignAddress(ptr value.Value, reg value.Value, exp ast.Expression, target ast.Expression, tok token.Token) error {
+	typ := ptr.Type().(*types.PointerType).ElemType
+
+	reg, err := convertLiteral(c.upcast(reg, typ), exp, typ, tok)
+	if err != nil {
+		return err
+	}
+	if !typ.Equal(reg.Type()) {
+		return newError(fmt.Sprintf("can not assign type %s into %s", c.compiler.displayType(reg.Type()), target.String()), TypeError, tok)
+	}
+	if err := checkNotNone(reg, typ, "the value assigned to "+target.String(), tok); err != nil {
+		return err
+	}
+
+	c.store(reg, ptr)
+	return nil
+}
+
+// A call of a function returning None has no value to store
+func checkHasValue(reg value.Value, target string, tok token.Token) error {
+	if reg.Type().Equal(None) {
+		return newError(fmt.Sprintf("can not assign the result of a function returning None to %s", target), TypeError, tok)
+	}
+
+	return nil
+}
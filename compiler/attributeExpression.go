@@ -0,0 +1,64 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileAttributeExpression(attr *ast.AttributeExpression) error {
+	// A function of an imported module used as a value
+	if mod, ok := c.moduleOf(attr.Object); ok {
+		if fn, ok := mod.functions[attr.Attribute.Value]; ok {
+			c.pushReg(c.newCallable(c.compiler.thunkFunc(fn), constant.NewNull(I8Ptr)))
+			return nil
+		}
+	}
+
+	ptr, err := c.compileAttributeAddress(attr)
+	if err != nil {
+		return err
+	}
+
+	fieldTyp := ptr.Type().(*types.PointerType).ElemType
+	c.pushReg(c.NewLoad(fieldTyp, ptr))
+	return nil
+}
+
+// Compile the address of an object field, or of a module level variable of
+// an imported module, for reading or assigning it
+func (c *context) compileAttributeAddress(attr *ast.AttributeExpression) (value.Value, error) {
+	if mod, ok := c.moduleOf(attr.Object); ok {
+		global, ok := mod.globals[attr.Attribute.Value]
+		if !ok {
+			return nil, newError(fmt.Sprintf("module '%s' has no attribute '%s'", mod.name, attr.Attribute.Value), NameError, attr.Attribute.Token)
+		}
+		return global, nil
+	}
+
+	if err := c.compile(attr.Object); err != nil {
+		return nil, err
+	}
+	object := c.popReg()
+	if err := checkUsedNotNone(object, attr.Object, attr.Token); err != nil {
+		return nil, err
+	}
+
+	cls, ok := c.compiler.classOf(object.Type())
+	if !ok {
+		return nil, newError(fmt.Sprintf("type %s has no attribute '%s'", c.compiler.displayType(object.Type()), attr.Attribute.Value), NameError, attr.Attribute.Token)
+	}
+
+	index, _, ok := cls.field(attr.Attribute.Value)
+	if !ok {
+		if _, ok := cls.methods[attr.Attribute.Value]; ok {
+			return nil, newError(fmt.Sprintf("method '%s' of class '%s' can only be called", attr.Attribute.Value, cls.name), UnsupportedError, attr.Attribute.Token)
+		}
+		return nil, newError(fmt.Sprintf("'%s' object has no attribute '%s'", cls.name, attr.Attribute.Value), NameError, attr.Attribute.Token)
+	}
+
+	return objectField(c.Block, object, index), nil
+}
@@ -0,0 +1,132 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileAugmentedAssignStatement(assignStat *ast.AugmentedAssignStatement) error {
+	if indexExp, ok := assignStat.Target.(*ast.IndexExpression); ok {
+		return c.compileAugmentedIndex(assignStat, indexExp)
+	}
+
+	ptr, err := c.compileAugmentedTarget(assignStat)
+	if err != nil {
+		return err
+	}
+
+	return c.compileAugmentedStore(assignStat, ptr, nil)
+}
+
+// Augmented assignment into an index, the container and the index are
+// evaluated once, an object is read and written through __getitem__ and __setitem__
+func (c *context) compileAugmentedIndex(assignStat *ast.AugmentedAssignStatement, indexExp *ast.IndexExpression) error {
+	if err := c.compile(indexExp.Left); err != nil {
+		return err
+	}
+	container := c.hold(c.popReg(), indexExp.Index, assignStat.Value)
+
+	if _, ok := c.compiler.classOf(container.Type()); !ok {
+		key, err := c.compileKey(container, indexExp)
+		if err != nil {
+			return err
+		}
+		ptr := c.elementAddress(container, key, false, indexExp.Token)
+
+		// The right side can grow the container and move its elements, the
+		// result is stored at the element looked up again
+		return c.compileAugmentedStore(assignStat, ptr, func() value.Value {
+			return c.elementAddress(container, key, true, indexExp.Token)
+		})
+	}
+
+	if err := c.compile(indexExp.Index); err != nil {
+		return err
+	}
+	index := c.popReg()
+
+	current, ok, err := c.callSpecialMethod(container, "__getitem__", []value.Value{index}, assignStat.Token)
+	if err == nil && !ok {
+		err = newError(fmt.Sprintf("type %s is not subscriptable", c.compiler.displayType(container.Type())), TypeError, assignStat.Token)
+	}
+	if err != nil {
+		return err
+	}
+
+	reg, err := c.compileExpected(assignStat.Value, current.Type(), assignStat.Token)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.compileBinaryOperation(assignStat.Operator, current, reg, assignStat.Token)
+	if err != nil {
+		return err
+	}
+
+	_, ok, err = c.callSpecialMethod(container, "__setitem__", []value.Value{index, res}, assignStat.Token)
+	if err == nil && !ok {
+		err = newError(fmt.Sprintf("type %s does not support item assignment", c.compiler.displayType(container.Type())), TypeError, assignStat.Token)
+	}
+	return err
+}
+
+// Apply the operator on the value stored at ptr and store the result back.
+// As in Python the value is loaded before the right side runs, relocate looks
+// the address up again when the right side can run code
+func (c *context) compileAugmentedStore(assignStat *ast.AugmentedAssignStatement, ptr value.Value, relocate func() value.Value) error {
+	targetTyp := ptr.Type().(*types.PointerType).ElemType
+	current := c.hold(c.NewLoad(targetTyp, ptr), assignStat.Value)
+
+	reg, err := c.compileExpected(assignStat.Value, targetTyp, assignStat.Token)
+	if err != nil {
+		return err
+	}
+	if relocate != nil && mayRunCode([]ast.Expression{assignStat.Value}) {
+		ptr = relocate()
+	}
+
+	res, err := c.compileBinaryOperation(assignStat.Operator, current, reg, assignStat.Token)
+	if err != nil {
+		return err
+	}
+
+	// Check if result type is identical to target type
+	if !res.Type().Equal(targetTyp) {
+		return newError(fmt.Sprintf("can not assign type %s into %s", c.compiler.displayType(res.Type()), assignStat.Target.String()), TypeError, assignStat.Token)
+	}
+	c.store(res, ptr)
+
+	return nil
+}
+
+// Get the address the augmented assignment loads from and stores into
+func (c *context) compileAugmentedTarget(assignStat *ast.AugmentedAssignStatement) (value.Value, error) {
+	switch target := assignStat.Target.(type) {
+	case *ast.Identifier:
+		varName := target.TokenLiteral()
+
+		vr, err := c.lookupVar(varName)
+		if err != nil {
+			return nil, err
+		}
+		if vr == nil {
+			return nil, newError(fmt.Sprintf("variable %s is not defined", varName), NameError, target.Token)
+		}
+
+		if err := c.checkGlobalWrite(varName, vr, assignStat.Token); err != nil {
+			return nil, err
+		}
+
+		if !types.IsPointer(vr.Type()) {
+			return nil, newError(fmt.Sprintf("can not assign into %s", varName), TypeError, assignStat.Token)
+		}
+		return vr, nil
+	case *ast.AttributeExpression:
+		return c.compileAttributeAddress(target)
+	default:
+		return nil, newError(fmt.Sprintf("augmented assignment into %s is not supported", assignStat.Target.String()), UnsupportedError, assignStat.Token)
+	}
+}
@@ -6,11 +6,21 @@ import (
 
 func (c *context) compileBlockStatement(blockStat *ast.BlockStatement) error {
 	for _, statement := range blockStat.Statements {
+		c.skipTerminated()
 		err := c.compile(statement)
 		if err != nil {
 			return err
 		}
+		c.endStatement()
 	}
 
 	return nil
 }
+
+// Statements after a return or raise are compiled into a block nothing branches to,
+// the optimizer removes it
+func (c *context) skipTerminated() {
+	if c.Term != nil {
+		c.Block = c.newContext("unreachable").Block
+	}
+}
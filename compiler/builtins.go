@@ -0,0 +1,190 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+)
+
+// Compile a call to a builtin function, reports false if name is not a builtin
+func (c *context) compileBuiltinCall(name string, callExp *ast.CallExpression) (bool, error) {
+	switch name {
+	case "len":
+		return true, c.compileLen(callExp)
+	case "isinstance":
+		return true, c.compileIsinstance(callExp)
+	case "print":
+		return true, c.compilePrint(callExp)
+	default:
+		return false, nil
+	}
+}
+
+func (c *context) compileLen(callExp *ast.CallExpression) error {
+	if len(callExp.Arguments) != 1 {
+		return newError(fmt.Sprintf("len() takes exactly one argument (%d given)", len(callExp.Arguments)), TypeError, callExp.Token)
+	}
+
+	if err := c.compile(callExp.Arguments[0]); err != nil {
+		return err
+	}
+	arg := c.popReg()
+
+	if _, ok := c.compiler.listElem(arg.Type()); ok {
+		c.pushReg(c.NewLoad(Int, listField(c.Block, arg, listLen)))
+		return nil
+	}
+
+	if _, _, ok := c.compiler.dictElems(arg.Type()); ok {
+		c.pushReg(c.NewLoad(Int, dictField(c.Block, arg, dictLen)))
+		return nil
+	}
+
+	if res, ok, err := c.callSpecialMethod(arg, "__len__", nil, callExp.Token); ok || err != nil {
+		c.pushReg(res)
+		return err
+	}
+
+	if elemTyps, ok := tupleElems(arg.Type()); ok {
+		c.pushReg(constant.NewInt(Int, int64(len(elemTyps))))
+		return nil
+	}
+
+	return newError(fmt.Sprintf("object of type %s has no len()", c.compiler.displayType(arg.Type())), TypeError, callExp.Token)
+}
+
+// Compile a method call on an object or a builtin type, e.g. xs.append(1)
+func (c *context) compileMethodCall(attr *ast.AttributeExpression, callExp *ast.CallExpression) error {
+	if superCall, ok := attr.Object.(*ast.CallExpression); ok && superCall.Function.TokenLiteral() == "super" {
+		return c.compileSuperCall(attr, callExp)
+	}
+
+	if mod, ok := c.moduleOf(attr.Object); ok {
+		return c.compileModuleCall(mod, attr, callExp)
+	}
+
+	if err := c.compile(attr.Object); err != nil {
+		return err
+	}
+	object := c.hold(c.popReg(), callExp.Arguments...)
+	if err := checkUsedNotNone(object, attr.Object, attr.Token); err != nil {
+		return err
+	}
+
+	if cls, ok := c.compiler.classOf(object.Type()); ok {
+		methodName := attr.Attribute.Value
+		method, ok := cls.method(methodName)
+		if !ok {
+			// A field holding a function value is called like a method
+			if index, typ, ok := cls.field(methodName); ok {
+				if _, ok := c.compiler.callableSig(typ); ok {
+					callee := c.NewLoad(typ, objectField(c.Block, object, index))
+					return c.compileIndirectCall(callee, cls.name+"."+methodName, callExp)
+				}
+			}
+			return newError(fmt.Sprintf("'%s' object has no method '%s'", cls.name, methodName), NameError, attr.Attribute.Token)
+		}
+
+		args, err := c.compileArguments(method.Params[1:], cls.name+"."+methodName, callExp.Arguments, callExp.Token)
+		if err != nil {
+			return err
+		}
+
+		if methodName == "__init__" {
+			c.pushReg(c.callMethodStatic(object, method, args))
+		} else {
+			c.pushReg(c.callMethod(object, cls, methodName, args))
+		}
+		return nil
+	}
+
+	if elemTyp, ok := c.compiler.listElem(object.Type()); ok && attr.Attribute.Value == "append" {
+		if len(callExp.Arguments) != 1 {
+			return newError(fmt.Sprintf("append() takes exactly one argument (%d given)", len(callExp.Arguments)), TypeError, callExp.Token)
+		}
+
+		val, err := c.compileExpected(callExp.Arguments[0], elemTyp, callExp.Token)
+		if err != nil {
+			return err
+		}
+		if !val.Type().Equal(elemTyp) {
+			return newError(fmt.Sprintf("can not append type %s into a list of %s", c.compiler.displayType(val.Type()), c.compiler.displayType(elemTyp)), TypeError, callExp.Token)
+		}
+
+		c.NewCall(c.compiler.listAppendFunc(elemTyp), object, val)
+		return nil
+	}
+
+	if keyTyp, valTyp, ok := c.compiler.dictElems(object.Type()); ok && attr.Attribute.Value == "get" {
+		if len(callExp.Arguments) != 2 {
+			return newError(fmt.Sprintf("get() takes exactly two arguments (%d given)", len(callExp.Arguments)), TypeError, callExp.Token)
+		}
+
+		key, err := c.compileExpected(callExp.Arguments[0], keyTyp, callExp.Token)
+		if err != nil {
+			return err
+		}
+		def, err := c.compileExpected(callExp.Arguments[1], valTyp, callExp.Token)
+		if err != nil {
+			return err
+		}
+		if !key.Type().Equal(keyTyp) || !def.Type().Equal(valTyp) {
+			return newError(fmt.Sprintf("get() of a dict of %s: %s called with %s, %s", c.compiler.displayType(keyTyp), c.compiler.displayType(valTyp), c.compiler.displayType(key.Type()), c.compiler.displayType(def.Type())), TypeError, callExp.Token)
+		}
+
+		c.pushReg(c.NewCall(c.compiler.dictGetFunc(keyTyp, valTyp), object, key, def))
+		return nil
+	}
+
+	return newError(fmt.Sprintf("type %s has no attribute '%s'", c.compiler.displayType(object.Type()), attr.Attribute.Value), NameError, attr.Attribute.Token)
+}
+
+// Call a method of the base class of the method being compiled, e.g. super().__init__(x)
+func (c *context) compileSuperCall(attr *ast.AttributeExpression, callExp *ast.CallExpression) error {
+	if c.class == nil || c.class.base == nil {
+		return newError("super() can only be used in a method of a class with a base class", TypeError, callExp.Token)
+	}
+
+	base := c.class.base
+	methodName := attr.Attribute.Value
+	method, ok := base.method(methodName)
+	if !ok {
+		return newError(fmt.Sprintf("'%s' object has no method '%s'", base.name, methodName), NameError, attr.Attribute.Token)
+	}
+
+	args, err := c.compileArguments(method.Params[1:], base.name+"."+methodName, callExp.Arguments, callExp.Token)
+	if err != nil {
+		return err
+	}
+
+	self := c.fn.Params[0]
+	c.pushReg(c.callMethodStatic(self, method, args))
+	return nil
+}
+
+// isinstance(obj, Class) checks the class of an object at runtime
+func (c *context) compileIsinstance(callExp *ast.CallExpression) error {
+	if len(callExp.Arguments) != 2 {
+		return newError(fmt.Sprintf("isinstance() takes exactly two arguments (%d given)", len(callExp.Arguments)), TypeError, callExp.Token)
+	}
+
+	if err := c.compile(callExp.Arguments[0]); err != nil {
+		return err
+	}
+	object := c.popReg()
+
+	if _, ok := c.compiler.classOf(object.Type()); !ok {
+		return newError(fmt.Sprintf("isinstance() expects an object, got %s", c.compiler.displayType(object.Type())), TypeError, callExp.Token)
+	}
+
+	target, ok := c.classNamed(callExp.Arguments[1])
+	if !ok {
+		return newError(fmt.Sprintf("isinstance() arg 2 must be a class, got %s", callExp.Arguments[1].String()), TypeError, callExp.Token)
+	}
+
+	vtable := c.NewLoad(I8Ptr, c.NewBitCast(object, types.NewPointer(I8Ptr)))
+	c.pushReg(c.NewCall(c.compiler.isinstanceFunc(), vtable, constant.NewBitCast(target.vtable, I8Ptr)))
+	return nil
+}
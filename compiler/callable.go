@@ -0,0 +1,115 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Function values are a named struct { ret (i8*, params...)* fn, i8* env }
+// passed by value, e.g. Callable[[int], int] is %callable.i64_ret_i64. The
+// function takes the env as its first parameter, module level functions
+// ignore it and nested functions and lambdas find their captured cells in it
+const (
+	callableFunc = iota
+	callableEnv
+)
+
+// The type of Callable[[params...], ret], its struct type is defined in the module on first use
+func (comp *compiler) callableOf(params []types.Type, ret types.Type) *types.StructType {
+	tags := make([]string, 0, len(params)+2)
+	for _, param := range params {
+		tags = append(tags, typeTag(param))
+	}
+	tags = append(tags, "ret", typeTag(ret))
+	name := "callable." + strings.Join(tags, "_")
+
+	st, ok := comp.callables[name]
+	if !ok {
+		sig := types.NewFunc(ret, append([]types.Type{I8Ptr}, params...)...)
+		st = types.NewStruct(types.NewPointer(sig), I8Ptr)
+		comp.module.NewTypeDef(name, st)
+		comp.callables[name] = st
+	}
+
+	return st
+}
+
+// Get the signature of a callable type, including the env parameter
+func (comp *compiler) callableSig(typ types.Type) (*types.FuncType, bool) {
+	st, ok := typ.(*types.StructType)
+	if !ok || comp.callables[st.Name()] != st {
+		return nil, false
+	}
+
+	return st.Fields[callableFunc].(*types.PointerType).ElemType.(*types.FuncType), true
+}
+
+// The callable type of a function that takes an env as its first parameter
+func (comp *compiler) callableOfFunc(fn *ir.Func) *types.StructType {
+	return comp.callableOf(fn.Sig.Params[1:], fn.Sig.RetType)
+}
+
+// Make a function value from a function and its env
+func (c *context) newCallable(fn *ir.Func, env value.Value) value.Value {
+	typ := c.compiler.callableOfFunc(fn)
+	val := c.NewInsertValue(constant.NewUndef(typ), fn, callableFunc)
+	return c.NewInsertValue(val, env, callableEnv)
+}
+
+// A module level function used as a value is called through a thunk that
+// takes the env parameter every function value takes
+func (comp *compiler) thunkFunc(fn *ir.Func) *ir.Func {
+	return comp.runtimeFunc(fn.Name()+".thunk", func(name string) *ir.Func {
+		params := []*ir.Param{ir.NewParam("env", I8Ptr)}
+		args := make([]value.Value, 0, len(fn.Params))
+		for _, param := range fn.Params {
+			arg := ir.NewParam(param.Name(), param.Type())
+			params = append(params, arg)
+			args = append(args, arg)
+		}
+
+		thunk := comp.module.NewFunc(name, fn.Sig.RetType, params...)
+		entry := thunk.NewBlock("entry")
+		ret := entry.NewCall(fn, args...)
+		if fn.Sig.RetType.Equal(None) {
+			entry.NewRet(nil)
+		} else {
+			entry.NewRet(ret)
+		}
+
+		return thunk
+	})
+}
+
+// Call a function value with type checked arguments
+func (c *context) compileIndirectCall(callee value.Value, funcName string, callExp *ast.CallExpression) error {
+	sig, ok := c.compiler.callableSig(callee.Type())
+	if !ok {
+		return newError(fmt.Sprintf("'%s' object is not callable", c.compiler.displayType(callee.Type())), TypeError, callExp.Token)
+	}
+	callee = c.hold(callee, callExp.Arguments...)
+
+	// Function values have no parameter names, arguments are reported by position
+	params := make([]*ir.Param, 0, len(sig.Params)-1)
+	for i, typ := range sig.Params[1:] {
+		params = append(params, ir.NewParam(fmt.Sprintf("arg%d", i+1), typ))
+	}
+
+	args, err := c.compileArguments(params, funcName, callExp.Arguments, callExp.Token)
+	if err != nil {
+		return err
+	}
+
+	fn := c.NewExtractValue(callee, callableFunc)
+	env := c.NewExtractValue(callee, callableEnv)
+	call := c.NewCall(fn, append([]value.Value{env}, args...)...)
+	c.checkException()
+	c.pushReg(c.temp(call))
+	return nil
+}
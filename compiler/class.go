@@ -0,0 +1,233 @@
+package compiler
+
+import (
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Objects are pointers to a heap allocated named struct, its first field points
+// to the vtable of the object class and the fields of the class follow it.
+// A derived class starts with the fields of its base, so an object can be used
+// through a pointer to any of its base classes
+const (
+	objectVtable = iota
+	objectFields
+)
+
+// Vtables are constant globals holding the vtable of the base class, used by
+// isinstance, followed by a function pointer for every virtual method
+const (
+	vtableParent = iota
+	vtableSlots
+)
+
+type class struct {
+	name      string
+	base      *class
+	symbol    string // prefixes the LLVM names of its methods, constructor and vtable
+	typ       *types.StructType
+	vtableTyp *types.StructType
+	vtable    *ir.Global
+	fields    []*field                        // the fields of the class, inherited ones first
+	defaults  []*ast.AnnotatedAssignStatement // field initializers, inherited ones first
+	methods   map[string]*ir.Func             // methods defined by the class itself
+	slots     []string                        // virtual methods in vtable order, inherited ones first
+	ctor      *ir.Func                        // allocates an object and calls __init__ on it
+}
+
+type field struct {
+	name string
+	typ  types.Type
+}
+
+func (cls *class) ptrType() *types.PointerType {
+	return types.NewPointer(cls.typ)
+}
+
+// Get the struct index and type of a field, reports false if there is no such field
+func (cls *class) field(name string) (int, types.Type, bool) {
+	for i, f := range cls.fields {
+		if f.name == name {
+			return objectFields + i, f.typ, true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// Get the implementation of a method, defined by the class or inherited
+func (cls *class) method(name string) (*ir.Func, bool) {
+	for ; cls != nil; cls = cls.base {
+		if fn, ok := cls.methods[name]; ok {
+			return fn, true
+		}
+	}
+
+	return nil, false
+}
+
+// Get the vtable index of a virtual method
+func (cls *class) slot(name string) (int, bool) {
+	for i, slot := range cls.slots {
+		if slot == name {
+			return vtableSlots + i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (cls *class) isSubclassOf(other *class) bool {
+	for ; cls != nil; cls = cls.base {
+		if cls == other {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the class of an object type
+func (comp *compiler) classOf(typ types.Type) (*class, bool) {
+	ptr, ok := typ.(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok {
+		return nil, false
+	}
+
+	cls, ok := comp.classes[st.Name()]
+	if !ok || cls.typ != st {
+		return nil, false
+	}
+
+	return cls, true
+}
+
+// Pointer to a field of an object
+func objectField(b *ir.Block, obj value.Value, index int) value.Value {
+	st := obj.Type().(*types.PointerType).ElemType
+	return b.NewGetElementPtr(st, obj, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(index)))
+}
+
+// Use an object of a derived class where an object of its base class is expected
+func (c *context) upcast(reg value.Value, typ types.Type) value.Value {
+	if opt, ok := typ.(*optionalType); ok {
+		return c.toOptional(reg, opt)
+	}
+
+	if reg.Type().Equal(typ) {
+		return reg
+	}
+
+	from, ok := c.compiler.classOf(reg.Type())
+	if !ok {
+		return reg
+	}
+	to, ok := c.compiler.classOf(typ)
+	if !ok || !from.isSubclassOf(to) {
+		return reg
+	}
+
+	return c.NewBitCast(reg, typ)
+}
+
+// Call a method through the vtable of the object, so the implementation of
+// the object class is called even when the object is used as its base class
+func (c *context) callMethod(obj value.Value, cls *class, name string, args []value.Value) value.Value {
+	index, _ := cls.slot(name)
+
+	vtable := c.NewLoad(types.NewPointer(cls.vtableTyp), objectField(c.Block, obj, objectVtable))
+	slotPtr := c.NewGetElementPtr(cls.vtableTyp, vtable, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(index)))
+	fnTyp := cls.vtableTyp.Fields[index]
+	fn := c.NewLoad(fnTyp, slotPtr)
+
+	// The slot takes self as the class that introduced the method
+	selfTyp := fnTyp.(*types.PointerType).ElemType.(*types.FuncType).Params[0]
+	call := c.NewCall(fn, append([]value.Value{c.castObject(obj, selfTyp)}, args...)...)
+	c.checkException()
+	return c.temp(call)
+}
+
+// Call a method implementation directly, e.g. super().__init__()
+func (c *context) callMethodStatic(obj value.Value, fn *ir.Func, args []value.Value) value.Value {
+	self := c.castObject(obj, fn.Params[0].Type())
+	call := c.NewCall(fn, append([]value.Value{self}, args...)...)
+	c.checkException()
+	return c.temp(call)
+}
+
+func (c *context) castObject(obj value.Value, typ types.Type) value.Value {
+	if obj.Type().Equal(typ) {
+		return obj
+	}
+	return c.NewBitCast(obj, typ)
+}
+
+// void Name.drop(i8* obj) releases the fields of an object, nil when no
+// field holds a reference
+func (comp *compiler) classDropFunc(cls *class) *ir.Func {
+	managed := false
+	for _, f := range cls.fields {
+		managed = managed || comp.isManaged(f.typ)
+	}
+	if !managed {
+		return nil
+	}
+
+	return comp.runtimeFunc(cls.symbol+".drop", func(name string) *ir.Func {
+		raw := ir.NewParam("obj", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, raw)
+
+		entry := fn.NewBlock("entry")
+		obj := entry.NewBitCast(raw, cls.ptrType())
+		for i, f := range cls.fields {
+			if comp.isManaged(f.typ) {
+				comp.release(entry, entry.NewLoad(f.typ, objectField(entry, obj, objectFields+i)))
+			}
+		}
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// i1 spython_isinstance(i8* vtable, i8* target) walks the vtable parents of
+// an object looking for the vtable of the target class
+func (comp *compiler) isinstanceFunc() *ir.Func {
+	return comp.runtimeFunc("spython_isinstance", func(name string) *ir.Func {
+		vtable := ir.NewParam("vtable", I8Ptr)
+		target := ir.NewParam("target", I8Ptr)
+		fn := comp.module.NewFunc(name, types.I1, vtable, target)
+
+		entry := fn.NewBlock("entry")
+		loop := fn.NewBlock("loop")
+		check := fn.NewBlock("check")
+		next := fn.NewBlock("next")
+		found := fn.NewBlock("found")
+		missing := fn.NewBlock("missing")
+
+		entry.NewBr(loop)
+
+		current := loop.NewPhi(ir.NewIncoming(vtable, entry))
+		loop.NewCondBr(loop.NewICmp(enum.IPredEQ, current, constant.NewNull(I8Ptr)), missing, check)
+
+		check.NewCondBr(check.NewICmp(enum.IPredEQ, current, target), found, next)
+
+		parent := next.NewLoad(I8Ptr, next.NewBitCast(current, types.NewPointer(I8Ptr)))
+		current.Incs = append(current.Incs, ir.NewIncoming(parent, next))
+		next.NewBr(loop)
+
+		found.NewRet(constant.True)
+		missing.NewRet(constant.False)
+
+		return fn
+	})
+}
@@ -0,0 +1,271 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+var builtinGenerics = map[string]bool{"list": true, "dict": true, "tuple": true}
+
+func (c *context) compileClassStatement(classStat *ast.ClassStatement) error {
+	name := classStat.Name.Value
+
+	if !c.isModuleLevel() {
+		return newError(fmt.Sprintf("class '%s' must be defined at module level", name), UnsupportedError, classStat.Token)
+	}
+
+	if _, ok := nameToType[name]; ok || builtinGenerics[name] {
+		return newError(fmt.Sprintf("class '%s' shadows the builtin type %s", name, name), NameError, classStat.Token)
+	}
+
+	if _, ok := c.compiler.lookupClass(name); ok {
+		return newError(fmt.Sprintf("class '%s' is already defined", name), NameError, classStat.Token)
+	}
+	if err := c.compiler.ns.checkNewName("class", name, classStat.Token); err != nil {
+		return err
+	}
+
+	var base *class
+	if classStat.Base != nil {
+		var ok bool
+		base, ok = c.classNamed(classStat.Base)
+		if !ok {
+			return newError(fmt.Sprintf("base class '%s' of class '%s' is not defined", classStat.Base.String(), name), NameError, classStat.Token)
+		}
+	}
+
+	// The class is registered before its fields are resolved so a field,
+	// or a method parameter, can have the type of the class itself
+	typeName := c.compiler.ns.typeName(name)
+	st := types.NewStruct()
+	c.mod.NewTypeDef(typeName, st)
+	vtableTyp := types.NewStruct()
+	c.mod.NewTypeDef(typeName+".vtable", vtableTyp)
+
+	cls := &class{name: name, symbol: c.compiler.ns.symbol(name), base: base, typ: st, vtableTyp: vtableTyp, methods: make(map[string]*ir.Func)}
+	if base != nil {
+		cls.fields = append(cls.fields, base.fields...)
+		cls.defaults = append(cls.defaults, base.defaults...)
+		cls.slots = append(cls.slots, base.slots...)
+	}
+	c.compiler.ns.classes[name] = cls
+	c.compiler.ns.defined = append(c.compiler.ns.defined, cls)
+	c.compiler.classes[typeName] = cls
+
+	var methods []*ast.FunctionLiteral
+	for _, stmt := range classStat.Body.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.AnnotatedAssignStatement:
+			if err := c.declareField(cls, stmt); err != nil {
+				return err
+			}
+			if stmt.Value != nil {
+				cls.defaults = append(cls.defaults, stmt)
+			}
+		case *ast.ExpressionStatement:
+			funcLit, ok := stmt.Expression.(*ast.FunctionLiteral)
+			if !ok {
+				return newError(fmt.Sprintf("class '%s' body can only contain field declarations and methods", name), UnsupportedError, classStat.Token)
+			}
+			methods = append(methods, funcLit)
+		case *ast.PassStatement:
+		default:
+			return newError(fmt.Sprintf("class '%s' body can only contain field declarations and methods", name), UnsupportedError, classStat.Token)
+		}
+	}
+
+	st.Fields = append(st.Fields, types.NewPointer(vtableTyp))
+	for _, f := range cls.fields {
+		st.Fields = append(st.Fields, f.typ)
+	}
+
+	for _, funcLit := range methods {
+		if err := c.declareMethod(cls, funcLit); err != nil {
+			return err
+		}
+	}
+
+	if init, ok := cls.methods["__init__"]; ok && !init.Sig.RetType.Equal(None) {
+		return newError(fmt.Sprintf("__init__ of class '%s' should return None", name), TypeError, classStat.Token)
+	}
+
+	c.defineVtable(cls)
+	cls.ctor = c.declareConstructor(cls)
+	return nil
+}
+
+func (c *context) declareField(cls *class, decl *ast.AnnotatedAssignStatement) error {
+	fieldName := decl.Name.Value
+	if _, _, ok := cls.field(fieldName); ok {
+		return newError(fmt.Sprintf("field '%s' of class '%s' is already declared", fieldName, cls.name), NameError, decl.Token)
+	}
+
+	typ, err := c.resolveType(decl.Type, decl.Token)
+	if err != nil {
+		return err
+	}
+
+	if typ.Equal(None) {
+		return newError(fmt.Sprintf("field %s can not be declared as None", fieldName), TypeError, decl.Token)
+	}
+
+	cls.fields = append(cls.fields, &field{name: fieldName, typ: typ})
+	return nil
+}
+
+// Declare a method of the class, a method overriding a method of a base class
+// must have the same signature and any other method gets a new vtable slot
+func (c *context) declareMethod(cls *class, funcLit *ast.FunctionLiteral) error {
+	methodName := funcLit.TokenLiteral()
+	if _, err := c.checkDecorators(funcLit, false); err != nil {
+		return err
+	}
+	if _, ok := cls.methods[methodName]; ok {
+		return newError(fmt.Sprintf("method '%s' of class '%s' is already defined", methodName, cls.name), NameError, funcLit.Token)
+	}
+	if _, _, ok := cls.field(methodName); ok {
+		return newError(fmt.Sprintf("method '%s' of class '%s' has the name of a field", methodName, cls.name), NameError, funcLit.Token)
+	}
+
+	fn, err := c.declareFunction(funcLit, cls.symbol+"."+methodName, cls, nil)
+	if err != nil {
+		return err
+	}
+	cls.methods[methodName] = fn
+
+	if err := c.compiler.checkSpecialMethod(cls, fn, methodName, funcLit.Token); err != nil {
+		return err
+	}
+
+	// Constructors are not inherited through the vtable
+	if methodName == "__init__" {
+		return nil
+	}
+
+	if cls.base != nil {
+		if baseFn, ok := cls.base.method(methodName); ok {
+			if !sameSignature(fn.Sig, baseFn.Sig) {
+				return newError(fmt.Sprintf("method '%s' of class '%s' does not match the signature of %s", methodName, cls.name, displayName(baseFn)), TypeError, funcLit.Token)
+			}
+			return nil
+		}
+	}
+
+	cls.slots = append(cls.slots, methodName)
+	return nil
+}
+
+// Compare the signatures of two methods, ignoring the type of self
+func sameSignature(x, y *types.FuncType) bool {
+	if !x.RetType.Equal(y.RetType) || len(x.Params) != len(y.Params) {
+		return false
+	}
+
+	for i := 1; i < len(x.Params); i++ {
+		if !x.Params[i].Equal(y.Params[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Define the vtable of the class, an inherited slot keeps the function type
+// of the class that introduced it so the base class vtable is a prefix of it
+func (c *context) defineVtable(cls *class) {
+	parent := constant.Constant(constant.NewNull(I8Ptr))
+	if cls.base != nil {
+		parent = constant.NewBitCast(cls.base.vtable, I8Ptr)
+		cls.vtableTyp.Fields = append(cls.vtableTyp.Fields, cls.base.vtableTyp.Fields...)
+	} else {
+		cls.vtableTyp.Fields = append(cls.vtableTyp.Fields, I8Ptr)
+	}
+
+	for _, slot := range cls.slots[len(cls.vtableTyp.Fields)-vtableSlots:] {
+		fn, _ := cls.method(slot)
+		cls.vtableTyp.Fields = append(cls.vtableTyp.Fields, fn.Type())
+	}
+
+	if c.compiler.ns.separate {
+		cls.vtable = c.compiler.externalGlobal(cls.symbol+".vtable", cls.vtableTyp)
+		cls.vtable.Immutable = true
+		return
+	}
+
+	entries := []constant.Constant{parent}
+	for i, slot := range cls.slots {
+		fn, _ := cls.method(slot)
+
+		var entry constant.Constant = fn
+		if slotTyp := cls.vtableTyp.Fields[vtableSlots+i]; !fn.Type().Equal(slotTyp) {
+			entry = constant.NewBitCast(fn, slotTyp)
+		}
+		entries = append(entries, entry)
+	}
+
+	cls.vtable = c.mod.NewGlobalDef(cls.symbol+".vtable", constant.NewStruct(cls.vtableTyp, entries...))
+	cls.vtable.Immutable = true
+}
+
+// The constructor takes the parameters of __init__ without self, it allocates
+// a zeroed object, stores its vtable and the field defaults and calls __init__ on it
+func (c *context) declareConstructor(cls *class) *ir.Func {
+	init, hasInit := cls.method("__init__")
+
+	params := make([]*ir.Param, 0)
+	if hasInit {
+		for _, param := range init.Params[1:] {
+			params = append(params, ir.NewParam(param.Name(), param.Type()))
+		}
+	}
+
+	fn := c.mod.NewFunc(cls.symbol+".new", cls.ptrType(), params...)
+	if c.compiler.ns.separate {
+		return fn
+	}
+
+	c.compiler.pendingBodies = append(c.compiler.pendingBodies, func() error {
+		ctx := newContext(c.compiler, fn, fn.NewBlock("entry"))
+
+		obj := c.compiler.alloc(ctx.Block, cls.typ, c.compiler.classDropFunc(cls), cls.name)
+		ctx.NewStore(constant.NewZeroInitializer(cls.typ), obj)
+		ctx.NewStore(cls.vtable, objectField(ctx.Block, obj, objectVtable))
+
+		// An exception raised by a field default or __init__ frees the object
+		unwind := ctx.newContext("unwind")
+		c.compiler.release(unwind.Block, obj)
+		unwind.NewRet(constant.NewNull(cls.ptrType()))
+		ctx.frame.unwind = unwind.Block
+
+		for _, decl := range cls.defaults {
+			index, typ, _ := cls.field(decl.Name.Value)
+			reg, err := ctx.compileExpected(decl.Value, typ, decl.Token)
+			if err != nil {
+				return err
+			}
+			if !reg.Type().Equal(typ) {
+				return newError(fmt.Sprintf("can not assign type %s into field %s", c.compiler.displayType(reg.Type()), decl.Name.Value), TypeError, decl.Token)
+			}
+			ctx.store(reg, objectField(ctx.Block, obj, index))
+			ctx.releaseTemps()
+		}
+
+		if hasInit {
+			args := make([]value.Value, 0, len(params))
+			for _, param := range params {
+				args = append(args, param)
+			}
+			ctx.callMethodStatic(obj, init, args)
+		}
+
+		ctx.NewRet(obj)
+		return nil
+	})
+
+	return fn
+}
@@ -0,0 +1,172 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A function defined inside another function is closure converted. The
+// variables it uses from the functions around it live in heap cells, and
+// pointers to those cells are stored in an env struct that is passed to it
+// as a hidden first parameter. The name of the nested function is a variable
+// of the enclosing function holding it as a function value, so the functions
+// that call it can capture it like any other variable
+func (c *context) compileNestedFunction(funcLit *ast.FunctionLiteral) error {
+	name := funcLit.TokenLiteral()
+	symbol := c.fn.Name() + "." + name
+
+	for _, fn := range c.mod.Funcs {
+		if fn.Name() == symbol {
+			return newError(fmt.Sprintf("function '%s' is already defined", name), NameError, funcLit.Token)
+		}
+	}
+
+	envTyp := types.NewStruct()
+	c.mod.NewTypeDef(symbol+".env", envTyp)
+
+	fn, err := c.declareFunction(funcLit, symbol, nil, envTyp)
+	if err != nil {
+		return err
+	}
+
+	// The binding exists before the env is made so a function can call itself
+	binding := c.newVariable(name, c.compiler.callableOfFunc(fn))
+	c.createVar(name, binding)
+
+	closure, err := c.newClosure(fn, envTyp, c.compiler.scopes[funcLit], funcLit.Token)
+	if err != nil {
+		return err
+	}
+
+	c.store(closure, binding)
+	return nil
+}
+
+// A lambda is compiled like a nested function, its parameter and return
+// types come from the Callable type it is used as
+func (c *context) compileLambda(lambda *ast.LambdaExpression, typ types.Type) (value.Value, error) {
+	sig, ok := c.compiler.callableSig(typ)
+	if !ok {
+		return nil, newError("lambda can only be used where a Callable type is expected", TypeError, lambda.Token)
+	}
+
+	paramTyps := sig.Params[1:]
+	if len(lambda.Parameters) != len(paramTyps) {
+		return nil, newError(fmt.Sprintf("lambda takes %d arguments but %s expects %d", len(lambda.Parameters), c.compiler.displayType(typ), len(paramTyps)), TypeError, lambda.Token)
+	}
+
+	params := make([]*ir.Param, 0, len(paramTyps))
+	for i, param := range lambda.Parameters {
+		if hasParam(params, param.Value) {
+			return nil, newError(fmt.Sprintf("duplicate parameter '%s' in lambda", param.Value), NameError, lambda.Token)
+		}
+		params = append(params, ir.NewParam(param.Value, paramTyps[i]))
+	}
+
+	symbol := fmt.Sprintf("%s.lambda.%d", c.fn.Name(), len(c.mod.Funcs))
+	envTyp := types.NewStruct()
+	c.mod.NewTypeDef(symbol+".env", envTyp)
+
+	envParam := ir.NewParam("env", I8Ptr)
+	fn := c.mod.NewFunc(symbol, sig.RetType, append([]*ir.Param{envParam}, params...)...)
+	scope := c.compiler.scopes[lambda]
+
+	c.compiler.pendingBodies = append(c.compiler.pendingBodies, func() error {
+		ctx := newContext(c.compiler, fn, fn.NewBlock("entry"))
+		ctx.scope = scope
+		ctx.enterFunction("<lambda>")
+		ctx.loadEnv(envParam, envTyp)
+		ctx.spillParams(params)
+
+		if sig.RetType.Equal(None) {
+			if err := ctx.compile(lambda.Body); err != nil {
+				return err
+			}
+			ctx.releaseTemps()
+			ctx.NewRet(nil)
+			ctx.releaseFrame()
+			return nil
+		}
+
+		ret, err := ctx.compileExpected(lambda.Body, sig.RetType, lambda.Token)
+		if err != nil {
+			return err
+		}
+		if !ret.Type().Equal(sig.RetType) {
+			return newError(fmt.Sprintf("lambda returns %s but %s expects %s", c.compiler.displayType(ret.Type()), c.compiler.displayType(typ), c.compiler.displayType(sig.RetType)), TypeError, lambda.Token)
+		}
+		ctx.compiler.retain(ctx.Block, ret)
+		ctx.releaseTemps()
+		ctx.NewRet(ret)
+		ctx.releaseFrame()
+		return nil
+	})
+
+	return c.newClosure(fn, envTyp, scope, lambda.Token)
+}
+
+// Make a function value of a nested function or lambda with an env holding
+// the cells of the variables it uses from the functions around it
+func (c *context) newClosure(fn *ir.Func, envTyp *types.StructType, scope *funcScope, tok token.Token) (value.Value, error) {
+	cells := make([]value.Value, 0, len(scope.free))
+	for _, free := range scope.free {
+		cell := c.getVar(free)
+		if _, isGlobal := cell.(*ir.Global); cell == nil || isGlobal {
+			return nil, newError(fmt.Sprintf("free variable '%s' referenced before assignment in enclosing scope", free), NameError, tok)
+		}
+		envTyp.Fields = append(envTyp.Fields, cell.Type())
+		cells = append(cells, cell)
+	}
+
+	if len(cells) == 0 {
+		return c.newCallable(fn, constant.NewNull(I8Ptr)), nil
+	}
+
+	// The env holds a reference to every cell it stores
+	env := c.compiler.alloc(c.Block, envTyp, c.compiler.envDropFunc(envTyp), "closure")
+	for i, cell := range cells {
+		field := c.NewGetElementPtr(envTyp, env, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+		c.NewCall(c.compiler.increfFunc(), c.NewBitCast(cell, I8Ptr))
+		c.NewStore(cell, field)
+	}
+
+	return c.temp(c.newCallable(fn, c.NewBitCast(env, I8Ptr))), nil
+}
+
+// void symbol.env.drop(i8* env) releases the cells of a closure env
+func (comp *compiler) envDropFunc(envTyp *types.StructType) *ir.Func {
+	return comp.runtimeFunc(envTyp.Name()+".drop", func(name string) *ir.Func {
+		raw := ir.NewParam("env", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, raw)
+
+		entry := fn.NewBlock("entry")
+		env := entry.NewBitCast(raw, types.NewPointer(envTyp))
+		for i, cellTyp := range envTyp.Fields {
+			field := entry.NewGetElementPtr(envTyp, env, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			cell := entry.NewLoad(cellTyp, field)
+			entry.NewCall(comp.decrefFunc(), entry.NewBitCast(cell, I8Ptr))
+		}
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// Make the cells stored in the env of a nested function its variables
+func (c *context) loadEnv(env *ir.Param, envTyp *types.StructType) {
+	if len(c.scope.free) == 0 {
+		return
+	}
+
+	typed := c.NewBitCast(env, types.NewPointer(envTyp))
+	for i, free := range c.scope.free {
+		field := c.NewGetElementPtr(envTyp, typed, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+		c.createVar(free, c.NewLoad(envTyp.Fields[i], field))
+	}
+}
@@ -2,40 +2,164 @@ package compiler
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/hvuhsg/spython/ast"
 
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
 )
 
 var Int = types.I64
 var Float = types.Float
 var None = types.Void
+var Str = types.I8Ptr
+var Bool = types.I1
 
 var nameToType map[string]types.Type = map[string]types.Type{
 	"int":   Int,
 	"float": Float,
+	"str":   Str,
+	"bool":  Bool,
 	"None":  None,
+	"ptr":   Ptr,
+}
+
+// The name of a type as it is written in the source, e.g. list[int] or
+// Node | None, for error messages
+func (comp *compiler) displayType(typ types.Type) string {
+	if typ == noneType {
+		return "None"
+	}
+	if opt, ok := typ.(*optionalType); ok {
+		return comp.displayType(opt.PointerType) + " | None"
+	}
+	for name, t := range nameToType {
+		if t.Equal(typ) {
+			return name
+		}
+	}
+
+	if cls, ok := comp.classOf(typ); ok {
+		return strings.TrimPrefix(cls.typ.Name(), moduleTypePrefix)
+	}
+	if elem, ok := comp.listElem(typ); ok {
+		return fmt.Sprintf("list[%s]", comp.displayType(elem))
+	}
+	if key, val, ok := comp.dictElems(typ); ok {
+		return fmt.Sprintf("dict[%s, %s]", comp.displayType(key), comp.displayType(val))
+	}
+	if elems, ok := tupleElems(typ); ok {
+		return fmt.Sprintf("tuple[%s]", comp.displayTypes(elems))
+	}
+	if sig, ok := comp.callableSig(typ); ok {
+		return fmt.Sprintf("Callable[[%s], %s]", comp.displayTypes(sig.Params[1:]), comp.displayType(sig.RetType))
+	}
+
+	return typ.String()
+}
+
+func (comp *compiler) displayTypes(typs []types.Type) string {
+	names := make([]string, 0, len(typs))
+	for _, typ := range typs {
+		names = append(names, comp.displayType(typ))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// Options change how a program is compiled
+type Options struct {
+	DebugLeaks bool     // report the heap objects that are still alive when the program exits
+	File       string   // the source file name shown in tracebacks
+	NoAsserts  bool     // compile assert statements to nothing, for optimised builds
+	Path       []string // directories searched for imported modules after the directory of the importing file
+	Library    string   // compile a library module of this name, without a main function
+	OptLevel   int      // the optimisation level of OptimizedIR, 0 to 3, opt runs from level 2
+	Passes     string   // an opt pass pipeline OptimizedIR runs instead of the default one of the level
 }
 
 type compiler struct {
+	options  Options
 	module   *ir.Module
 	function *ir.Func
 	ctx      *context
+
+	ns            *namespace              // the module being compiled
+	builtins      *namespace              // the built-in classes, visible in every module
+	namespaces    []*namespace            // the program and the modules it imports, in import order
+	modules       map[string]*namespace   // imported modules by name
+	importing     []*namespace            // the modules being compiled, innermost last
+	scopes        map[ast.Node]*funcScope // the names bound and shared by every function
+	pendingBodies []func() error          // function bodies, compiled after the module level code
+
+	runtime     map[string]*ir.Func          // runtime and C functions used by the program
+	strings     map[string]*ir.Global        // string constants
+	lists       map[string]*types.StructType // list types by type name
+	dicts       map[string]*types.StructType // dict types by type name
+	classes     map[string]*class            // classes of every module by struct name
+	callables   map[string]*types.StructType // function value types by type name
+	header      *types.StructType            // the header of reference counted objects
+	live        *ir.Global                   // the live objects list, when leaks are reported
+	stackEntry  *types.StructType            // an entry of the shadow call stack
+	stack       *ir.Global                   // the innermost entry of the shadow call stack
+	traceback   *ir.Global                   // the copied stack of the last exception raised
+	exception   *ir.Global                   // the pending exception, null when there is none
+	exports     []*exportFunc                // the functions exported to C, in definition order
+	ptrDeclared bool                         // the ptr type is declared in the LLVM module
 }
 
 func New() *compiler {
-	c := &compiler{}
+	return NewWithOptions(Options{})
+}
+
+func NewWithOptions(options Options) *compiler {
+	c := &compiler{
+		options:   options,
+		modules:   make(map[string]*namespace),
+		scopes:    make(map[ast.Node]*funcScope),
+		runtime:   make(map[string]*ir.Func),
+		strings:   make(map[string]*ir.Global),
+		lists:     make(map[string]*types.StructType),
+		dicts:     make(map[string]*types.StructType),
+		classes:   make(map[string]*class),
+		callables: make(map[string]*types.StructType),
+	}
 
 	mainModule := ir.NewModule()
 	c.module = mainModule
 
+	// Modules imported by the program are searched next to it
+	dir := "."
+	if options.File != "" {
+		dir = filepath.Dir(options.File)
+	}
+	c.ns = newNamespace(options.Library, c.fileName(), dir)
+	c.namespaces = append(c.namespaces, c.ns)
+	c.builtins = newNamespace("", c.fileName(), dir)
+	// The built-in classes are part of the runtime, shared by every compilation unit
+	c.builtins.prefix = ""
+
+	// A library runs its module level code in its init function
+	if options.Library != "" {
+		c.ns.init = mainModule.NewFunc(c.ns.symbol("<module>"), types.Void)
+		c.ns.loaded = mainModule.NewGlobalDef(c.ns.symbol("<loaded>"), constant.False)
+		c.modules[options.Library] = c.ns
+		c.importing = append(c.importing, c.ns)
+		c.builtins.init = c.ns.init
+		c.ctx = newContext(c, c.ns.init, c.ns.init.NewBlock("entry"))
+		return c
+	}
+
 	mainFunction := mainModule.NewFunc("main", Int)
 	c.function = mainFunction
+	c.ns.init = mainFunction
+	c.builtins.init = mainFunction
 
 	startBlock := mainFunction.NewBlock("prog_entry")
-	c.ctx = newContext(mainModule, mainFunction, startBlock)
+	c.ctx = newContext(c, mainFunction, startBlock)
 
 	return c
 }
@@ -58,10 +182,38 @@ func (c *context) compile(node ast.Node) error {
 		if err := c.compileExpressionStatement(node); err != nil {
 			return err
 		}
+	case *ast.AssignStatement:
+		if err := c.compileAssignStatement(node); err != nil {
+			return err
+		}
+	case *ast.GlobalStatement:
+		if err := c.compileGlobalStatement(node); err != nil {
+			return err
+		}
+	case *ast.NonlocalStatement:
+		if err := c.compileNonlocalStatement(node); err != nil {
+			return err
+		}
+	case *ast.AnnotatedAssignStatement:
+		if err := c.compileAnnotatedAssignStatement(node); err != nil {
+			return err
+		}
+	case *ast.AugmentedAssignStatement:
+		if err := c.compileAugmentedAssignStatement(node); err != nil {
+			return err
+		}
+	case *ast.ClassStatement:
+		if err := c.compileClassStatement(node); err != nil {
+			return err
+		}
 	case *ast.InfixExpression:
 		if err := c.compileInfixExpression(node); err != nil {
 			return err
 		}
+	case *ast.PrefixExpression:
+		if err := c.compilePrefixExpression(node); err != nil {
+			return err
+		}
 	case *ast.IntegerLiteral:
 		if err := c.compileIntegerLiteral(node); err != nil {
 			return err
@@ -70,6 +222,10 @@ func (c *context) compile(node ast.Node) error {
 		if err := c.compileFloatLiteral(node); err != nil {
 			return err
 		}
+	case *ast.StringLiteral:
+		if err := c.compileStringLiteral(node); err != nil {
+			return err
+		}
 	case *ast.Identifier:
 		if err := c.compileIdentifier(node); err != nil {
 			return err
@@ -94,10 +250,59 @@ func (c *context) compile(node ast.Node) error {
 		if err := c.compileReturnStatement(node); err != nil {
 			return err
 		}
+	case *ast.TryStatement:
+		if err := c.compileTryStatement(node); err != nil {
+			return err
+		}
+	case *ast.RaiseStatement:
+		if err := c.compileRaiseStatement(node); err != nil {
+			return err
+		}
+	case *ast.PassStatement:
+	case *ast.ImportStatement:
+		if err := c.compileImportStatement(node); err != nil {
+			return err
+		}
+	case *ast.FromImportStatement:
+		if err := c.compileFromImportStatement(node); err != nil {
+			return err
+		}
+	case *ast.ExternStatement:
+		if err := c.compileExternStatement(node); err != nil {
+			return err
+		}
+	case *ast.AssertStatement:
+		if err := c.compileAssertStatement(node); err != nil {
+			return err
+		}
+	case *ast.LambdaExpression:
+		if _, err := c.compileLambda(node, nil); err != nil {
+			return err
+		}
 	case *ast.CallExpression:
 		if err := c.compileCallExpression(node); err != nil {
 			return err
 		}
+	case *ast.ArrayLiteral:
+		if err := c.compileArrayLiteral(node); err != nil {
+			return err
+		}
+	case *ast.HashLiteral:
+		if err := c.compileHashLiteral(node); err != nil {
+			return err
+		}
+	case *ast.TupleLiteral:
+		if err := c.compileTupleLiteral(node); err != nil {
+			return err
+		}
+	case *ast.IndexExpression:
+		if err := c.compileIndexExpression(node); err != nil {
+			return err
+		}
+	case *ast.AttributeExpression:
+		if err := c.compileAttributeExpression(node); err != nil {
+			return err
+		}
 	default:
 		fmt.Println(node)
 		return fmt.Errorf("node not supported")
@@ -1,7 +1,14 @@
 package compiler
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hvuhsg/spython/lexer"
 	"github.com/hvuhsg/spython/parser"
@@ -38,3 +45,1840 @@ func TestWrongTypeAssign(t *testing.T) {
 		t.Errorf("Expecting a wrong type assign error got %s", err.Error())
 	}
 }
+
+func TestBitwiseOnFloat(t *testing.T) {
+	l := lexer.New("a = 1.5\nb = a << 2")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("Got parsing errors %v", p.Errors())
+	}
+
+	err := c.Compile(ast)
+	if err == nil || err.Error() != "unsupported operand types for <<: 'float' and 'int'" {
+		t.Errorf("Expecting a bitwise type error got %v", err)
+	}
+}
+
+func TestShiftCount(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "shift count", options: Options{DebugLeaks: true}, stdout: "8 -4 0 -1 0\nnegative shift count\n", code: `
+x = 1
+n = 64
+print(x << 3, -16 >> 2, x << n, -5 >> n, 5 >> 100)
+m = -1
+try:
+	print(x << m)
+except ValueError as e:
+	print(e.message)
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{"b = (1 < 2) << (1 < 2)", "unsupported operand types for <<: 'bool' and 'bool'"},
+	})
+}
+
+func TestAugmentedAssignWrongType(t *testing.T) {
+	l := lexer.New("a = 1\na += 0.5")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("Got parsing errors %v", p.Errors())
+	}
+
+	err := c.Compile(ast)
+	if err == nil || err.Error() != "unsupported operand types for +: 'int' and 'float'" {
+		t.Errorf("Expecting an operand type error got %v", err)
+	}
+}
+
+func TestAugmentedAssignNotDefined(t *testing.T) {
+	l := lexer.New("a *= 2")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	err := c.Compile(ast)
+	if err == nil || err.Error() != "variable a is not defined" {
+		t.Errorf("Expecting an undefined error for variable 'a' got %v", err)
+	}
+}
+
+func TestAugmentedAssignOrder(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "target loaded before the right side", stdout: "2 3\n", code: `
+class Box:
+	n: int = 0
+
+x = 1
+b = Box()
+b.n = 1
+
+def g() -> int:
+	global x
+	x = 100
+	return 1
+
+def h() -> int:
+	b.n = 100
+	return 2
+
+x += g()
+b.n += h()
+print(x, b.n)
+return 0`},
+	})
+}
+
+func TestUnpackWrongLength(t *testing.T) {
+	l := lexer.New("a, b = 1, 2, 3")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("Got parsing errors %v", p.Errors())
+	}
+
+	err := c.Compile(ast)
+	if err == nil || err.Error() != "can not unpack 3 values into 2 targets" {
+		t.Errorf("Expecting an unpack error got %v", err)
+	}
+}
+
+func TestAnnotatedAssignConversion(t *testing.T) {
+	l := lexer.New("a: float = 3\nb: float = -16777216\nc: int\na = 2\nb = -4\nd: list[float] = [1.5]\nd[0] = 5\ne = {\"k\": 0.5}\ne[\"k\"] = 6")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("Got parsing errors %v", p.Errors())
+	}
+
+	if err := c.Compile(ast); err != nil {
+		t.Errorf("Expecting int literals to convert into float got %s", err.Error())
+	}
+}
+
+func TestAnnotatedAssignWrongType(t *testing.T) {
+	tests := map[string]string{
+		"a: int = 0.5":               "can not assign type float into a",
+		"a: float = 16777217":        "can not convert 16777217 into float without losing precision",
+		"a: int = 1\na: float = 1":   "variable a is already declared with type int",
+		"a: float = 1\na = 16777217": "can not convert 16777217 into float without losing precision",
+		"a: list = 1":                "'list' is not a valid type",
+	}
+
+	for code, expected := range tests {
+		l := lexer.New(code)
+		p := parser.New(&l)
+		c := New()
+		ast := p.ParseProgram()
+
+		err := c.Compile(ast)
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expecting error '%s' for %q got %v", expected, code, err)
+		}
+	}
+}
+
+func TestAssignNoneResult(t *testing.T) {
+	f := `
+def f() -> None:
+	return
+`
+	compileErrors(t, Options{}, []errorTest{
+		{"x = print(1)", "can not assign the result of a function returning None to x"},
+		{f + "x = y = f()", "can not assign the result of a function returning None to x"},
+		{f + "x: int = f()", "can not assign the result of a function returning None to x"},
+	})
+}
+
+func TestGlobalVariables(t *testing.T) {
+	l := lexer.New("count = 0\ndef inc() -> int:\n\tglobal count\n\tcount += 1\n\treturn count\ndef get() -> int:\n\treturn count\ninc()\nreturn get()")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Errorf("Got parsing errors %v", p.Errors())
+	}
+
+	if err := c.Compile(ast); err != nil {
+		t.Fatalf("Expecting functions to use module level variable got %s", err.Error())
+	}
+
+	if !strings.Contains(c.IR(), "@__main__.count = global i64 zeroinitializer") {
+		t.Errorf("Expecting count to be lowered into a global got\n%s", c.IR())
+	}
+}
+
+func TestGlobalWriteWithoutDeclaration(t *testing.T) {
+	l := lexer.New("count = 0\ndef get() -> int:\n\treturn count\ndef reset() -> int:\n\tcount = 0\n\treturn 0")
+	p := parser.New(&l)
+	c := New()
+	ast := p.ParseProgram()
+
+	err := c.Compile(ast)
+	if err == nil || err.Error() != "can not assign into global variable count without declaring 'global count'" {
+		t.Errorf("Expecting a global write error got %v", err)
+	}
+}
+
+func TestGlobalAssignedInFunction(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "declared global", options: Options{DebugLeaks: true}, stdout: "5 6\n6 8\n", code: `
+def setup() -> None:
+	global newname, names
+	newname = 5
+	names = ["a"]
+
+def show() -> int:
+	return newname + len(names)
+
+setup()
+print(newname, show())
+newname += 1
+names.append("b")
+print(newname, show())
+return 0`},
+	})
+}
+
+// The programs of the tests below are raw strings that start on the line
+// after the backtick, the new line before them is not part of the program
+func source(code string) string {
+	return strings.TrimPrefix(code, "\n")
+}
+
+// Compile code with options and run it with lli, returning the exit code, stdout and stderr
+func runProgramWithOptions(t *testing.T, code string, options Options) (int, string, string) {
+	t.Helper()
+
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli is not installed")
+	}
+
+	l := lexer.New(source(code))
+	p := parser.New(&l)
+	c := NewWithOptions(options)
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", p.Errors())
+	}
+
+	if err := c.Compile(ast); err != nil {
+		t.Fatalf("Got compilation error %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "code.ll")
+	if err := os.WriteFile(path, []byte(c.IR()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(lli, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stdout.String(), stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return 0, stdout.String(), stderr.String()
+}
+
+// A program run with lli and the exit status and output it must produce
+type programTest struct {
+	name    string
+	code    string
+	options Options
+	status  int
+	stdout  string
+	stderr  string
+}
+
+func runPrograms(t *testing.T, tests []programTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, stdout, stderr := runProgramWithOptions(t, tt.code, tt.options)
+			if status != tt.status {
+				t.Errorf("Expecting exit status %d got %d", tt.status, status)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("Expecting output %q got %q", tt.stdout, stdout)
+			}
+			if stderr != tt.stderr {
+				t.Errorf("Expecting stderr %q got %q", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+// A program that must fail to compile with an error
+type errorTest struct {
+	code string
+	err  string
+}
+
+func compileErrors(t *testing.T, options Options, tests []errorTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		l := lexer.New(source(tt.code))
+		p := parser.New(&l)
+
+		err := NewWithOptions(options).Compile(p.ParseProgram())
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Expecting error %q for\n%s\ngot %v", tt.err, source(tt.code), err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "list", status: 7 + 17 + 5, code: `
+def squares(n: int) -> list[int]:
+	out: list[int] = []
+	i = 0
+	while i < n:
+		out.append(i * i)
+		i += 1
+	return out
+xs = squares(5)
+xs[0] = 7
+xs[-1] += 1
+return xs[0] + xs[-1] + len(xs)`},
+		{name: "augmented element moved by the right side", stdout: "3 5001\n", code: `
+xs = [1]
+
+def h() -> int:
+	i = 0
+	while i < 5000:
+		xs.append(i)
+		i += 1
+	return 2
+
+xs[0] += h()
+print(xs[0], len(xs))
+return 0`},
+		{name: "index error", status: 1, code: `
+xs = [1, 2, 3]
+return xs[-4]`, stderr: `Traceback (most recent call last):
+  File "<string>", line 2, col 10, in <module>
+IndexError: list index out of range
+`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{"xs = []", "can not infer the type of an empty list, annotate it e.g. xs: list[int] = []"},
+		{"xs = [1, 2.5]", "can not add type float into a list of int"},
+		{`
+xs = [1]
+xs.append(0.5)`, "can not append type float into a list of int"},
+		{`
+xs = [1]
+xs[0.5] = 1`, "list indices must be integers, not float"},
+		{`
+def f(xs: list[int]) -> int:
+	return 0
+f([0.5])`, "can not add type float into a list of int"},
+	})
+}
+
+func TestDict(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "dict", status: (200 + 20 + 100) % 256, code: `
+def count(words: list[str]) -> dict[str, int]:
+	counts: dict[str, int] = {}
+	i = 0
+	while i < len(words):
+		w = words[i]
+		counts[w] = counts.get(w, 0) + 1
+		i += 1
+	return counts
+c = count(["a", 'b', "a"])
+squares: dict[int, float] = {}
+i = 0
+while i < 100:
+	squares[i] = 0.5
+	i += 1
+squares[3] += 2
+if 'z' in c:
+	return 0
+if 99 in squares:
+	return c["a"] * 100 + len(c) * 10 + len(squares)
+return 1`},
+		{name: "augmented element moved by the right side", stdout: "15 201\n", code: `
+d = {1: 5}
+
+def k() -> int:
+	i = 2
+	while i < 202:
+		d[i] = i
+		i += 1
+	return 10
+
+d[1] += k()
+print(d[1], len(d))
+return 0`},
+		{name: "key error", status: 1, code: `
+d = {'x': 1}
+return d['y']`, stderr: `Traceback (most recent call last):
+  File "<string>", line 2, col 9, in <module>
+KeyError: 'y'
+`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{"d = {}", "can not infer the type of an empty dict, annotate it e.g. d: dict[str, int] = {}"},
+		{"d = {1: 2, 'a': 3}", "can not add str: int pair into a dict of int: int"},
+		{"d: dict[list[int], int] = {}", "dict keys must be int, float or str, not list[int]"},
+		{`
+d = {1: 2}
+d['a'] = 1`, "dict keys must be int, not str"},
+		{`
+d = {1: 2}
+return 'a' in d`, "dict keys must be int, not str"},
+		{`
+d = {1: 2}
+return d.get(1, 0.5)`, "get() of a dict of int: int called with int, float"},
+	})
+}
+
+func TestTuple(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "tuple", status: 30 + 2 + 2 + 2 + 3 + 2, code: `
+def divmod(a: int, b: int) -> tuple[int, int]:
+	return a / b, a - (a / b) * b
+q, r = divmod(17, 5)
+p: tuple[int, float] = (2, 1)
+a, b = 1, 2
+a, b = b, a
+pairs = [(q, r)]
+(x, y), z = pairs[0], len(p)
+return q * 10 + r + p[0] + a + x + z`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+t = (1, 2)
+t[0] = 3`, "'tuple' object does not support item assignment"},
+		{`
+t = (1, 2)
+i = 0
+return t[i]`, "tuple indices must be integer constants"},
+		{`
+t = (1, 2)
+return t[-3]`, "tuple index out of range"},
+		{"a, b = (1, 2, 3)", "can not unpack 3 values into 2 targets"},
+		{"a, b = 1", "can not unpack non tuple value into (a, b)"},
+		{"t: tuple[int, float] = (1, 2, 3)", "can not assign type tuple[int, int, int] into t"},
+		{`
+t: tuple[int, float] = (1, 2)
+t = 1`, "can not assign type int into t"},
+	})
+}
+
+func TestClass(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "class", status: 13 + 7 + 5, code: `
+class Point:
+	x: int
+	y: int = 7
+
+	def __init__(self, x: int):
+		self.x = x
+
+	def move(self, dx: int):
+		self.x += dx
+
+	def add(self, other: Point) -> Point:
+		return Point(self.x + other.x)
+
+class Node:
+	value: int
+	next: Node
+
+p = Point(1)
+p.move(2)
+q = p.add(Point(10))
+n = Node()
+n.next = Node()
+n.next.value = 5
+return q.x + q.y + n.next.value`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+class P:
+	x: int
+p = P()
+return p.z`, "'P' object has no attribute 'z'"},
+		{`
+class P:
+	x: int
+p = P()
+p.x = 1.5`, "can not assign type float into p.x"},
+		{`
+class P:
+	x: int
+p = P()
+p.go()`, "'P' object has no method 'go'"},
+		{`
+class P:
+	def f():
+		return 1`, "method 'f' of class 'P' must take self as its first parameter"},
+		{`
+class P:
+	def __init__(self, x: int):
+		return
+p = P()`, "function 'P' takes 1 arguments but 0 were given"},
+		{`
+class P:
+	x = 1`, "class 'P' body can only contain field declarations and methods"},
+		{`
+class P:
+	x: int
+	x: float`, "field 'x' of class 'P' is already declared"},
+		{`
+class P:
+	x: int
+def f(p: P) -> float:
+	return p.x`, "function 'f' declered return type 'float' is not matching actual return type 'int'"},
+	})
+}
+
+func TestMissingReturn(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "every path returns", stdout: "1 -1 0 3\n", code: `
+def sign(n: int) -> int:
+	if n > 0:
+		return 1
+	else:
+		return 0 - 1
+
+def parse(xs: list[int]) -> int:
+	try:
+		return xs[0]
+	except IndexError:
+		return 0
+
+def first(xs: list[int]) -> int:
+	try:
+		return xs[0]
+	finally:
+		xs.append(1)
+
+print(sign(3), sign(0 - 3), parse([]), first([3]))
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+def f(n: int) -> int:
+	if n > 0:
+		return 1
+print(f(0))`, "function 'f' is missing a return statement"},
+		{`
+def f(n: int) -> int:
+	while n > 0:
+		return n`, "function 'f' is missing a return statement"},
+		{`
+class P:
+	def get(self) -> int:
+		print(1)`, "function 'P.get' is missing a return statement"},
+	})
+}
+
+func TestInheritance(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "virtual methods", status: 180 + 10, code: `
+class Shape:
+	scale: int
+
+	def __init__(self, scale: int):
+		self.scale = scale
+
+	def area(self) -> int:
+		return 0
+
+	def describe(self) -> int:
+		return self.area() * 10
+
+class Square(Shape):
+	side: int
+
+	def __init__(self, side: int):
+		super().__init__(2)
+		self.side = side
+
+	def area(self) -> int:
+		return self.side * self.side * self.scale
+
+class Rect(Square):
+	def area(self) -> int:
+		return 1
+
+shapes: list[Shape] = [Shape(1), Square(3), Rect(4)]
+s: Shape = shapes[1]
+t = shapes[0].describe() + s.describe() + shapes[2].describe()
+if isinstance(s, Rect):
+	return 0
+if isinstance(shapes[2], Square):
+	return t
+return 1`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+class A:
+	def f(self) -> int:
+		return 1
+class B(A):
+	def f(self, x: int) -> int:
+		return x`, "method 'f' of class 'B' does not match the signature of A.f"},
+		{`
+class A:
+	def f(self) -> int:
+		return super().f()`, "super() can only be used in a method of a class with a base class"},
+		{`
+class B(C):
+	x: int`, "base class 'C' of class 'B' is not defined"},
+		{`
+class A:
+	x: int
+class B(A):
+	x: float`, "field 'x' of class 'B' is already declared"},
+		{`
+x = 1
+return isinstance(x, int)`, "isinstance() expects an object, got int"},
+		{`
+class A:
+	x: int
+class B(A):
+	y: int
+b: B = A()`, "can not assign type A into b"},
+	})
+}
+
+func TestOperatorOverloading(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "special methods", status: 2, stdout: "Vec 4 18 2 False True 2.5 2.0\n", code: `
+class Vec:
+	x: int
+	y: int
+
+	def __init__(self, x: int, y: int):
+		self.x = x
+		self.y = y
+
+	def __add__(self, other: Vec) -> Vec:
+		return Vec(self.x + other.x, self.y + other.y)
+
+	def __mul__(self, k: int) -> Vec:
+		return Vec(self.x * k, self.y * k)
+
+	def __eq__(self, other: Vec) -> bool:
+		return (self.x == other.x) & (self.y == other.y)
+
+	def __len__(self) -> int:
+		return 2
+
+	def __getitem__(self, i: int) -> int:
+		if i == 0:
+			return self.x
+		return self.y
+
+	def __setitem__(self, i: int, v: int):
+		if i == 0:
+			self.x = v
+		else:
+			self.y = v
+
+	def __str__(self) -> str:
+		return "Vec"
+
+a = Vec(1, 2)
+c = (a + a) * 2
+c[1] += 10
+print(c, c[0], c[1], len(c), c == a, c != a, 2.5, 2.0)
+return len(c)`},
+		{name: "print evaluates its arguments first", stdout: "g 2\ng 0\n2 0\n", code: `
+def g(n: int) -> int:
+	print("g", n)
+	return n
+
+print(g(2), g(0))
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+class A:
+	x: int
+a = A()
+return a + a`, "unsupported operand types for +: 'A' and 'A'"},
+		{`
+class A:
+	x: int
+a = A()
+return a[0]`, "type A is not subscriptable"},
+		{`
+class A:
+	def __len__(self) -> float:
+		return 1.0`, "method '__len__' of class 'A' must return int"},
+		{`
+class A:
+	def __add__(self) -> int:
+		return 1`, "method '__add__' of class 'A' must take 1 arguments besides self"},
+		{`
+class A:
+	def __add__(self, x: int) -> int:
+		return x
+a = A()
+return a + 1.5`, "argument x of function 'A.__add__' expects type int got float"},
+		{"print([1])", "print() does not support type list[int]"},
+	})
+}
+
+func TestClosures(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "closures", stdout: "17 120 8\n", code: `
+def counter(start: int) -> int:
+	count = start
+	def inc(by: int) -> int:
+		nonlocal count
+		count += by
+		return count
+	def twice() -> int:
+		inc(1)
+		return inc(1)
+	inc(5)
+	twice()
+	return count
+
+def fact(n: int) -> int:
+	def go(k: int) -> int:
+		if k < 2:
+			return 1
+		return k * go(k - 1)
+	return go(n)
+
+def outer(x: int) -> int:
+	def middle() -> int:
+		def inner() -> int:
+			return x * 2
+		return inner()
+	x = x + 1
+	return middle()
+
+print(counter(10), fact(5), outer(3))
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{"nonlocal x", "nonlocal declaration not allowed at module level"},
+		{`
+def f():
+	def g():
+		nonlocal y
+		y = 1`, "no binding for nonlocal 'y' found"},
+		{`
+def f():
+	def g() -> int:
+		return x
+	x = 1`, "free variable 'x' referenced before assignment in enclosing scope"},
+		{`
+def f():
+	def g():
+		return
+	def g():
+		return`, "function 'g' is already defined"},
+		{`
+def f():
+	def g(a: int) -> int:
+		return a
+	g()`, "function 'g' takes 1 arguments but 0 were given"},
+	})
+}
+
+func TestFunctionValues(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "function values", status: 12, stdout: "5 107 5\n", code: `
+def add(a: int, b: int) -> int:
+	return a + b
+
+def apply(f: Callable[[int, int], int], x: int, y: int) -> int:
+	return f(x, y)
+
+def make_adder(n: int) -> Callable[[int], int]:
+	def adder(x: int) -> int:
+		return x + n
+	return adder
+
+def compose(f: Callable[[int], int], g: Callable[[int], int]) -> Callable[[int], int]:
+	return lambda x: f(g(x))
+
+op = add
+k = 100
+print(apply(op, 2, 3), apply(lambda a, b: a - b + k, 10, 3), make_adder(2)(3))
+double: Callable[[int], int] = lambda x: x * 2
+fs: list[Callable[[int], int]] = [double, compose(double, make_adder(5))]
+return fs[1](1)`},
+	})
+
+	apply := `
+def apply(f: Callable[[int], int]) -> int:
+	return f(1)
+`
+	compileErrors(t, Options{}, []errorTest{
+		{"f = lambda x: x", "lambda can only be used where a Callable type is expected"},
+		{apply + "apply(lambda x, y: x)", "lambda takes 2 arguments but Callable[[int], int] expects 1"},
+		{apply + `def g(a: float) -> int:
+	return 1
+apply(g)`, "argument f of function 'apply' expects type Callable[[int], int] got Callable[[float], int]"},
+		{"f: Callable[[int], int] = lambda x: 1.5", "lambda returns float but Callable[[int], int] expects int"},
+		{`
+f: Callable[[int], int] = lambda x: x
+f(1.5)`, "argument arg1 of function 'f' expects type int got float"},
+		{`
+x = 1
+(x + 1)(2)`, "'int' object is not callable"},
+	})
+}
+
+func TestReferenceCounting(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "no leaks", options: Options{DebugLeaks: true}, stdout: "7 31 2 120 2\n", code: `
+class Node:
+	children: list[Node] = []
+	value: int = 0
+
+	def __init__(self, value: int) -> None:
+		self.value = value
+
+def tree(depth: int) -> Node:
+	node = Node(depth)
+	if depth > 0:
+		node.children.append(tree(depth - 1))
+		node.children.append(tree(depth - 1))
+	return node
+
+def size(node: Node) -> int:
+	n = 1
+	i = 0
+	while i < len(node.children):
+		n += size(node.children[i])
+		i += 1
+	return n
+
+def counter() -> Callable[[], int]:
+	count = 0
+	def inc() -> int:
+		nonlocal count
+		count += 1
+		return count
+	return inc
+
+def fact(n: int) -> int:
+	def go(k: int) -> int:
+		if k < 2:
+			return 1
+		return k * go(k - 1)
+	return go(n)
+
+root = tree(3)
+root = tree(2)
+c = counter()
+c()
+ages: dict[str, list[int]] = {"a": [1, 2]}
+ages["b"] = [3]
+print(size(root), size(tree(4)), c(), fact(5), len(ages))
+return 0`},
+		{name: "operands reassigned by a later call", options: Options{DebugLeaks: true}, stdout: "1 20\n", code: `
+class Box:
+	xs: list[int] = []
+
+b = Box()
+b.xs = [1, 2]
+
+def clobber() -> int:
+	b.xs = [7, 8]
+	return 0
+
+ys = [10, 20]
+
+def clobber2() -> int:
+	global ys
+	ys = [1]
+	return 1
+
+def take(xs: list[int], i: int) -> int:
+	return xs[i]
+
+print(b.xs[clobber()], take(ys, clobber2()))
+return 0`},
+	})
+
+	// The addresses of leaked objects differ between runs
+	status, _, stderr := runProgramWithOptions(t, `
+class Node:
+	next: list[Node] = []
+
+a = Node()
+b = Node()
+a.next.append(b)
+b.next.append(a)
+return 0`, Options{DebugLeaks: true})
+	if status != 0 {
+		t.Errorf("Expecting exit status 0 got %d", status)
+	}
+	if strings.Count(stderr, "leaked Node object") != 2 || !strings.HasSuffix(stderr, "4 objects leaked\n") {
+		t.Errorf("Expecting the cycle to be reported as leaked got %q", stderr)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "traceback", options: Options{File: "average.sp"}, status: 1, stdout: "2\n", code: `
+def divide(a: int, b: int) -> int:
+	return a / b
+
+def average(xs: list[int]) -> int:
+	total = 0
+	i = 0
+	while i < len(xs):
+		total += xs[i]
+		i += 1
+	return divide(total, len(xs))
+
+print(average([1, 2, 3]))
+empty: list[int] = []
+print(average(empty))
+return 0`, stderr: `Traceback (most recent call last):
+  File "average.sp", line 14, col 14, in <module>
+  File "average.sp", line 10, col 26, in average
+  File "average.sp", line 2, col 11, in divide
+ZeroDivisionError: division by zero
+`},
+		{name: "division", status: 1, code: `
+x = 0
+return 1 / x`, stderr: `Traceback (most recent call last):
+  File "<string>", line 2, col 10, in <module>
+ZeroDivisionError: division by zero
+`},
+		{name: "modulo", status: 1, code: `
+x = 0
+return 1 % x`, stderr: `Traceback (most recent call last):
+  File "<string>", line 2, col 10, in <module>
+ZeroDivisionError: integer modulo by zero
+`},
+		{name: "float division", status: 1, code: `
+x = 0.0
+y = 1.0 / x
+return 0`, stderr: `Traceback (most recent call last):
+  File "<string>", line 2, col 9, in <module>
+ZeroDivisionError: float division by zero
+`},
+	})
+}
+
+func TestExceptions(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "handlers", options: Options{DebugLeaks: true}, stdout: `zero division by zero
+3
+value too big
+finally
+key 'b'
+first
+again list index out of range
+first
+4
+else
+`, code: `
+class TooBig(ValueError):
+	limit: int = 0
+
+	def __init__(self, message: str, limit: int) -> None:
+		super().__init__(message)
+		self.limit = limit
+
+def check(n: int) -> int:
+	if n > 10:
+		raise TooBig("too big", 10)
+	return n
+
+def first(xs: list[int]) -> int:
+	try:
+		return xs[0]
+	finally:
+		print("first")
+
+try:
+	print(1 / (len([1]) - 1))
+except ZeroDivisionError as e:
+	print("zero", e.message)
+
+try:
+	print(check(3))
+	print(check(30))
+except LookupError:
+	print("lookup")
+except ValueError as e:
+	print("value", e.message)
+else:
+	print("else")
+finally:
+	print("finally")
+
+try:
+	ages = {"a": 1}
+	print(ages["b"])
+except (IndexError, KeyError) as e:
+	print("key", e.message)
+
+try:
+	try:
+		first([])
+	except IndexError:
+		raise
+except Exception as e:
+	print("again", e.message)
+
+try:
+	print(first([4]))
+except:
+	print("never")
+else:
+	print("else")
+return 0`},
+		{name: "uncaught", options: Options{File: "parse.sp"}, status: 1, stdout: "cleanup\n", code: `
+def parse(n: int) -> int:
+	if n < 0:
+		raise ValueError("empty string")
+	return 1
+
+try:
+	parse(0 - 1)
+except KeyError:
+	print("key")
+finally:
+	print("cleanup")
+return 0`, stderr: `Traceback (most recent call last):
+  File "parse.sp", line 7, col 7, in <module>
+  File "parse.sp", line 3, col 3, in parse
+ValueError: empty string
+`},
+		{name: "variables assigned in clauses", options: Options{DebugLeaks: true}, stdout: "else 1\ncaught 1\n7 2 1 1\n5\n", code: `
+def first(xs: list[int]) -> int:
+	try:
+		z = xs[0]
+	except IndexError:
+		z = 2
+	return z
+
+def status(n: int) -> int:
+	try:
+		if n > 0:
+			raise ValueError("v")
+		s = "ok"
+	except ValueError as e:
+		s = "caught"
+	else:
+		s = "else"
+	finally:
+		t = 1
+	print(s, t)
+	return t
+
+print(first([7]), first([]), status(0), status(1))
+try:
+	m = 5
+except Exception:
+	m = 6
+print(m)
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{"raise 1", "exceptions must derive from Exception, got int"},
+		{"raise ValueError", "exceptions are raised as objects, e.g. raise ValueError(\"message\")"},
+		{"raise", "no active exception to re-raise"},
+		{"assert 1", "assert condition must be a bool, got int"},
+		{"assert 1 > 0, 2", "assert message must be a str, got int"},
+		{`
+try:
+	x = 1
+except int:
+	x = 2`, "catching classes that do not inherit from Exception is not allowed, got int"},
+	})
+}
+
+func TestAssert(t *testing.T) {
+	code := `
+def mean(xs: list[int]) -> int:
+	assert len(xs) > 0, "mean of an empty list"
+	return 1
+
+try:
+	assert 1 > 2
+except AssertionError:
+	print("caught")
+empty: list[int] = []
+return mean(empty)`
+
+	runPrograms(t, []programTest{
+		{name: "assert", code: code, options: Options{File: "mean.sp"}, status: 1, stdout: "caught\n", stderr: `Traceback (most recent call last):
+  File "mean.sp", line 10, col 12, in <module>
+  File "mean.sp", line 2, col 2, in mean
+AssertionError: mean of an empty list
+`},
+		{name: "no asserts", code: code, options: Options{NoAsserts: true}, status: 1},
+	})
+}
+
+func TestOptional(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "optional", options: Options{DebugLeaks: true}, stdout: "0 -1 None\n6 3 False True\n", code: `
+class Node:
+	value: int = 0
+	next: Optional[Node] = None
+
+	def __init__(self, value: int) -> None:
+		self.value = value
+
+class Empty:
+	pass
+
+def push(head: Node | None, value: int) -> Node:
+	node = Node(value)
+	node.next = head
+	return node
+
+def total(head: Optional[Node]) -> int:
+	n = 0
+	cur = head
+	while cur is not None:
+		n += cur.value
+		cur = cur.next
+	return n
+
+def first(head: Optional[Node]) -> int:
+	if head is None:
+		return 0 - 1
+	return head.value
+
+def noop() -> None:
+	pass
+
+head: Optional[Node] = None
+print(total(head), first(head), head)
+i = 0
+while i < 4:
+	head = push(head, i)
+	i += 1
+noop()
+print(total(head), first(head), head is None, head is not None)
+return 0`},
+		{name: "narrowed by assignment", options: Options{DebugLeaks: true}, stdout: "3 4\nTrue\n", code: `
+class Box:
+	val: int = 0
+
+	def __init__(self, val: int) -> None:
+		self.val = val
+
+q: Box | None = None
+q = Box(3)
+r: Box | None = Box(4)
+print(q.val, r.val)
+if q.val > 0:
+	q = None
+else:
+	q = Box(5)
+print(q is None)
+return 0`},
+	})
+
+	node := `
+class Node:
+	next: Optional[Node] = None
+
+`
+	compileErrors(t, Options{}, []errorTest{
+		{node + `def f(x: Optional[Node]) -> Node:
+	return x.next`, "x may be None, check it with 'is not None' first"},
+		{node + `def f(x: Optional[Node]) -> Node:
+	if x is not None:
+		x = x.next
+		return x
+	return Node()`, "x may be None, check it with 'is not None' first"},
+		{node + `def f(x: Optional[Node]) -> None:
+	if x is None:
+		return
+	while 1 > 0:
+		x = x.next`, "x may be None, check it with 'is not None' first"},
+		{node + `x: Node | None = None
+if 1 > 0:
+	x = Node()
+print(x.next)`, "x may be None, check it with 'is not None' first"},
+		{node + `x: Node | None = Node()
+x = None
+print(x.next)`, "x may be None, check it with 'is not None' first"},
+		{node + `n: Node | None = Node()
+def clear() -> None:
+	global n
+	n = None
+if n is not None:
+	clear()
+	print(n.next)`, "n may be None, check it with 'is not None' first"},
+		{node + `n: Node | None = Node()
+def clear() -> None:
+	global n
+	n = None
+n = Node()
+clear()
+print(n.next)`, "n may be None, check it with 'is not None' first"},
+		{node + `def f() -> None:
+	n: Node | None = Node()
+	def clear() -> None:
+		nonlocal n
+		n = None
+	if n is not None:
+		clear()
+		print(n.next)`, "n may be None, check it with 'is not None' first"},
+		{node + `a = Node()
+if a.next is not None:
+	print(a.next.next)`, "a.next may be None, only variables are narrowed, read it into a variable and check that with 'is not None'"},
+		{"x = None", "can not infer the type of None, declare the variable e.g. x: Optional[T] = None"},
+		{"x: Optional[int] = None", "Optional is only supported for lists, dicts and objects, not int"},
+		{"x: list[int] = None", "can not use None as list[int], only Optional types can be None"},
+		{"print(1 is None)", "'is' is only supported for objects and None, got int"},
+		{"xs: list[int] | None = None\nprint(len(xs))", "object of type list[int] | None has no len()"},
+		{node + "x: Node | None = None\nx: int = 1", "variable x is already declared with type Node | None"},
+		{node + "raise Node()", "exceptions must derive from Exception, got Node"},
+	})
+}
+
+// Write the source files of modules into a directory
+func writeModules(t *testing.T, dir string, modules map[string]string) {
+	t.Helper()
+
+	for name, code := range modules {
+		if err := os.WriteFile(filepath.Join(dir, name+".sp"), []byte(source(code)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, lib := t.TempDir(), t.TempDir()
+	writeModules(t, dir, map[string]string{
+		"shapes": `
+import util
+
+class ShapeError(ValueError):
+	pass
+
+class Circle:
+	r: int = 0
+
+	def __init__(self, r: int) -> None:
+		self.r = r
+
+def area(c: Circle) -> int:
+	return 3 * c.r * c.r
+
+def fail() -> None:
+	raise ShapeError("bad shape")
+
+print("shapes", util.clamp(99))`,
+		"a": "import b",
+		"b": "import a",
+		"list": `
+class Point:
+	x: int = 0
+	y: int = 0
+
+	def __init__(self, x: int, y: int) -> None:
+		self.x = x
+		self.y = y`,
+	})
+	writeModules(t, lib, map[string]string{
+		"util": `
+counter = 0
+
+def clamp(x: int) -> int:
+	if x > 10:
+		return 10
+	return x
+
+def bump() -> None:
+	global counter
+	counter += 1
+
+print("util")`,
+	})
+
+	options := Options{File: filepath.Join(dir, "main.sp"), Path: []string{lib}}
+	runPrograms(t, []programTest{
+		{name: "modules", options: Options{File: options.File, Path: options.Path, DebugLeaks: true}, stdout: "util\nshapes 10\n12 0 10\n1 0\nbad shape\n", code: `
+import shapes
+import util as u
+from util import clamp, counter as hits
+
+def area() -> int:
+	return 0
+
+c: shapes.Circle = shapes.Circle(2)
+print(shapes.area(c), area(), clamp(15))
+u.bump()
+print(u.counter, hits)
+try:
+	shapes.fail()
+except shapes.ShapeError as e:
+	print(e.message)
+return 0`},
+		{name: "module named like a type", options: options, stdout: "3 4 1\n", code: `
+import list
+
+class Point:
+	x: int = 0
+
+p = list.Point(3, 4)
+ps = [Point()]
+print(p.x, p.y, len(ps))
+return 0`},
+		{name: "base class of a module", options: Options{File: options.File, Path: options.Path, DebugLeaks: true}, stdout: "util\nshapes 10\n12 1\n", code: `
+import shapes
+
+class Ring(shapes.Circle):
+	w: int = 0
+
+	def __init__(self, r: int, w: int) -> None:
+		super().__init__(r)
+		self.w = w
+
+r = Ring(2, 1)
+print(shapes.area(r), r.w)
+return 0`},
+	})
+
+	compileErrors(t, options, []errorTest{
+		{"import missing", "no module named 'missing'"},
+		{"import shapes\nx: int = shapes.Circle(1)", "can not assign type shapes.Circle into x"},
+		{"import a", "import cycle: a -> b -> a"},
+		{"import shapes\nclass R(shapes.Square):\n\tx: int", "base class 'shapes.Square' of class 'R' is not defined"},
+		{"from util import nope", "can not import name 'nope' from module 'util'"},
+		{"counter = \"none\"\nfrom util import counter", "can not assign type int into counter"},
+		{`
+import util
+print(util.x)`, "module 'util' has no attribute 'x'"},
+		{`
+def f() -> None:
+	import util
+f()`, "imports are only supported at module level"},
+	})
+}
+
+// Compile code with options, failing the test on errors
+func compileWithOptions(t *testing.T, code string, options Options) *compiler {
+	t.Helper()
+
+	l := lexer.New(source(code))
+	p := parser.New(&l)
+	c := NewWithOptions(options)
+	ast := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", p.Errors())
+	}
+
+	if err := c.Compile(ast); err != nil {
+		t.Fatalf("Got compilation error %s", err.Error())
+	}
+
+	return c
+}
+
+func TestLibrary(t *testing.T) {
+	llvmLink, err := exec.LookPath("llvm-link")
+	if err != nil {
+		t.Skip("llvm-link is not installed")
+	}
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli is not installed")
+	}
+
+	dir := t.TempDir()
+	c := compileWithOptions(t, `
+class Counter:
+	n: int = 0
+
+	def __init__(self, n: int) -> None:
+		self.n = n
+
+	def add(self, k: int) -> int:
+		self.n += k
+		return self.n
+
+counters: list[Counter] = []
+
+def make(n: int) -> Counter:
+	c = Counter(n)
+	counters.append(c)
+	return c
+
+print("counters")`, Options{File: filepath.Join(dir, "counters.sp"), Library: "counters"})
+
+	expected := source(`
+class Counter:
+	n: int
+	def __init__(self, n: int) -> None:
+		pass
+	def add(self, k: int) -> int:
+		pass
+def make(n: int) -> Counter:
+	pass
+counters: list[Counter]
+`)
+	if c.Interface() != expected {
+		t.Errorf("Expecting interface %q got %q", expected, c.Interface())
+	}
+	if strings.Contains(c.IR(), "define i64 @main()") {
+		t.Errorf("Expecting a library without a main function")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "counters.spi"), []byte(c.Interface()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "counters.ll"), []byte(c.IR()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	main := compileWithOptions(t, `
+import counters
+
+c = counters.make(1)
+c.add(2)
+print(c.add(3), len(counters.counters))
+return 0`, Options{File: filepath.Join(dir, "main.sp"), DebugLeaks: true})
+	if err := os.WriteFile(filepath.Join(dir, "main.ll"), []byte(main.IR()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	linked := filepath.Join(dir, "linked.bc")
+	if out, err := exec.Command(llvmLink, filepath.Join(dir, "main.ll"), filepath.Join(dir, "counters.ll"), "-o", linked).CombinedOutput(); err != nil {
+		t.Fatalf("Got link error %s", out)
+	}
+
+	out, err := exec.Command(lli, linked).CombinedOutput()
+	if err != nil || string(out) != "counters\n6 1\n" {
+		t.Errorf("Expecting output %q got %q (%v)", "counters\n6 1\n", out, err)
+	}
+
+	// Calls into the library are type checked against its interface
+	compileErrors(t, Options{File: filepath.Join(dir, "main.sp")}, []errorTest{
+		{`
+import counters
+counters.make("one")`, "argument n of function 'counters.make' expects type int got str"},
+	})
+
+	// A source changed after its interface was written is compiled instead
+	spi := filepath.Join(dir, "counters.spi")
+	if err := os.WriteFile(filepath.Join(dir, "counters.sp"), []byte("def make(n: str) -> str:\n\treturn n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(spi, old, old); err != nil {
+		t.Fatal(err)
+	}
+	compileWithOptions(t, `
+import counters
+counters.make("one")`, Options{File: filepath.Join(dir, "main.sp")})
+
+	now := time.Now().Add(time.Hour)
+	if err := os.Chtimes(spi, now, now); err != nil {
+		t.Fatal(err)
+	}
+	compileErrors(t, Options{File: filepath.Join(dir, "main.sp")}, []errorTest{
+		{`
+import counters
+counters.make("one")`, "argument n of function 'counters.make' expects type int got str"},
+	})
+}
+
+func TestExtern(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "libc", options: Options{DebugLeaks: true}, stdout: "7 5 True\nx=7 2.5\nfreed\n", code: `
+extern def strlen(s: str) -> int
+extern def abs(x: c_int) -> c_int
+extern def malloc(size: int) -> ptr
+extern def free(p: ptr)
+extern def snprintf(buf: ptr, size: int, format: str, ...) -> c_int
+extern def getenv(name: str) -> ptr
+extern def printf(format: str, ...) -> c_int
+
+buf = malloc(64)
+n = snprintf(buf, 64, "%s=%ld %.1f", "x", abs(-7), 2.5)
+print(n, strlen("hello"), getenv("SPYTHON_NO_SUCH_VARIABLE") is None)
+printf("%s\n", buf)
+free(buf)
+print("freed")
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+extern def abs(x: c_int) -> c_int
+abs("a")`, "argument x of function 'abs' expects type int got str"},
+		{`
+extern def abs(x: c_int) -> c_int
+abs(1, 2)`, "function 'abs' takes 1 arguments but 2 were given"},
+		{`
+extern def printf(f: str, ...) -> c_int
+printf()`, "function 'printf' takes at least 1 arguments but 0 were given"},
+		{`
+extern def printf(f: str, ...) -> c_int
+printf("%d", [1])`, "argument 2 of function 'printf' can not be passed to C, got list[int]"},
+		{"extern def f(x: list[int]) -> None", "type list[int] can not be passed to C functions"},
+		{`
+extern def abs(x: c_int) -> c_int
+g = abs`, "extern function 'abs' can only be called"},
+		{"extern def spython_raise() -> None", "extern function 'spython_raise' has a name reserved by the runtime"},
+		{`
+extern def abs(x: c_int) -> c_int
+def abs(x: int) -> int:
+	return x`, "function 'abs' is already defined"},
+	})
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	c := compileWithOptions(t, `
+scale = 2
+
+@export
+def area(w: int, h: int) -> int:
+	return w * h * scale
+
+@export
+def half(x: float) -> float:
+	return x / 2.0
+
+@export
+def check(n: int) -> None:
+	if n < 0:
+		raise ValueError("negative")
+
+print("geometry")`, Options{File: filepath.Join(dir, "geometry.sp"), Library: "geometry"})
+
+	header := c.Header()
+	for _, decl := range []string{"int64_t area(int64_t w, int64_t h);\n", "double half(double x);\n", "void check(int64_t n);\n"} {
+		if !strings.Contains(header, decl) {
+			t.Errorf("Expecting the header to declare %q got\n%s", decl, header)
+		}
+	}
+
+	llc, err := exec.LookPath("llc")
+	if err != nil {
+		t.Skip("llc is not installed")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc is not installed")
+	}
+
+	files := map[string]string{
+		"geometry.ll": c.IR(),
+		"geometry.h":  header,
+		"main.c": source(`
+#include <stdio.h>
+#include "geometry.h"
+
+int main(void) {
+	printf("%ld %.1f\n", (long)area(3, 4), half(5.0));
+	fflush(stdout);
+	check(-1);
+	return 0;
+}
+`),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	obj, bin := filepath.Join(dir, "geometry.o"), filepath.Join(dir, "main")
+	if out, err := exec.Command(llc, "-relocation-model=pic", "-filetype=obj", filepath.Join(dir, "geometry.ll"), "-o", obj).CombinedOutput(); err != nil {
+		t.Fatalf("Got llc error %s", out)
+	}
+	if out, err := exec.Command(cc, filepath.Join(dir, "main.c"), obj, "-o", bin).CombinedOutput(); err != nil {
+		t.Fatalf("Got link error %s", out)
+	}
+
+	// The module level code runs on the first call and an exception the
+	// exported function does not handle exits the process
+	cmd := exec.Command(bin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("Expecting exit status 1 got %v", err)
+	}
+	if stdout.String() != "geometry\n24 2.5\n" {
+		t.Errorf("Expecting output %q got %q", "geometry\n24 2.5\n", stdout.String())
+	}
+	if !strings.HasSuffix(stderr.String(), "ValueError: negative\n") {
+		t.Errorf("Expecting the exception to be reported got %q", stderr.String())
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	compileErrors(t, Options{}, []errorTest{
+		{`
+@export
+def f() -> None:
+	pass`, "function 'f' can only be exported from a library or a module"},
+		{`
+@inline
+def f() -> None:
+	pass`, "decorator '@inline' is not supported"},
+		{`
+class A:
+	@export
+	def f(self) -> None:
+		pass`, "function 'f' can not be exported, only module level functions can"},
+		{`
+def f() -> None:
+	@export
+	def g() -> None:
+		pass
+	g()
+f()`, "function 'g' can not be exported, only module level functions can"},
+	})
+
+	// Exported functions take and return C types
+	compileErrors(t, Options{Library: "stats"}, []errorTest{
+		{`
+@export
+def total(xs: list[int]) -> int:
+	return len(xs)`, "type list[int] can not be passed to C functions"},
+	})
+}
+
+func TestNameMangling(t *testing.T) {
+	runPrograms(t, []programTest{
+		{name: "C names", options: Options{DebugLeaks: true}, stdout: "not exiting 1\n7 4\n", code: `
+stdout = 5
+
+def main() -> int:
+	return 1
+
+def printf(x: int) -> int:
+	return x + stdout
+
+def malloc(n: int) -> list[int]:
+	return [n]
+
+def exit(code: int) -> None:
+	print("not exiting", code)
+
+exit(main())
+print(printf(2), malloc(4)[0])
+return 0`},
+	})
+
+	compileErrors(t, Options{}, []errorTest{
+		{`
+def f() -> int:
+	return 1
+
+def f() -> int:
+	return 2`, "function 'f' is already defined"},
+		{`
+class f:
+	x: int = 0
+
+def f() -> int:
+	return 2`, "name 'f' is already defined as a class"},
+		{`
+def f() -> int:
+	return 2
+
+class f:
+	x: int = 0`, "name 'f' is already defined as a function"},
+		{`
+class abs:
+	x: int = 0
+
+extern def abs(x: c_int) -> c_int`, "name 'abs' is already defined as a class"},
+		{`
+extern def abs(x: c_int) -> c_int
+
+class abs:
+	x: int = 0`, "name 'abs' is already defined as a function"},
+		{`
+def f(a: int, a: int) -> int:
+	return a`, "duplicate parameter 'a' in function 'f'"},
+		{`
+class P:
+	def f(self, self: int) -> None:
+		return`, "duplicate parameter 'self' in function 'f'"},
+		{"g: Callable[[int, int], int] = lambda x, x: x", "duplicate parameter 'x' in lambda"},
+	})
+}
+
+func TestOptimizedIR(t *testing.T) {
+	code := `
+def square(x: int) -> int:
+	y = x * x
+	return y
+
+total = 0
+i = 0
+while i < 4:
+	total += square(i)
+	i += 1
+print(total)
+return 0`
+
+	c := compileWithOptions(t, code, Options{})
+	if ir, err := c.OptimizedIR(); err != nil || ir != c.IR() {
+		t.Errorf("Expecting level 0 to keep the IR as compiled, got error %v", err)
+	}
+
+	if _, err := compileWithOptions(t, code, Options{OptLevel: 4}).OptimizedIR(); err == nil {
+		t.Errorf("Expecting an error for optimisation level 4")
+	}
+
+	// Level 1 only runs the passes of the optimizer package
+	c = compileWithOptions(t, `
+def f(x: int) -> int:
+	y = 2 * 3 + 1
+	if 1 == 2:
+		print("never")
+	return x + y
+
+print(f(1))
+return 0`, Options{OptLevel: 1})
+	c.IR()
+	ir, err := c.OptimizedIR()
+	if err != nil {
+		t.Fatalf("Got optimiser error %s", err)
+	}
+	for _, unexpected := range []string{"mul i64 2, 3", "icmp eq i64 1, 2", "if.then"} {
+		if strings.Contains(ir, unexpected) {
+			t.Errorf("Expecting %q to be optimised out of\n%s", unexpected, ir)
+		}
+	}
+	if !strings.Contains(ir, "store i64 7, i64* %y") {
+		t.Errorf("Expecting y to be folded to 7 in\n%s", ir)
+	}
+	if lli, err := exec.LookPath("lli"); err == nil {
+		path := filepath.Join(t.TempDir(), "code.ll")
+		if err := os.WriteFile(path, []byte(ir), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(lli, path).CombinedOutput()
+		if err != nil || string(out) != "8\n" {
+			t.Errorf("Expecting output %q at level 1 got %q (%v)", "8\n", out, err)
+		}
+	}
+
+	if _, err := exec.LookPath("opt"); err != nil {
+		t.Skip("opt is not installed")
+	}
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("lli is not installed")
+	}
+
+	if _, err := compileWithOptions(t, code, Options{Passes: "no-such-pass"}).OptimizedIR(); err == nil || !strings.HasPrefix(err.Error(), "opt failed") {
+		t.Errorf("Expecting opt to reject an unknown pass got %v", err)
+	}
+
+	for _, options := range []Options{{OptLevel: 2}, {Passes: "mem2reg"}} {
+		ir, err := compileWithOptions(t, code, options).OptimizedIR()
+		if err != nil {
+			t.Fatalf("Got optimiser error %s", err)
+		}
+
+		// Variables are promoted to registers
+		start := strings.Index(ir, "@__main__.square(")
+		if start < 0 {
+			// square was inlined into main
+			start = strings.Index(ir, "@main(")
+		}
+		end := strings.Index(ir[start:], "\n}\n")
+		if body := ir[start : start+end]; strings.Contains(body, "alloca i64") {
+			t.Errorf("Expecting no stack slots with options %+v got\n%s", options, body)
+		}
+
+		path := filepath.Join(t.TempDir(), "code.ll")
+		if err := os.WriteFile(path, []byte(ir), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(lli, path).CombinedOutput()
+		if err != nil || string(out) != "14\n" {
+			t.Errorf("Expecting output %q with options %+v got %q (%v)", "14\n", options, out, err)
+		}
+	}
+}
+
+func TestUnreachableStatements(t *testing.T) {
+	code := `
+def f() -> int:
+	return 1
+	print("after return")
+
+print(f())
+return 0
+print("after module return")`
+
+	runPrograms(t, []programTest{
+		{name: "return", stdout: "1\n", code: code},
+		{name: "raise", stdout: "caught\n", code: `
+def g() -> None:
+	raise ValueError("bad")
+	print("after raise")
+
+try:
+	g()
+	print("after call")
+except ValueError:
+	print("caught")
+raise ValueError("uncaught")
+print("after module raise")`, status: 1, stderr: `Traceback (most recent call last):
+  File "<string>", line 10, col 1, in <module>
+ValueError: uncaught
+`},
+	})
+
+	// The statements after a return are optimised out with the block they are in
+	c := compileWithOptions(t, code, Options{OptLevel: 1})
+	c.IR()
+	ir, err := c.OptimizedIR()
+	if err != nil {
+		t.Fatalf("Got optimiser error %s", err)
+	}
+	if strings.Contains(ir, "unreachable.") {
+		t.Errorf("Expecting the unreachable blocks to be removed from\n%s", ir)
+	}
+}
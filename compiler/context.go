@@ -1,33 +1,61 @@
 package compiler
 
 import (
+	"fmt"
+
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
 type context struct {
 	*ir.Block
-	fn       *ir.Func
-	mod      *ir.Module
-	parent   *context
-	vars     map[string]value.Value
-	regStack []value.Value
+	compiler    *compiler
+	fn          *ir.Func
+	mod         *ir.Module
+	parent      *context
+	vars        map[string]value.Value
+	globalNames map[string]bool  // names declared with 'global' in this function
+	class       *class           // the class of the method being compiled
+	scope       *funcScope       // the names of the function being compiled
+	frame       *frame           // the variables released when the function returns
+	temps       []value.Value    // owned values released at the end of the statement
+	handler     *ir.Block        // where raised exceptions branch to, nil outside try statements
+	finally     []*finallyClause // finally blocks of the enclosing try statements
+	handling    value.Value      // the exception being handled, for a bare raise
+	narrowed    map[string]bool  // optional variables narrowed to their type, or widened again
+	tryClause   bool             // a clause of a try statement, its variables belong to the enclosing block
+	regStack    []value.Value
 }
 
-func newContext(mod *ir.Module, fn *ir.Func, b *ir.Block) *context {
+func newContext(comp *compiler, fn *ir.Func, b *ir.Block) *context {
 	return &context{
-		Block:  b,
-		fn:     fn,
-		mod:    mod,
-		parent: nil,
-		vars:   make(map[string]value.Value),
+		Block:    b,
+		compiler: comp,
+		fn:       fn,
+		mod:      comp.module,
+		parent:   nil,
+		vars:     make(map[string]value.Value),
+		frame:    &frame{},
 	}
 }
 
+// Block names must be unique inside a function, so every block but the
+// entry one is suffixed with its position
 func (c *context) newContext(name string) *context {
+	if n := len(c.fn.Blocks); n > 0 {
+		name = fmt.Sprintf("%s.%d", name, n)
+	}
 	b := c.fn.NewBlock(name)
-	ctx := newContext(c.mod, c.fn, b)
+	ctx := newContext(c.compiler, c.fn, b)
 	ctx.parent = c
+	ctx.class = c.class
+	ctx.scope = c.scope
+	ctx.frame = c.frame
+	ctx.handler = c.handler
+	ctx.finally = c.finally
+	ctx.handling = c.handling
 	return ctx
 }
 
@@ -36,13 +64,96 @@ func (c *context) getVar(name string) value.Value {
 		return v
 	} else if c.parent != nil {
 		return c.parent.getVar(name)
+	} else if global, ok := c.compiler.ns.globals[name]; ok {
+		return global
 	} else {
 		return nil
 	}
 }
 
+// Module level code is compiled into the main function, or into the init
+// function of an imported module
+func (c *context) isModuleLevel() bool {
+	return c.fn == c.compiler.ns.init
+}
+
+func (c *context) isDeclaredGlobal(name string) bool {
+	if c.globalNames[name] {
+		return true
+	} else if c.parent != nil {
+		return c.parent.isDeclaredGlobal(name)
+	} else {
+		return false
+	}
+}
+
+// Create the storage of a new variable, module level variables used by
+// functions or by importing modules are stored in LLVM globals and anything
+// else on the stack
+func (c *context) newVariable(name string, typ types.Type) value.Value {
+	ns := c.compiler.ns
+	if (c.isModuleLevel() && (ns.name != "" || ns.sharedNames[name])) || c.isDeclaredGlobal(name) {
+		global := c.mod.NewGlobalDef(ns.symbol(name), constant.NewZeroInitializer(typ))
+		ns.globals[name] = global
+		return global
+	}
+
+	if c.scope != nil && c.scope.captured[name] {
+		return c.newCell(name, typ)
+	}
+
+	return c.newLocal(name, typ)
+}
+
 func (c *context) createVar(name string, val value.Value) {
-	c.vars[name] = val
+	c.binding().vars[name] = val
+}
+
+// The context new variables are bound in, a variable assigned in a clause of
+// a try statement is still defined after the statement
+func (c *context) binding() *context {
+	for c.tryClause && c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+// Allocate stack space for a local variable in the function entry block,
+// so a variable created inside a loop does not grow the stack every iteration.
+// A variable holding references starts zeroed and is released on return
+func (c *context) newLocal(name string, typ types.Type) *ir.InstAlloca {
+	entry := c.fn.Blocks[0]
+	alloca := ir.NewAlloca(typ)
+	alloca.SetName(c.frame.uniqueName(name))
+
+	insts := []ir.Instruction{alloca}
+	if c.compiler.isManaged(typ) {
+		insts = append(insts, ir.NewStore(constant.NewZeroInitializer(typ), alloca))
+		c.frame.locals = append(c.frame.locals, alloca)
+	}
+	entry.Insts = append(insts, entry.Insts...)
+
+	return alloca
+}
+
+// Allocate a heap cell for a local variable that nested functions capture,
+// so they share it with the function and can outlive its stack frame
+func (c *context) newCell(name string, typ types.Type) value.Value {
+	entry := c.fn.Blocks[0]
+
+	var drop constant.Constant = constant.NewNull(dropFuncType)
+	if c.compiler.isManaged(typ) {
+		drop = c.compiler.cellDropFunc(typ)
+	}
+
+	raw := ir.NewCall(c.compiler.allocFunc(), sizeOf(typ), drop, c.compiler.cString("cell"))
+	cell := ir.NewBitCast(raw, types.NewPointer(typ))
+	cell.SetName(name + ".cell")
+	zero := ir.NewStore(constant.NewZeroInitializer(typ), cell)
+	entry.Insts = append([]ir.Instruction{raw, cell, zero}, entry.Insts...)
+	c.frame.cells = append(c.frame.cells, cell)
+
+	return cell
 }
 
 func (c *context) pushReg(val value.Value) {
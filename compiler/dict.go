@@ -0,0 +1,423 @@
+package compiler
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Dicts are pointers to a heap allocated open addressing hash table
+// { i64 len, i64 cap, K* keys, V* values, i8* used }, cap is a power of two
+const (
+	dictLen = iota
+	dictCap
+	dictKeys
+	dictValues
+	dictUsed
+)
+
+const dictMinCap = 8
+
+// The type of dict[key, val], its struct type is defined in the module on first use
+func (comp *compiler) dictOf(key, val types.Type) *types.PointerType {
+	name := "dict." + typeTag(key) + "." + typeTag(val)
+
+	st, ok := comp.dicts[name]
+	if !ok {
+		st = types.NewStruct(Int, Int, types.NewPointer(key), types.NewPointer(val), I8Ptr)
+		comp.module.NewTypeDef(name, st)
+		comp.dicts[name] = st
+	}
+
+	return types.NewPointer(st)
+}
+
+// Get the key and value types of a dict type
+func (comp *compiler) dictElems(typ types.Type) (types.Type, types.Type, bool) {
+	ptr, ok := typ.(*types.PointerType)
+	if !ok {
+		return nil, nil, false
+	}
+
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok || comp.dicts[st.Name()] != st {
+		return nil, nil, false
+	}
+
+	key := st.Fields[dictKeys].(*types.PointerType).ElemType
+	val := st.Fields[dictValues].(*types.PointerType).ElemType
+	return key, val, true
+}
+
+// Only types with a hash function can be dict keys
+func isHashable(typ types.Type) bool {
+	return typ.Equal(Int) || typ.Equal(Float) || typ.Equal(Str)
+}
+
+func dictFuncName(key, val types.Type, op string) string {
+	return "spython_dict_" + typeTag(key) + "_" + typeTag(val) + "_" + op
+}
+
+// Pointer to a field of a dict
+func dictField(b *ir.Block, dict value.Value, field int64) value.Value {
+	st := dict.Type().(*types.PointerType).ElemType
+	return b.NewGetElementPtr(st, dict, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// Load the address of the i-th entry of one of the dict arrays
+func dictEntry(b *ir.Block, dict value.Value, field int64, i value.Value) value.Value {
+	arrTyp := dict.Type().(*types.PointerType).ElemType.(*types.StructType).Fields[field].(*types.PointerType)
+	arr := b.NewLoad(arrTyp, dictField(b, dict, field))
+	return b.NewGetElementPtr(arrTyp.ElemType, arr, i)
+}
+
+// Allocate the arrays of a dict with the given capacity
+func (comp *compiler) dictAllocArrays(b *ir.Block, dict value.Value, key, val types.Type, capacity value.Value) {
+	calloc := comp.libcFunc("calloc")
+
+	keys := b.NewCall(calloc, capacity, sizeOf(key))
+	vals := b.NewCall(calloc, capacity, sizeOf(val))
+	used := b.NewCall(calloc, capacity, constant.NewInt(Int, 1))
+
+	b.NewStore(b.NewBitCast(keys, types.NewPointer(key)), dictField(b, dict, dictKeys))
+	b.NewStore(b.NewBitCast(vals, types.NewPointer(val)), dictField(b, dict, dictValues))
+	b.NewStore(used, dictField(b, dict, dictUsed))
+	b.NewStore(capacity, dictField(b, dict, dictCap))
+	b.NewStore(constant.NewInt(Int, 0), dictField(b, dict, dictLen))
+}
+
+// dict* spython_dict_K_V_new() allocates an empty dict
+func (comp *compiler) dictNewFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "new"), func(name string) *ir.Func {
+		dictTyp := comp.dictOf(key, val)
+		fn := comp.module.NewFunc(name, dictTyp)
+
+		entry := fn.NewBlock("entry")
+		st := dictTyp.ElemType.(*types.StructType)
+		dict := comp.alloc(entry, st, comp.dictDropFunc(key, val), st.Name())
+		comp.dictAllocArrays(entry, dict, key, val, constant.NewInt(Int, dictMinCap))
+		entry.NewRet(dict)
+
+		return fn
+	})
+}
+
+// void spython_dict_K_V_drop(i8* dict) releases the values of a dict and frees its arrays
+func (comp *compiler) dictDropFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "drop"), func(name string) *ir.Func {
+		raw := ir.NewParam("dict", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, raw)
+
+		dictTyp := comp.dictOf(key, val)
+		entry := fn.NewBlock("entry")
+		dict := entry.NewBitCast(raw, dictTyp)
+
+		if comp.isManaged(val) {
+			loop := fn.NewBlock("loop")
+			check := fn.NewBlock("check")
+			release := fn.NewBlock("release")
+			next := fn.NewBlock("next")
+			done := fn.NewBlock("done")
+
+			capacity := entry.NewLoad(Int, dictField(entry, dict, dictCap))
+			entry.NewBr(loop)
+
+			i := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, 0), entry))
+			loop.NewCondBr(loop.NewICmp(enum.IPredSLT, i, capacity), check, done)
+
+			used := check.NewLoad(types.I8, dictEntry(check, dict, dictUsed, i))
+			check.NewCondBr(check.NewICmp(enum.IPredNE, used, constant.NewInt(types.I8, 0)), release, next)
+
+			comp.release(release, release.NewLoad(val, dictEntry(release, dict, dictValues, i)))
+			release.NewBr(next)
+
+			i.Incs = append(i.Incs, ir.NewIncoming(next.NewAdd(i, constant.NewInt(Int, 1)), next))
+			next.NewBr(loop)
+
+			entry = done
+		}
+
+		free := comp.libcFunc("free")
+		for _, field := range []int64{dictKeys, dictValues, dictUsed} {
+			arr := entry.NewLoad(dictTyp.ElemType.(*types.StructType).Fields[field], dictField(entry, dict, field))
+			entry.NewCall(free, entry.NewBitCast(arr, I8Ptr))
+		}
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// i64 spython_dict_K_V_find(dict* d, K key) returns the slot holding key,
+// or the empty slot where it should be inserted
+func (comp *compiler) dictFindFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "find"), func(name string) *ir.Func {
+		dict := ir.NewParam("dict", comp.dictOf(key, val))
+		k := ir.NewParam("key", key)
+		fn := comp.module.NewFunc(name, Int, dict, k)
+
+		entry := fn.NewBlock("entry")
+		loop := fn.NewBlock("loop")
+		check := fn.NewBlock("check")
+		next := fn.NewBlock("next")
+		done := fn.NewBlock("done")
+
+		capacity := entry.NewLoad(Int, dictField(entry, dict, dictCap))
+		mask := entry.NewSub(capacity, constant.NewInt(Int, 1))
+		hash := entry.NewCall(comp.hashFunc(key), k)
+		start := entry.NewAnd(hash, mask)
+		entry.NewBr(loop)
+
+		i := loop.NewPhi(ir.NewIncoming(start, entry))
+		used := loop.NewLoad(types.I8, dictEntry(loop, dict, dictUsed, i))
+		empty := loop.NewICmp(enum.IPredEQ, used, constant.NewInt(types.I8, 0))
+		loop.NewCondBr(empty, done, check)
+
+		current := check.NewLoad(key, dictEntry(check, dict, dictKeys, i))
+		check.NewCondBr(comp.keysEqual(check, key, current, k), done, next)
+
+		following := next.NewAnd(next.NewAdd(i, constant.NewInt(Int, 1)), mask)
+		i.Incs = append(i.Incs, ir.NewIncoming(following, next))
+		next.NewBr(loop)
+
+		done.NewRet(i)
+
+		return fn
+	})
+}
+
+// void spython_dict_K_V_grow(dict* d) doubles the capacity and reinserts every entry
+func (comp *compiler) dictGrowFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "grow"), func(name string) *ir.Func {
+		dictTyp := comp.dictOf(key, val)
+		dict := ir.NewParam("dict", dictTyp)
+		fn := comp.module.NewFunc(name, types.Void, dict)
+
+		entry := fn.NewBlock("entry")
+		loop := fn.NewBlock("loop")
+		body := fn.NewBlock("body")
+		reinsert := fn.NewBlock("reinsert")
+		next := fn.NewBlock("next")
+		exit := fn.NewBlock("exit")
+
+		// Keep the old table in a stack copy while the arrays are replaced
+		old := entry.NewAlloca(dictTyp.ElemType)
+		entry.NewStore(entry.NewLoad(dictTyp.ElemType, dict), old)
+		oldCap := entry.NewLoad(Int, dictField(entry, dict, dictCap))
+		comp.dictAllocArrays(entry, dict, key, val, entry.NewMul(oldCap, constant.NewInt(Int, 2)))
+		entry.NewBr(loop)
+
+		i := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, 0), entry))
+		loop.NewCondBr(loop.NewICmp(enum.IPredEQ, i, oldCap), exit, body)
+
+		used := body.NewLoad(types.I8, dictEntry(body, old, dictUsed, i))
+		body.NewCondBr(body.NewICmp(enum.IPredNE, used, constant.NewInt(types.I8, 0)), reinsert, next)
+
+		k := reinsert.NewLoad(key, dictEntry(reinsert, old, dictKeys, i))
+		v := reinsert.NewLoad(val, dictEntry(reinsert, old, dictValues, i))
+		slot := reinsert.NewCall(comp.dictFindFunc(key, val), dict, k)
+		reinsert.NewStore(constant.NewInt(types.I8, 1), dictEntry(reinsert, dict, dictUsed, slot))
+		reinsert.NewStore(k, dictEntry(reinsert, dict, dictKeys, slot))
+		reinsert.NewStore(v, dictEntry(reinsert, dict, dictValues, slot))
+		length := reinsert.NewLoad(Int, dictField(reinsert, dict, dictLen))
+		reinsert.NewStore(reinsert.NewAdd(length, constant.NewInt(Int, 1)), dictField(reinsert, dict, dictLen))
+		reinsert.NewBr(next)
+
+		i.Incs = append(i.Incs, ir.NewIncoming(next.NewAdd(i, constant.NewInt(Int, 1)), next))
+		next.NewBr(loop)
+
+		free := comp.libcFunc("free")
+		for _, field := range []int64{dictKeys, dictValues, dictUsed} {
+			arr := exit.NewLoad(dictTyp.ElemType.(*types.StructType).Fields[field], dictField(exit, old, field))
+			exit.NewCall(free, exit.NewBitCast(arr, I8Ptr))
+		}
+		exit.NewRet(nil)
+
+		return fn
+	})
+}
+
+// V* spython_dict_K_V_slot(dict* d, K key) returns the address of the value of key,
+// inserting key with a zero value when it is missing
+func (comp *compiler) dictSlotFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "slot"), func(name string) *ir.Func {
+		dict := ir.NewParam("dict", comp.dictOf(key, val))
+		k := ir.NewParam("key", key)
+		fn := comp.module.NewFunc(name, types.NewPointer(val), dict, k)
+
+		entry := fn.NewBlock("entry")
+		grow := fn.NewBlock("grow")
+		find := fn.NewBlock("find")
+		insert := fn.NewBlock("insert")
+		found := fn.NewBlock("found")
+
+		// Grow when the table would become more than 3/4 full
+		length := entry.NewLoad(Int, dictField(entry, dict, dictLen))
+		capacity := entry.NewLoad(Int, dictField(entry, dict, dictCap))
+		filled := entry.NewMul(entry.NewAdd(length, constant.NewInt(Int, 1)), constant.NewInt(Int, 4))
+		full := entry.NewICmp(enum.IPredSGT, filled, entry.NewMul(capacity, constant.NewInt(Int, 3)))
+		entry.NewCondBr(full, grow, find)
+
+		grow.NewCall(comp.dictGrowFunc(key, val), dict)
+		grow.NewBr(find)
+
+		slot := find.NewCall(comp.dictFindFunc(key, val), dict, k)
+		used := find.NewLoad(types.I8, dictEntry(find, dict, dictUsed, slot))
+		find.NewCondBr(find.NewICmp(enum.IPredEQ, used, constant.NewInt(types.I8, 0)), insert, found)
+
+		insert.NewStore(constant.NewInt(types.I8, 1), dictEntry(insert, dict, dictUsed, slot))
+		insert.NewStore(k, dictEntry(insert, dict, dictKeys, slot))
+		insert.NewStore(constant.NewZeroInitializer(val), dictEntry(insert, dict, dictValues, slot))
+		newLength := insert.NewLoad(Int, dictField(insert, dict, dictLen))
+		insert.NewStore(insert.NewAdd(newLength, constant.NewInt(Int, 1)), dictField(insert, dict, dictLen))
+		insert.NewBr(found)
+
+		found.NewRet(dictEntry(found, dict, dictValues, slot))
+
+		return fn
+	})
+}
+
+// V* spython_dict_K_V_at(dict* d, K key) returns the address of the value of key,
+// or null when the key is missing
+func (comp *compiler) dictAtFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "at"), func(name string) *ir.Func {
+		dict := ir.NewParam("dict", comp.dictOf(key, val))
+		k := ir.NewParam("key", key)
+		fn := comp.module.NewFunc(name, types.NewPointer(val), dict, k)
+
+		entry := fn.NewBlock("entry")
+		missing := fn.NewBlock("missing")
+		found := fn.NewBlock("found")
+
+		slot := entry.NewCall(comp.dictFindFunc(key, val), dict, k)
+		used := entry.NewLoad(types.I8, dictEntry(entry, dict, dictUsed, slot))
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, used, constant.NewInt(types.I8, 0)), missing, found)
+
+		missing.NewRet(constant.NewNull(types.NewPointer(val)))
+
+		found.NewRet(dictEntry(found, dict, dictValues, slot))
+
+		return fn
+	})
+}
+
+// i1 spython_dict_K_V_contains(dict* d, K key)
+func (comp *compiler) dictContainsFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "contains"), func(name string) *ir.Func {
+		dict := ir.NewParam("dict", comp.dictOf(key, val))
+		k := ir.NewParam("key", key)
+		fn := comp.module.NewFunc(name, types.I1, dict, k)
+
+		entry := fn.NewBlock("entry")
+		slot := entry.NewCall(comp.dictFindFunc(key, val), dict, k)
+		used := entry.NewLoad(types.I8, dictEntry(entry, dict, dictUsed, slot))
+		entry.NewRet(entry.NewICmp(enum.IPredNE, used, constant.NewInt(types.I8, 0)))
+
+		return fn
+	})
+}
+
+// V spython_dict_K_V_get(dict* d, K key, V default) returns the value of key or default when it is missing
+func (comp *compiler) dictGetFunc(key, val types.Type) *ir.Func {
+	return comp.runtimeFunc(dictFuncName(key, val, "get"), func(name string) *ir.Func {
+		dict := ir.NewParam("dict", comp.dictOf(key, val))
+		k := ir.NewParam("key", key)
+		def := ir.NewParam("default", val)
+		fn := comp.module.NewFunc(name, val, dict, k, def)
+
+		entry := fn.NewBlock("entry")
+		missing := fn.NewBlock("missing")
+		found := fn.NewBlock("found")
+
+		slot := entry.NewCall(comp.dictFindFunc(key, val), dict, k)
+		used := entry.NewLoad(types.I8, dictEntry(entry, dict, dictUsed, slot))
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, used, constant.NewInt(types.I8, 0)), missing, found)
+
+		missing.NewRet(def)
+		found.NewRet(found.NewLoad(val, dictEntry(found, dict, dictValues, slot)))
+
+		return fn
+	})
+}
+
+// The message of the KeyError of a missing key, the key as Python shows it
+func (comp *compiler) keyRepr(b *ir.Block, key types.Type, k value.Value) value.Value {
+	var format constant.Constant
+	switch {
+	case key.Equal(Int):
+		format = comp.cString("%ld")
+	case key.Equal(Float):
+		format = comp.cString("%g")
+		k = b.NewFPExt(k, types.Double)
+	default:
+		format = comp.cString("'%s'")
+	}
+
+	size := b.NewAdd(b.NewSExt(b.NewCall(comp.libcFunc("snprintf"), constant.NewNull(I8Ptr), constant.NewInt(types.I64, 0), format, k), types.I64), constant.NewInt(types.I64, 1))
+	buf := b.NewCall(comp.libcFunc("malloc"), size)
+	b.NewCall(comp.libcFunc("snprintf"), buf, size, format, k)
+	return buf
+}
+
+// Compare two keys of a hashable type
+func (comp *compiler) keysEqual(b *ir.Block, key types.Type, x, y value.Value) value.Value {
+	switch {
+	case key.Equal(Float):
+		return b.NewFCmp(enum.FPredOEQ, x, y)
+	case key.Equal(Str):
+		cmp := b.NewCall(comp.libcFunc("strcmp"), x, y)
+		return b.NewICmp(enum.IPredEQ, cmp, constant.NewInt(types.I32, 0))
+	default:
+		return b.NewICmp(enum.IPredEQ, x, y)
+	}
+}
+
+// i64 spython_hash_T(T key)
+func (comp *compiler) hashFunc(key types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_hash_"+typeTag(key), func(name string) *ir.Func {
+		k := ir.NewParam("key", key)
+		fn := comp.module.NewFunc(name, Int, k)
+		entry := fn.NewBlock("entry")
+
+		switch {
+		case key.Equal(Str):
+			// FNV-1a over the bytes of the string
+			loop := fn.NewBlock("loop")
+			next := fn.NewBlock("next")
+			done := fn.NewBlock("done")
+			entry.NewBr(loop)
+
+			i := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, 0), entry))
+			hash := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, -3750763034362895579), entry))
+			char := loop.NewLoad(types.I8, loop.NewGetElementPtr(types.I8, k, i))
+			loop.NewCondBr(loop.NewICmp(enum.IPredEQ, char, constant.NewInt(types.I8, 0)), done, next)
+
+			mixed := next.NewMul(next.NewXor(hash, next.NewZExt(char, Int)), constant.NewInt(Int, 1099511628211))
+			i.Incs = append(i.Incs, ir.NewIncoming(next.NewAdd(i, constant.NewInt(Int, 1)), next))
+			hash.Incs = append(hash.Incs, ir.NewIncoming(mixed, next))
+			next.NewBr(loop)
+
+			done.NewRet(hash)
+		case key.Equal(Float):
+			// 0.0 and -0.0 are equal keys so they must hash the same
+			isZero := entry.NewFCmp(enum.FPredOEQ, k, constant.NewFloat(Float, 0))
+			bits := entry.NewZExt(entry.NewBitCast(k, types.I32), Int)
+			x := entry.NewSelect(isZero, constant.NewInt(Int, 0), bits)
+			entry.NewRet(mixInt(entry, x))
+		default:
+			entry.NewRet(mixInt(entry, k))
+		}
+
+		return fn
+	})
+}
+
+// Spread the bits of an integer so close keys land in different slots
+func mixInt(b *ir.Block, x value.Value) value.Value {
+	x = b.NewXor(x, b.NewLShr(x, constant.NewInt(Int, 33)))
+	x = b.NewMul(x, constant.NewInt(Int, -49064778989728563))
+	return b.NewXor(x, b.NewLShr(x, constant.NewInt(Int, 33)))
+}
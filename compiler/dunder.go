@@ -0,0 +1,81 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Special methods called for operators on objects
+var operatorMethods = map[string]string{
+	token.Plus:     "__add__",
+	token.Minus:    "__sub__",
+	token.Asterisk: "__mul__",
+	token.Equal:    "__eq__",
+	token.NotEqual: "__eq__",
+	token.LessThan: "__lt__",
+}
+
+// The number of parameters, without self, and the return type that special
+// methods must have, a nil return type allows any type
+var specialMethods = map[string]struct {
+	params int
+	ret    types.Type
+}{
+	"__add__":     {1, nil},
+	"__sub__":     {1, nil},
+	"__mul__":     {1, nil},
+	"__eq__":      {1, Bool},
+	"__lt__":      {1, Bool},
+	"__getitem__": {1, nil},
+	"__setitem__": {2, None},
+	"__len__":     {0, Int},
+	"__str__":     {0, Str},
+}
+
+// Check the signature of a special method when it is declared
+func (comp *compiler) checkSpecialMethod(cls *class, fn *ir.Func, name string, tok token.Token) error {
+	special, ok := specialMethods[name]
+	if !ok {
+		return nil
+	}
+
+	if len(fn.Params)-1 != special.params {
+		return newError(fmt.Sprintf("method '%s' of class '%s' must take %d arguments besides self", name, cls.name, special.params), TypeError, tok)
+	}
+
+	if special.ret != nil && !fn.Sig.RetType.Equal(special.ret) {
+		return newError(fmt.Sprintf("method '%s' of class '%s' must return %s", name, cls.name, comp.displayType(special.ret)), TypeError, tok)
+	}
+
+	return nil
+}
+
+// Call a special method of an object with already compiled arguments,
+// reports false if the class of the object does not define it
+func (c *context) callSpecialMethod(obj value.Value, name string, args []value.Value, tok token.Token) (value.Value, bool, error) {
+	cls, ok := c.compiler.classOf(obj.Type())
+	if !ok {
+		return nil, false, nil
+	}
+
+	fn, ok := cls.method(name)
+	if !ok {
+		return nil, false, nil
+	}
+
+	for i, arg := range args {
+		param := fn.Params[i+1]
+		arg = c.upcast(arg, param.Type())
+		if !arg.Type().Equal(param.Type()) {
+			return nil, true, newError(fmt.Sprintf("argument %s of function '%s' expects type %s got %s", param.Name(), displayName(fn), c.compiler.displayType(param.Type()), c.compiler.displayType(arg.Type())), TypeError, tok)
+		}
+		args[i] = arg
+	}
+
+	c.setLocation(tok)
+	return c.callMethod(obj, cls, name, args), true, nil
+}
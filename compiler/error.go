@@ -6,6 +6,7 @@ const (
 	NameError = iota
 	TypeError
 	UnsupportedError
+	ImportError
 )
 
 type CompilationError int
@@ -14,6 +15,7 @@ type compileError struct {
 	Msg   string
 	Type  CompilationError
 	Token token.Token
+	File  string // the module the error is in, empty for the compiled program
 }
 
 func (c compileError) Error() string {
@@ -23,3 +25,13 @@ func (c compileError) Error() string {
 func newError(msg string, typ CompilationError, tok token.Token) compileError {
 	return compileError{Msg: msg, Type: typ, Token: tok}
 }
+
+// Attribute an error to the file of the imported module it was found in
+func inFile(err error, file string) error {
+	if err, ok := err.(compileError); ok && err.File == "" {
+		err.File = file
+		return err
+	}
+
+	return err
+}
@@ -0,0 +1,439 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/lexer"
+	"github.com/hvuhsg/spython/parser"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Exceptions are objects of classes derived from the built-in Exception
+// class. Raising an exception stores it in the spython_exception global and
+// branches to the handler of the innermost try statement, or to a block that
+// releases the frame and returns a zero value when there is none. Every call
+// is followed by a check of spython_exception, so an exception raised by the
+// callee continues unwinding in the caller. An exception that main does not
+// handle is reported by spython_panic.
+
+// The built-in exception classes and their base classes, in definition order
+var builtinExceptions = []struct{ name, base string }{
+	{"ArithmeticError", "Exception"},
+	{"ZeroDivisionError", "ArithmeticError"},
+	{"LookupError", "Exception"},
+	{"IndexError", "LookupError"},
+	{"KeyError", "LookupError"},
+	{"ValueError", "Exception"},
+	{"AssertionError", "Exception"},
+}
+
+// A finally block a return statement runs before it leaves its try statement
+type finallyClause struct {
+	body    *ast.BlockStatement
+	handler *ir.Block // the handler of the code around the try statement
+}
+
+// The source of the built-in exception classes, they are compiled like user
+// classes so programs can derive from them
+func exceptionSource() string {
+	var out strings.Builder
+
+	out.WriteString("class Exception:\n\tmessage: str = \"\"\n\n")
+	out.WriteString("\tdef __init__(self, message: str) -> None:\n\t\tself.message = message\n\n")
+	out.WriteString("\tdef __str__(self) -> str:\n\t\treturn self.message\n\n")
+
+	for _, exc := range builtinExceptions {
+		fmt.Fprintf(&out, "class %s(%s):\n", exc.name, exc.base)
+		out.WriteString("\tdef __init__(self, message: str) -> None:\n\t\tsuper().__init__(message)\n\n")
+	}
+
+	return out.String()
+}
+
+// Compile the built-in exception classes before the program
+func (c *context) compileExceptionClasses() error {
+	l := lexer.New(exceptionSource())
+	p := parser.New(&l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		panic(fmt.Sprintf("can not parse the built-in exceptions: %v", p.Errors()))
+	}
+
+	scopes, _ := resolveScopes(program)
+	for node, scope := range scopes {
+		c.compiler.scopes[node] = scope
+	}
+
+	// They are defined in a namespace of their own, visible in every module
+	programNs := c.compiler.ns
+	c.compiler.ns = c.compiler.builtins
+	defer func() { c.compiler.ns = programNs }()
+
+	for _, statement := range program.Statements {
+		if err := c.compile(statement); err != nil {
+			return err
+		}
+	}
+
+	if err := c.compiler.compilePendingBodies(); err != nil {
+		return err
+	}
+
+	// Every compilation unit defines them, like the runtime
+	for _, cls := range c.compiler.builtins.classes {
+		cls.vtable.Linkage = enum.LinkageLinkOnceODR
+		cls.ctor.Linkage = enum.LinkageLinkOnceODR
+		for _, fn := range cls.methods {
+			fn.Linkage = enum.LinkageLinkOnceODR
+		}
+	}
+
+	return nil
+}
+
+func (comp *compiler) exceptionClass() *class {
+	return comp.builtins.classes["Exception"]
+}
+
+// The pending exception, it holds a reference to the exception
+func (comp *compiler) currentException() *ir.Global {
+	if comp.exception == nil {
+		comp.exception = comp.runtimeGlobal("spython_exception", constant.NewNull(comp.exceptionClass().ptrType()))
+	}
+
+	return comp.exception
+}
+
+// The block that handles an exception raised in the context, the function
+// returns a zero value when the exception is not raised in a try statement
+// and main reports it
+func (c *context) unwindTarget() *ir.Block {
+	if c.handler != nil {
+		return c.handler
+	}
+
+	if c.frame.unwind == nil {
+		unwind := c.newContext("unwind")
+		switch {
+		case c.fn == c.compiler.function:
+			excTyp := c.compiler.exceptionClass().ptrType()
+			unwind.NewCall(c.compiler.panicFunc(), unwind.NewLoad(excTyp, c.compiler.currentException()))
+			unwind.NewUnreachable()
+		case c.fn.Sig.RetType.Equal(None):
+			unwind.NewRet(nil)
+		default:
+			unwind.NewRet(constant.NewZeroInitializer(c.fn.Sig.RetType))
+		}
+		c.frame.unwind = unwind.Block
+	}
+
+	return c.frame.unwind
+}
+
+// The block an exception raised in the middle of a statement branches to,
+// it releases the temporaries of the statement
+func (c *context) unwindBlock() *ir.Block {
+	if len(c.temps) == 0 {
+		return c.unwindTarget()
+	}
+
+	return c.landing(c.unwindTarget(), c.temps...)
+}
+
+// A block that releases values and branches to target
+func (c *context) landing(target *ir.Block, vals ...value.Value) *ir.Block {
+	landing := c.newContext("unwind.release")
+	for _, val := range vals {
+		c.compiler.release(landing.Block, val)
+	}
+	landing.NewBr(target)
+
+	return landing.Block
+}
+
+// Continue unwinding after a call when the callee raised an exception
+func (c *context) checkException() {
+	excTyp := c.compiler.exceptionClass().ptrType()
+	pending := c.NewLoad(excTyp, c.compiler.currentException())
+	raised := c.NewICmp(enum.IPredNE, pending, constant.NewNull(excTyp))
+
+	cont := c.newContext("call.ok")
+	c.NewCondBr(raised, c.unwindBlock(), cont.Block)
+	c.Block = cont.Block
+}
+
+// Raise an exception, the raise takes the reference to exc, this terminates
+// the current block
+func (c *context) raise(exc value.Value, tok token.Token) {
+	file := c.compiler.cString(c.compiler.fileName())
+	row := constant.NewInt(Int, int64(tok.Row+1))
+	col := constant.NewInt(Int, int64(tok.Col+1))
+	exc = c.upcast(exc, c.compiler.exceptionClass().ptrType())
+
+	c.NewCall(c.compiler.raiseFunc(), exc, file, row, col)
+	c.NewBr(c.unwindBlock())
+}
+
+// Raise a built-in exception with a message
+func (c *context) raiseError(excType string, msg value.Value, tok token.Token) {
+	c.raise(c.NewCall(c.compiler.builtins.classes[excType].ctor, msg), tok)
+}
+
+// Raise a built-in exception when failed is true, the message is built in
+// the block that raises it
+func (c *context) check(failed value.Value, excType string, msg func(b *ir.Block) value.Value, tok token.Token) {
+	fail := c.newContext("check.fail")
+	ok := c.newContext("check.ok")
+	c.NewCondBr(failed, fail.Block, ok.Block)
+
+	fail.temps = c.temps
+	fail.raiseError(excType, msg(fail.Block), tok)
+	c.Block = ok.Block
+}
+
+func (c *context) compileRaiseStatement(raiseStat *ast.RaiseStatement) error {
+	excTyp := c.compiler.exceptionClass().ptrType()
+
+	// A bare raise raises the exception being handled again
+	if raiseStat.Exception == nil {
+		if c.handling == nil {
+			return newError("no active exception to re-raise", TypeError, raiseStat.Token)
+		}
+		exc := c.NewLoad(excTyp, c.handling)
+		c.compiler.retain(c.Block, exc)
+		c.raise(exc, raiseStat.Token)
+		return nil
+	}
+
+	if ident, ok := raiseStat.Exception.(*ast.Identifier); ok && c.getVar(ident.Value) == nil {
+		if _, ok := c.compiler.lookupClass(ident.Value); ok {
+			return newError(fmt.Sprintf("exceptions are raised as objects, e.g. raise %s(\"message\")", ident.Value), TypeError, raiseStat.Token)
+		}
+	}
+
+	if err := c.compile(raiseStat.Exception); err != nil {
+		return err
+	}
+	exc := c.popReg()
+
+	cls, ok := c.compiler.classOf(exc.Type())
+	if !ok || !cls.isSubclassOf(c.compiler.exceptionClass()) {
+		return newError(fmt.Sprintf("exceptions must derive from Exception, got %s", c.compiler.displayType(exc.Type())), TypeError, raiseStat.Token)
+	}
+
+	c.compiler.retain(c.Block, exc)
+	c.raise(exc, raiseStat.Token)
+	return nil
+}
+
+// try statements are compiled into the blocks:
+//
+//	try        the body, its exceptions branch to except
+//	try.else   runs when the body did not raise
+//	except     tests the pending exception against every except clause
+//	finally    runs after all of them, a pending exception is stashed while
+//	           it runs and continues unwinding after it
+func (c *context) compileTryStatement(tryStat *ast.TryStatement) error {
+	excTyp := c.compiler.exceptionClass().ptrType()
+	end := c.newContext("try.end")
+	outer := c.unwindTarget()
+
+	// Where the clauses continue when they complete and when they raise
+	finallyNormal, finallyRaised := end.Block, outer
+	finally := c.finally
+	var fin, resume *context
+	if tryStat.Finally != nil {
+		normal := c.newContext("finally.normal")
+		raised := c.newContext("finally.raised")
+		fin = c.newContext("finally")
+		finallyNormal, finallyRaised = normal.Block, raised.Block
+		finally = append(finally[:len(finally):len(finally)], &finallyClause{body: tryStat.Finally, handler: c.handler})
+
+		normal.NewBr(fin.Block)
+
+		exc := raised.NewLoad(excTyp, c.compiler.currentException())
+		raised.NewStore(constant.NewNull(excTyp), c.compiler.currentException())
+		raised.NewBr(fin.Block)
+
+		// An exception raised by the finally block replaces the stashed one
+		stash := fin.NewPhi(ir.NewIncoming(constant.NewNull(excTyp), normal.Block), ir.NewIncoming(exc, raised.Block))
+		fin.handler = c.landing(outer, stash)
+		fin.tryClause = true
+		if err := fin.compile(tryStat.Finally); err != nil {
+			return err
+		}
+
+		if fin.Term == nil {
+			resume = c.newContext("finally.resume")
+			resume.NewStore(stash, c.compiler.currentException())
+			resume.NewBr(outer)
+			fin.NewCondBr(fin.NewICmp(enum.IPredNE, stash, constant.NewNull(excTyp)), resume.Block, end.Block)
+		}
+	}
+
+	dispatch := c.newContext("except")
+	body := c.newContext("try")
+	body.handler = dispatch.Block
+	body.finally = finally
+	body.tryClause = true
+	c.NewBr(body.Block)
+
+	if err := body.compile(tryStat.Body); err != nil {
+		return err
+	}
+
+	if body.Term == nil {
+		if tryStat.Else != nil {
+			els := c.newContext("try.else")
+			els.handler = finallyRaised
+			els.finally = finally
+			els.tryClause = true
+			body.NewBr(els.Block)
+
+			if err := els.compile(tryStat.Else); err != nil {
+				return err
+			}
+			if els.Term == nil {
+				els.NewBr(finallyNormal)
+			}
+		} else {
+			body.NewBr(finallyNormal)
+		}
+	}
+
+	exc := dispatch.NewLoad(excTyp, c.compiler.currentException())
+	for _, clause := range tryStat.Handlers {
+		classes, err := c.exceptClasses(clause)
+		if err != nil {
+			return err
+		}
+
+		handler := c.newContext("except.handler")
+		handler.handler = finallyRaised
+		handler.finally = finally
+
+		// A bare except and except Exception handle every exception
+		next := c.newContext("except.next")
+		if classes[0] == c.compiler.exceptionClass() {
+			dispatch.NewBr(handler.Block)
+		} else {
+			vtable := dispatch.NewLoad(I8Ptr, dispatch.NewBitCast(exc, types.NewPointer(I8Ptr)))
+			var match value.Value = constant.False
+			for _, cls := range classes {
+				isinstance := dispatch.NewCall(c.compiler.isinstanceFunc(), vtable, constant.NewBitCast(cls.vtable, I8Ptr))
+				match = dispatch.NewOr(match, isinstance)
+			}
+			dispatch.NewCondBr(match, handler.Block, next.Block)
+		}
+
+		if err := handler.compileExceptClause(clause, exc, commonBase(classes)); err != nil {
+			return err
+		}
+		if handler.Term == nil {
+			handler.NewBr(finallyNormal)
+		}
+
+		dispatch = next
+	}
+
+	// No clause handles the exception, it continues unwinding
+	if dispatch.Term == nil {
+		dispatch.NewBr(finallyRaised)
+	}
+
+	// When no clause completes normally the finally block only runs while
+	// unwinding, the code after the try statement is not reached through it
+	if resume != nil && !reachable(c.fn, finallyNormal) {
+		fin.NewBr(resume.Block)
+	}
+
+	c.Block = end.Block
+	return nil
+}
+
+// Take the pending exception and run the body of an except clause
+func (c *context) compileExceptClause(clause *ast.ExceptClause, exc value.Value, cls *class) error {
+	excTyp := c.compiler.exceptionClass().ptrType()
+
+	// The handler holds the exception while it runs, so a bare raise can raise it again
+	handling := c.newLocal("exc", excTyp)
+	c.store(exc, handling)
+	c.compiler.release(c.Block, exc)
+	c.NewStore(constant.NewNull(excTyp), c.compiler.currentException())
+	c.handling = handling
+
+	if clause.Name != nil {
+		if err := c.assignIdentifier(clause.Name, c.NewBitCast(exc, cls.ptrType()), nil, clause.Name.Token); err != nil {
+			return err
+		}
+	}
+
+	// The exception name stays local to the handler, unlike its other variables
+	c.tryClause = true
+	return c.compile(clause.Body)
+}
+
+// The exception classes an except clause handles, Exception for a bare except
+func (c *context) exceptClasses(clause *ast.ExceptClause) ([]*class, error) {
+	if clause.Type == nil {
+		return []*class{c.compiler.exceptionClass()}, nil
+	}
+
+	exps := []ast.Expression{clause.Type}
+	if tuple, ok := clause.Type.(*ast.TupleLiteral); ok {
+		exps = tuple.Elements
+	}
+
+	classes := make([]*class, 0, len(exps))
+	for _, exp := range exps {
+		cls, ok := c.classNamed(exp)
+		if !ok || !cls.isSubclassOf(c.compiler.exceptionClass()) {
+			return nil, newError(fmt.Sprintf("catching classes that do not inherit from Exception is not allowed, got %s", exp.String()), TypeError, clause.Token)
+		}
+		classes = append(classes, cls)
+	}
+
+	return classes, nil
+}
+
+// The most derived class all the classes derive from
+func commonBase(classes []*class) *class {
+	base := classes[0]
+	for _, cls := range classes[1:] {
+		for !cls.isSubclassOf(base) {
+			base = base.base
+		}
+	}
+
+	return base
+}
+
+// Run the finally blocks of the try statements a return statement leaves,
+// the innermost first. An exception raised by one releases the returned value
+func (c *context) runFinally(retVal value.Value) error {
+	finally, handler := c.finally, c.handler
+	defer func() {
+		c.finally, c.handler = finally, handler
+	}()
+
+	for i := len(finally) - 1; i >= 0 && c.Term == nil; i-- {
+		c.finally = finally[:i]
+		c.handler = finally[i].handler
+		if retVal != nil && c.compiler.isManaged(retVal.Type()) {
+			c.handler = c.landing(c.unwindTarget(), retVal)
+		}
+
+		if err := c.compile(finally[i].body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A function decorated with @export is also defined under its own name with
+// the C calling convention and C types, so C code can call it. Its C
+// function runs the module level code on the first call, and an exception
+// it does not handle is reported like in a program and exits the process
+type exportFunc struct {
+	name   string
+	fn     *ir.Func     // the C function
+	params []types.Type // the type of every parameter
+	ret    types.Type   // the type of the result
+}
+
+// Check the decorators of a function, only module level functions can be
+// exported
+func (c *context) checkDecorators(funcLit *ast.FunctionLiteral, exportable bool) (bool, error) {
+	export := false
+	for _, decorator := range funcLit.Decorators {
+		if decorator.Value != "export" {
+			return false, newError(fmt.Sprintf("decorator '@%s' is not supported", decorator.Value), UnsupportedError, decorator.Token)
+		}
+		if !exportable {
+			return false, newError(fmt.Sprintf("function '%s' can not be exported, only module level functions can", funcLit.TokenLiteral()), UnsupportedError, decorator.Token)
+		}
+		export = true
+	}
+
+	return export, nil
+}
+
+// Define the C function of an exported function
+func (c *context) exportFunction(funcLit *ast.FunctionLiteral, callee *ir.Func) error {
+	name := funcLit.TokenLiteral()
+	ns := c.compiler.ns
+
+	// The module level code of the program runs in main, C code can not
+	// load it before calling its functions
+	if ns.name == "" {
+		return newError(fmt.Sprintf("function '%s' can only be exported from a library or a module", name), UnsupportedError, funcLit.Token)
+	}
+	if name == "main" || strings.HasPrefix(name, "spython_") {
+		return newError(fmt.Sprintf("exported function '%s' has a name reserved by the runtime", name), NameError, funcLit.Token)
+	}
+	if _, ok := c.compiler.runtime[name]; ok {
+		return newError(fmt.Sprintf("exported function '%s' has the name of a C function", name), NameError, funcLit.Token)
+	}
+	for _, export := range c.compiler.exports {
+		if export.name == name {
+			return newError(fmt.Sprintf("function '%s' is already exported", name), NameError, funcLit.Token)
+		}
+	}
+
+	ret, cRet, err := c.resolveCType(funcLit.ReturnType, funcLit.Token)
+	if err != nil {
+		return err
+	}
+
+	export := &exportFunc{name: name, ret: ret}
+	params := make([]*ir.Param, 0, len(funcLit.Parameters))
+	for _, param := range funcLit.Parameters {
+		typ, cTyp, err := c.resolveCType(param.Type, param.Token)
+		if err != nil {
+			return err
+		}
+		export.params = append(export.params, typ)
+		params = append(params, ir.NewParam(param.TokenLiteral(), cTyp))
+	}
+
+	export.fn = c.mod.NewFunc(name, cRet, params...)
+	export.fn.CallingConv = enum.CallingConvC
+	c.compiler.exports = append(c.compiler.exports, export)
+
+	// A separately compiled module defines the C function
+	if ns.separate {
+		return nil
+	}
+
+	fn := export.fn
+	comp := c.compiler
+	excTyp := comp.exceptionClass().ptrType()
+	entry := fn.NewBlock("entry")
+	load := fn.NewBlock("load")
+	call := fn.NewBlock("call")
+	unhandled := fn.NewBlock("unhandled")
+
+	// Raise the pending exception of the module level code or the function
+	raised := func(b *ir.Block, next *ir.Block) {
+		pending := b.NewLoad(excTyp, comp.currentException())
+		b.NewCondBr(b.NewICmp(enum.IPredNE, pending, constant.NewNull(excTyp)), unhandled, next)
+	}
+
+	entry.NewCondBr(entry.NewLoad(Bool, ns.loaded), call, load)
+	load.NewCall(ns.init)
+	raised(load, call)
+
+	args := make([]value.Value, 0, len(params))
+	for _, param := range params {
+		var arg value.Value = param
+		switch {
+		case param.Type().Equal(types.Double):
+			arg = call.NewFPTrunc(arg, Float)
+		case param.Type().Equal(types.I32):
+			arg = call.NewSExt(arg, Int)
+		}
+		args = append(args, arg)
+	}
+
+	var result value.Value = call.NewCall(callee, args...)
+	done := fn.NewBlock("done")
+	raised(call, done)
+
+	switch {
+	case cRet.Equal(None):
+		done.NewRet(nil)
+	case cRet.Equal(types.Double):
+		done.NewRet(done.NewFPExt(result, types.Double))
+	case cRet.Equal(types.I32):
+		done.NewRet(done.NewTrunc(result, types.I32))
+	default:
+		done.NewRet(result)
+	}
+
+	unhandled.NewCall(comp.panicFunc(), unhandled.NewLoad(excTyp, comp.currentException()))
+	unhandled.NewUnreachable()
+
+	return nil
+}
+
+// Header returns a C header declaring the exported functions of the
+// compiled module, to call them from C or cgo
+func (c *compiler) Header() string {
+	guard := "SPYTHON_" + strings.ToUpper(strings.ReplaceAll(c.ns.name, ".", "_")) + "_H"
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "/* Exported functions of the SPython module %s */\n\n", c.ns.name)
+	fmt.Fprintf(&out, "#ifndef %s\n#define %s\n\n#include <stdint.h>\n\n", guard, guard)
+	out.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+
+	for _, export := range c.exports {
+		decls := make([]string, 0, len(export.params))
+		for i, typ := range export.params {
+			decls = append(decls, cDeclaration(typ, export.fn.Params[i].Type(), export.fn.Params[i].Name()))
+		}
+		if len(decls) == 0 {
+			decls = append(decls, "void")
+		}
+
+		fmt.Fprintf(&out, "%s(%s);\n", cDeclaration(export.ret, export.fn.Sig.RetType, export.name), strings.Join(decls, ", "))
+	}
+
+	out.WriteString("\n#ifdef __cplusplus\n}\n#endif\n\n")
+	fmt.Fprintf(&out, "#endif /* %s */\n", guard)
+
+	return out.String()
+}
+
+// The C declaration of a name of a type passed as the C type cTyp
+func cDeclaration(typ types.Type, cTyp types.Type, name string) string {
+	switch {
+	case typ.Equal(Str):
+		return "const char *" + name
+	case typ.Equal(Ptr):
+		return "void *" + name
+	case typ.Equal(None):
+		return "void " + name
+	case cTyp.Equal(types.Double):
+		return "double " + name
+	case cTyp.Equal(types.I32):
+		return "int " + name
+	default:
+		return "int64_t " + name
+	}
+}
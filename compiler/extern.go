@@ -0,0 +1,215 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// ptr is an untyped C pointer, e.g. a FILE* or a buffer allocated by a C
+// function. It can be stored, passed back to C functions and compared with None
+var Ptr = types.NewPointer(&types.StructType{TypeName: "ptr", Opaque: true})
+
+// A C function declared with extern def
+type externFunc struct {
+	name   string
+	decl   *ast.ExternStatement
+	callee value.Value     // the C function, bitcast when it is declared with another signature
+	sig    *types.FuncType // the C signature
+	params []types.Type    // the type of every fixed parameter
+	ret    types.Type      // the type of the result
+}
+
+// Declare the ptr type in the LLVM module the first time it is used
+func (comp *compiler) declarePtr() {
+	if !comp.ptrDeclared {
+		comp.module.NewTypeDef("ptr", Ptr.ElemType)
+		comp.ptrDeclared = true
+	}
+}
+
+func (c *context) compileExternStatement(externStat *ast.ExternStatement) error {
+	name := externStat.Name.Value
+	ns := c.compiler.ns
+
+	if !c.isModuleLevel() {
+		return newError(fmt.Sprintf("extern function '%s' must be declared at module level", name), UnsupportedError, externStat.Token)
+	}
+
+	if _, ok := ns.functions[name]; ok {
+		return newError(fmt.Sprintf("function '%s' is already defined", name), NameError, externStat.Token)
+	}
+	if err := ns.checkNewName("function", name, externStat.Token); err != nil {
+		return err
+	}
+	if name == "main" || strings.HasPrefix(name, "spython_") {
+		return newError(fmt.Sprintf("extern function '%s' has a name reserved by the runtime", name), NameError, externStat.Token)
+	}
+
+	ret, cRet, err := c.resolveCType(externStat.ReturnType, externStat.Token)
+	if err != nil {
+		return err
+	}
+
+	ext := &externFunc{name: name, decl: externStat, ret: ret}
+	params := make([]*ir.Param, 0, len(externStat.Parameters))
+	for _, param := range externStat.Parameters {
+		paramName := param.TokenLiteral()
+		if param.Type == nil {
+			return newError(fmt.Sprintf("parameter '%s' of function '%s' has no type annotation", paramName, name), TypeError, externStat.Token)
+		}
+
+		typ, cTyp, err := c.resolveCType(param.Type, param.Token)
+		if err != nil {
+			return err
+		}
+		if typ.Equal(None) {
+			return newError(fmt.Sprintf("parameter '%s' of function '%s' can not be None", paramName, name), TypeError, externStat.Token)
+		}
+
+		ext.params = append(ext.params, typ)
+		params = append(params, ir.NewParam(paramName, cTyp))
+	}
+
+	paramTyps := make([]types.Type, 0, len(params))
+	for _, param := range params {
+		paramTyps = append(paramTyps, param.Type())
+	}
+	ext.sig = types.NewFunc(cRet, paramTyps...)
+	ext.sig.Variadic = externStat.Variadic
+	ext.callee = c.compiler.cFunc(name, ext.sig, params)
+
+	ns.externs[name] = ext
+	return nil
+}
+
+// The type of an extern parameter or result and the C type it is passed as,
+// a float is passed as a C double and a c_int as a C int
+func (c *context) resolveCType(annotation ast.Expression, tok token.Token) (types.Type, types.Type, error) {
+	if ident, ok := annotation.(*ast.Identifier); ok && ident.Value == "c_int" {
+		return Int, types.I32, nil
+	}
+
+	typ, err := c.resolveType(annotation, tok)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case typ.Equal(Float):
+		return Float, types.Double, nil
+	case typ.Equal(Int), typ.Equal(Str), typ.Equal(Ptr), typ.Equal(None):
+		return typ, typ, nil
+	default:
+		return nil, nil, newError(fmt.Sprintf("type %s can not be passed to C functions", c.compiler.displayType(typ)), TypeError, tok)
+	}
+}
+
+// Declare a C function once in the LLVM module. A libc function the runtime
+// uses keeps the declaration of the runtime, an extern declaring it with
+// another signature calls it through a bitcast
+func (comp *compiler) cFunc(name string, sig *types.FuncType, params []*ir.Param) value.Value {
+	fn, ok := comp.runtime[name]
+	if !ok {
+		fn = comp.declareLibc(name)
+		if fn == nil {
+			fn = comp.module.NewFunc(name, sig.RetType, params...)
+			fn.Sig.Variadic = sig.Variadic
+		}
+		fn.CallingConv = enum.CallingConvC
+		comp.runtime[name] = fn
+	}
+
+	if fn.Sig.Equal(sig) {
+		return fn
+	}
+	return constant.NewBitCast(fn, types.NewPointer(sig))
+}
+
+// Call a C function, the arguments are converted to the C types of its
+// parameters and the extra arguments of a variadic function get the C
+// default argument promotions
+func (c *context) compileExternCall(ext *externFunc, funcName string, arguments []ast.Expression, tok token.Token) error {
+	fixed := len(ext.params)
+	if ext.sig.Variadic && len(arguments) < fixed {
+		return newError(fmt.Sprintf("function '%s' takes at least %d arguments but %d were given", funcName, fixed, len(arguments)), TypeError, tok)
+	}
+	if !ext.sig.Variadic && len(arguments) != fixed {
+		return newError(fmt.Sprintf("function '%s' takes %d arguments but %d were given", funcName, fixed, len(arguments)), TypeError, tok)
+	}
+
+	args := make([]value.Value, 0, len(arguments))
+	for i, arg := range arguments {
+		if i >= fixed {
+			reg, err := c.compileVariadicArgument(arg, i+1, funcName, tok)
+			if err != nil {
+				return err
+			}
+			args = append(args, reg)
+			continue
+		}
+
+		typ := ext.params[i]
+		reg, err := c.compileExpected(arg, typ, tok)
+		if err != nil {
+			return err
+		}
+
+		// A str is a buffer of bytes C functions can read and write
+		if typ.Equal(Ptr) && reg.Type().Equal(Str) {
+			reg = c.NewBitCast(reg, Ptr)
+		}
+
+		if !reg.Type().Equal(typ) {
+			return newError(fmt.Sprintf("argument %s of function '%s' expects type %s got %s", ext.decl.Parameters[i].TokenLiteral(), funcName, c.compiler.displayType(typ), c.compiler.displayType(reg.Type())), TypeError, tok)
+		}
+
+		switch cTyp := ext.sig.Params[i]; {
+		case cTyp.Equal(types.Double):
+			reg = c.NewFPExt(reg, types.Double)
+		case cTyp.Equal(types.I32):
+			reg = c.NewTrunc(reg, types.I32)
+		}
+		args = append(args, reg)
+	}
+
+	var result value.Value = c.NewCall(ext.callee, args...)
+	switch cRet := ext.sig.RetType; {
+	case cRet.Equal(types.Double):
+		result = c.NewFPTrunc(result, Float)
+	case cRet.Equal(types.I32):
+		result = c.NewSExt(result, Int)
+	}
+
+	c.pushReg(result)
+	return nil
+}
+
+// Compile an extra argument of a variadic C function, a float is passed as
+// a double and a bool as an int
+func (c *context) compileVariadicArgument(arg ast.Expression, position int, funcName string, tok token.Token) (value.Value, error) {
+	if err := c.compile(arg); err != nil {
+		return nil, err
+	}
+	reg := c.popReg()
+
+	switch typ := reg.Type(); {
+	case reg == noneValue:
+		return constant.NewNull(I8Ptr), nil
+	case typ.Equal(Float):
+		return c.NewFPExt(reg, types.Double), nil
+	case typ.Equal(Bool):
+		return c.NewZExt(reg, types.I32), nil
+	case typ.Equal(Int), typ.Equal(Str), typ.Equal(Ptr):
+		return reg, nil
+	default:
+		return nil, newError(fmt.Sprintf("argument %d of function '%s' can not be passed to C, got %s", position, funcName, c.compiler.displayType(typ)), TypeError, tok)
+	}
+}
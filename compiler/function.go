@@ -4,82 +4,328 @@ import (
 	"fmt"
 
 	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
 func (c *context) compileFunctionLiteral(funcLit *ast.FunctionLiteral) error {
+	export, err := c.checkDecorators(funcLit, c.isModuleLevel())
+	if err != nil {
+		return err
+	}
+
+	if !c.isModuleLevel() {
+		return c.compileNestedFunction(funcLit)
+	}
+
+	// A function imported from another module can be redefined, like any
+	// other name the module binds
+	ns := c.compiler.ns
 	name := funcLit.TokenLiteral()
-	retTyp, ok := nameToType[funcLit.ReturnType.Value]
-	if !ok {
-		return newError(fmt.Sprintf("return type for function '%s' is not a valid type", name), NameError, funcLit.Token)
+	if err := ns.checkNewName("function", name, funcLit.Token); err != nil {
+		return err
+	}
+
+	fn, err := c.declareFunction(funcLit, ns.symbol(name), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	ns.functions[name] = fn
+	if export {
+		return c.exportFunction(funcLit, fn)
+	}
+	return nil
+}
+
+// Declare a function in the module under the given symbol name and queue its
+// body, a method of self takes the object as its first, unannotated, parameter
+// and a nested function takes its env as a hidden first parameter
+func (c *context) declareFunction(funcLit *ast.FunctionLiteral, symbol string, self *class, env *types.StructType) (*ir.Func, error) {
+	name := funcLit.TokenLiteral()
+	retTyp, err := c.resolveType(funcLit.ReturnType, funcLit.Token)
+	if err != nil {
+		return nil, newError(fmt.Sprintf("return type for function '%s' is not a valid type", name), NameError, funcLit.Token)
+	}
+
+	if self != nil && (len(funcLit.Parameters) == 0 || funcLit.Parameters[0].Type != nil) {
+		return nil, newError(fmt.Sprintf("method '%s' of class '%s' must take self as its first parameter", name, self.name), TypeError, funcLit.Token)
 	}
 
 	params := make([]*ir.Param, 0)
-	for _, param := range funcLit.Parameters {
+	for i, param := range funcLit.Parameters {
 		paramName := param.TokenLiteral()
-		paramTyp, ok := nameToType[param.Type.Value]
-		if !ok {
-			return newError(fmt.Sprintf("parameter type '%s' is not a valid type", paramName), NameError, funcLit.Token)
+		if hasParam(params, paramName) {
+			return nil, newError(fmt.Sprintf("duplicate parameter '%s' in function '%s'", paramName, name), NameError, funcLit.Token)
+		}
+		if param.Type == nil {
+			if self == nil || i != 0 {
+				return nil, newError(fmt.Sprintf("parameter '%s' of function '%s' has no type annotation", paramName, name), TypeError, funcLit.Token)
+			}
+			params = append(params, ir.NewParam(paramName, self.ptrType()))
+			continue
+		}
+
+		paramTyp, err := c.resolveType(param.Type, param.Token)
+		if err != nil {
+			return nil, newError(fmt.Sprintf("parameter type '%s' is not a valid type", paramName), NameError, funcLit.Token)
 		}
 		params = append(params, ir.NewParam(paramName, paramTyp))
 	}
 
-	fn := c.mod.NewFunc(name, retTyp, params...)
-	block := fn.NewBlock("entry_" + name)
-	ctx := newContext(c.mod, fn, block)
+	fnParams := params
+	var envParam *ir.Param
+	if env != nil {
+		envParam = ir.NewParam("env", I8Ptr)
+		fnParams = append([]*ir.Param{envParam}, params...)
+	}
 
-	// Load params into function local vars
-	for _, param := range params {
-		ctx.createVar(param.Name(), param)
+	fn := c.mod.NewFunc(symbol, retTyp, fnParams...)
+	scope := c.compiler.scopes[funcLit]
+
+	// A separately compiled module defines the function
+	if c.compiler.ns.separate {
+		return fn, nil
 	}
 
-	if err := ctx.compile(funcLit.Body); err != nil {
-		return err
+	// The body is compiled after the module level code so it can use
+	// module level variables assigned after the function definition
+	c.queueBody(scope, func() error {
+		block := fn.NewBlock("entry_" + name)
+		ctx := newContext(c.compiler, fn, block)
+		ctx.class = self
+		ctx.scope = scope
+		ctx.enterFunction(name)
+
+		if envParam != nil {
+			ctx.loadEnv(envParam, env)
+		}
+
+		// A nested function calls itself through its own env, capturing the
+		// variable holding it would make the env reference itself
+		if scope.self {
+			binding := ctx.newVariable(name, c.compiler.callableOfFunc(fn))
+			ctx.store(ctx.newCallable(fn, envParam), binding)
+			ctx.createVar(name, binding)
+		}
+
+		ctx.spillParams(params)
+
+		if err := ctx.compile(funcLit.Body); err != nil {
+			return err
+		}
+
+		// A function returning None may end without a return statement, any
+		// other function must return on every path that reaches the end
+		if ctx.Term == nil {
+			if retTyp.Equal(None) {
+				ctx.NewRet(nil)
+			} else if reachable(fn, ctx.Block) {
+				return newError(fmt.Sprintf("function '%s' is missing a return statement", displayName(fn)), TypeError, funcLit.Token)
+			} else {
+				ctx.NewUnreachable()
+			}
+		}
+
+		ctx.releaseFrame()
+		return nil
+	})
+
+	return fn, nil
+}
+
+// Queue a function body, compiled once. The body of a function declaring
+// names 'global' is compiled early when code using one of the names runs
+// before it, see lookupVar
+func (c *context) queueBody(scope *funcScope, body func() error) {
+	compiled := false
+	once := func() error {
+		if compiled {
+			return nil
+		}
+		compiled = true
+		return body()
 	}
 
-	return nil
+	c.compiler.pendingBodies = append(c.compiler.pendingBodies, once)
+	ns := c.compiler.ns
+	for name := range scope.globals {
+		ns.globalBodies[name] = append(ns.globalBodies[name], once)
+	}
+}
+
+// Whether one of params is named name
+func hasParam(params []*ir.Param, name string) bool {
+	for _, param := range params {
+		if param.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Store params into function local vars so they can be reassigned
+func (c *context) spillParams(params []*ir.Param) {
+	for _, param := range params {
+		var vr value.Value
+		if c.scope.captured[param.Name()] {
+			vr = c.newCell(param.Name(), param.Type())
+		} else {
+			vr = c.newLocal(param.Name()+".addr", param.Type())
+		}
+		c.store(param, vr)
+		c.createVar(param.Name(), vr)
+	}
 }
 
 func (c *context) compileReturnStatement(retStat *ast.ReturnStatement) error {
-	if err := c.compile(retStat.ReturnValue); err != nil {
+	if retStat.ReturnValue == nil || (isNoneLiteral(retStat.ReturnValue) && c.fn.Sig.RetType.Equal(None)) {
+		if !c.fn.Sig.RetType.Equal(None) {
+			return newError(fmt.Sprintf("function '%s' must return a value of type '%s'", displayName(c.fn), c.compiler.displayType(c.fn.Sig.RetType)), TypeError, retStat.Token)
+		}
+		c.releaseTemps()
+		if err := c.runFinally(nil); err != nil {
+			return err
+		}
+		if c.Term == nil {
+			c.NewRet(nil)
+		}
+		return nil
+	}
+
+	retVal, err := c.compileExpected(retStat.ReturnValue, c.fn.Sig.RetType, retStat.Token)
+	if err != nil {
 		return err
 	}
-	retVal := c.peekReg()
+	c.pushReg(retVal)
 
 	// Check declered return type vs actual return type
 	if !c.fn.Sig.RetType.Equal(retVal.Type()) {
-		return newError(fmt.Sprintf("function '%s' declered return type '%s' is not matching actual return type '%s'", c.fn.Name(), c.fn.Sig.RetType.String(), retVal.Type().String()), TypeError, retStat.Token)
+		return newError(fmt.Sprintf("function '%s' declered return type '%s' is not matching actual return type '%s'", displayName(c.fn), c.compiler.displayType(c.fn.Sig.RetType), c.compiler.displayType(retVal.Type())), TypeError, retStat.Token)
 	}
 
-	c.NewRet(retVal)
+	// The caller owns the returned value
+	c.compiler.retain(c.Block, retVal)
+	c.releaseTemps()
+	if err := c.runFinally(retVal); err != nil {
+		return err
+	}
+	if c.Term == nil {
+		c.NewRet(retVal)
+	}
 
 	return nil
 }
 
 func (c *context) compileCallExpression(callExp *ast.CallExpression) error {
-	funcName := callExp.Function.TokenLiteral()
-	var callee value.Value
-	for _, fn := range c.mod.Funcs {
-		if fn.Name() == callExp.Function.TokenLiteral() {
-			callee = fn
+	c.setLocation(callExp.Token)
+
+	if attr, ok := callExp.Function.(*ast.AttributeExpression); ok {
+		return c.compileMethodCall(attr, callExp)
+	}
+
+	ident, ok := callExp.Function.(*ast.Identifier)
+	if !ok {
+		// Calling the result of an expression, e.g. make_adder(1)(2)
+		if err := c.compile(callExp.Function); err != nil {
+			return err
 		}
+		return c.compileIndirectCall(c.popReg(), callExp.Function.String(), callExp)
 	}
-	if callee == nil {
-		return newError(fmt.Sprintf("function '%s' was not found", funcName), NameError, callExp.Token)
+	funcName := ident.Value
+
+	// Variables holding function values, including nested functions,
+	// shadow module level functions and classes
+	if vr := c.getVar(funcName); vr != nil {
+		if ptr, ok := vr.Type().(*types.PointerType); ok {
+			if _, ok := c.compiler.callableSig(ptr.ElemType); ok {
+				if err := c.compile(ident); err != nil {
+					return err
+				}
+				return c.compileIndirectCall(c.popReg(), funcName, callExp)
+			}
+		}
 	}
 
-	args := make([]value.Value, 0)
-	for _, arg := range callExp.Arguments {
-		if err := c.compile(arg); err != nil {
+	// Calling a class creates an object of it
+	if cls, ok := c.compiler.lookupClass(funcName); ok {
+		return c.compileCall(cls.ctor, funcName, callExp.Arguments, callExp.Token)
+	}
+
+	if ext, ok := c.compiler.ns.externs[funcName]; ok {
+		return c.compileExternCall(ext, funcName, callExp.Arguments, callExp.Token)
+	}
+
+	callee, ok := c.compiler.ns.functions[funcName]
+	if !ok {
+		if ok, err := c.compileBuiltinCall(funcName, callExp); ok {
 			return err
 		}
-		argReg := c.popReg()
-		args = append(args, argReg)
+		return newError(fmt.Sprintf("function '%s' was not found", funcName), NameError, callExp.Token)
 	}
 
-	retVal := c.NewCall(callee, args...)
-	c.pushReg(retVal)
+	return c.compileCall(callee, funcName, callExp.Arguments, callExp.Token)
+}
 
+// Call a function with type checked arguments
+func (c *context) compileCall(callee *ir.Func, funcName string, arguments []ast.Expression, tok token.Token) error {
+	args, err := c.compileArguments(callee.Params, funcName, arguments, tok)
+	if err != nil {
+		return err
+	}
+
+	call := c.NewCall(callee, args...)
+	c.checkException()
+	c.pushReg(c.temp(call))
 	return nil
 }
+
+// Compile the arguments of a call checked against the callee parameters,
+// the self parameter of a method is left out by the caller
+func (c *context) compileArguments(params []*ir.Param, funcName string, arguments []ast.Expression, tok token.Token) ([]value.Value, error) {
+	if len(arguments) != len(params) {
+		return nil, newError(fmt.Sprintf("function '%s' takes %d arguments but %d were given", funcName, len(params), len(arguments)), TypeError, tok)
+	}
+
+	args := make([]value.Value, 0)
+	for i, arg := range arguments {
+		paramTyp := params[i].Type()
+		argReg, err := c.compileExpected(arg, paramTyp, tok)
+		if err != nil {
+			return nil, err
+		}
+
+		if !argReg.Type().Equal(paramTyp) {
+			return nil, newError(fmt.Sprintf("argument %s of function '%s' expects type %s got %s", params[i].Name(), funcName, c.compiler.displayType(paramTyp), c.compiler.displayType(argReg.Type())), TypeError, tok)
+		}
+		args = append(args, c.hold(argReg, arguments[i+1:]...))
+	}
+
+	return args, nil
+}
+
+// Whether a path from the entry of fn reaches block
+func reachable(fn *ir.Func, block *ir.Block) bool {
+	seen := map[*ir.Block]bool{fn.Blocks[0]: true}
+	work := []*ir.Block{fn.Blocks[0]}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		if b == block {
+			return true
+		}
+		if b.Term == nil {
+			continue
+		}
+
+		for _, succ := range b.Term.Succs() {
+			if !seen[succ] {
+				seen[succ] = true
+				work = append(work, succ)
+			}
+		}
+	}
+	return false
+}
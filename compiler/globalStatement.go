@@ -0,0 +1,47 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileGlobalStatement(globalStat *ast.GlobalStatement) error {
+	// Module level names are already global
+	if c.isModuleLevel() {
+		return nil
+	}
+
+	// Declarations apply to the whole function
+	root := c
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root.globalNames == nil {
+		root.globalNames = make(map[string]bool)
+	}
+
+	for _, name := range globalStat.Names {
+		if vr := c.getVar(name.Value); vr != nil {
+			if _, ok := vr.(*ir.Global); !ok {
+				return newError(fmt.Sprintf("name '%s' is assigned before global declaration", name.Value), NameError, name.Token)
+			}
+		}
+
+		root.globalNames[name.Value] = true
+	}
+
+	return nil
+}
+
+// Functions can read module level variables but writing one requires a 'global' declaration
+func (c *context) checkGlobalWrite(name string, vr value.Value, tok token.Token) error {
+	if _, ok := vr.(*ir.Global); !ok || c.isModuleLevel() || c.isDeclaredGlobal(name) {
+		return nil
+	}
+
+	return newError(fmt.Sprintf("can not assign into global variable %s without declaring 'global %s'", name, name), NameError, tok)
+}
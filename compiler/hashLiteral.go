@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileHashLiteral(hashLit *ast.HashLiteral) error {
+	return c.compileDictLiteral(hashLit, nil, nil)
+}
+
+// Compile a dict literal, the key and value types are taken from the first
+// pair unless they are known from an annotation
+func (c *context) compileDictLiteral(hashLit *ast.HashLiteral, keyTyp, valTyp types.Type) error {
+	keys := make([]value.Value, 0, len(hashLit.Pairs))
+	vals := make([]value.Value, 0, len(hashLit.Pairs))
+
+	for i, pair := range hashLit.Pairs {
+		key, err := c.compileElement(pair.Key, keyTyp, hashLit.Token)
+		if err != nil {
+			return err
+		}
+		val, err := c.compileElement(pair.Value, valTyp, hashLit.Token)
+		if err != nil {
+			return err
+		}
+
+		if keyTyp == nil {
+			keyTyp, valTyp = key.Type(), val.Type()
+			if !isHashable(keyTyp) {
+				return newError(fmt.Sprintf("dict keys must be int, float or str, not %s", c.compiler.displayType(keyTyp)), TypeError, hashLit.Token)
+			}
+		}
+
+		if !key.Type().Equal(keyTyp) || !val.Type().Equal(valTyp) {
+			return newError(fmt.Sprintf("can not add %s: %s pair into a dict of %s: %s", c.compiler.displayType(key.Type()), c.compiler.displayType(val.Type()), c.compiler.displayType(keyTyp), c.compiler.displayType(valTyp)), TypeError, hashLit.Token)
+		}
+
+		var later []ast.Expression
+		for _, next := range hashLit.Pairs[i+1:] {
+			later = append(later, next.Key, next.Value)
+		}
+		keys = append(keys, key)
+		vals = append(vals, c.hold(val, later...))
+	}
+
+	if keyTyp == nil {
+		return newError("can not infer the type of an empty dict, annotate it e.g. d: dict[str, int] = {}", TypeError, hashLit.Token)
+	}
+
+	dict := c.temp(c.NewCall(c.compiler.dictNewFunc(keyTyp, valTyp)))
+	slotFn := c.compiler.dictSlotFunc(keyTyp, valTyp)
+	for i := range keys {
+		c.store(vals[i], c.NewCall(slotFn, dict, keys[i]))
+	}
+
+	c.pushReg(dict)
+	return nil
+}
+
+// Compile an element of a container literal, converting it when its type is known
+func (c *context) compileElement(exp ast.Expression, typ types.Type, tok token.Token) (value.Value, error) {
+	if typ != nil {
+		return c.compileExpected(exp, typ, tok)
+	}
+
+	if err := c.compile(exp); err != nil {
+		return nil, err
+	}
+
+	reg := c.popReg()
+	if reg == noneValue {
+		return nil, newError("can not infer the type of None, annotate the container with an Optional type", TypeError, tok)
+	}
+	return reg, nil
+}
@@ -4,12 +4,31 @@ import (
 	"fmt"
 
 	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/types"
 )
 
 func (c *context) compileIdentifier(ident *ast.Identifier) error {
-	variable := c.getVar(ident.TokenLiteral())
+	if ident.Token.Type == token.None {
+		c.pushReg(noneValue)
+		return nil
+	}
+
+	variable, err := c.lookupVar(ident.TokenLiteral())
+	if err != nil {
+		return err
+	}
 	if variable == nil {
+		// A module level function used as a value
+		if fn, ok := c.compiler.ns.functions[ident.Value]; ok {
+			c.pushReg(c.newCallable(c.compiler.thunkFunc(fn), constant.NewNull(I8Ptr)))
+			return nil
+		}
+		if _, ok := c.compiler.ns.externs[ident.Value]; ok {
+			return newError(fmt.Sprintf("extern function '%s' can only be called", ident.Value), TypeError, ident.Token)
+		}
+
 		return newError(fmt.Sprintf("variable %s is not defined", ident.TokenLiteral()), NameError, ident.Token)
 	}
 
@@ -17,6 +36,9 @@ func (c *context) compileIdentifier(ident *ast.Identifier) error {
 	if types.IsPointer(variable.Type()) {
 		ptr := variable.Type().(*types.PointerType)
 		typ := ptr.ElemType
+		if opt, ok := typ.(*optionalType); ok && c.isNarrowed(ident.Value) {
+			typ = opt.PointerType
+		}
 		value := c.NewLoad(typ, variable)
 		c.pushReg(value)
 	} else {
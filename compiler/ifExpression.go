@@ -12,29 +12,59 @@ func (c *context) compileIfExpression(ifExp *ast.IfExpression) error {
 		return err
 	}
 	cond := c.popReg()
+	c.releaseTemps()
 
+	// if x is None: and if x is not None: narrow x in the branch where it is not None
+	name, thenNotNone, narrows := c.noneCheck(ifExp.Condition)
+
+	// Nested control flow moves a context to a new block, so keep the
+	// blocks the branch has to jump to
 	ifCtx := c.newContext("if.then")
+	thenEntry := ifCtx.Block
+	if narrows && thenNotNone {
+		ifCtx.narrow(name, true)
+	}
 	if err := ifCtx.compile(ifExp.Consequence); err != nil {
 		return err
 	}
 
-	// only jump to endif if there is no return
-	if reVal := ifCtx.popReg(); reVal == nil {
+	// only jump to endif if the branch did not return
+	if ifCtx.Term == nil {
 		ifCtx.NewBr(endif.Block)
 	}
 
 	// create else brach
-	var elseCtx *context = endif
+	elseEntry := endif.Block
+	var elseCtx *context
 	if ifExp.Alternative != nil {
 		elseCtx = c.newContext("if.else")
+		elseEntry = elseCtx.Block
+		if narrows && !thenNotNone {
+			elseCtx.narrow(name, true)
+		}
 		if err := elseCtx.compile(ifExp.Alternative); err != nil {
 			return err
 		}
-		elseCtx.NewBr(endif.Block)
+		if elseCtx.Term == nil {
+			elseCtx.NewBr(endif.Block)
+		}
 	}
 
 	// create branch
-	c.NewCondBr(cond, ifCtx.Block, elseCtx.Block)
+	c.NewCondBr(cond, thenEntry, elseEntry)
+
+	// x is not None after the if when the branch where it is None does not
+	// continue there
+	if narrows {
+		noneCtx, notNoneCtx := ifCtx, elseCtx
+		if thenNotNone {
+			noneCtx, notNoneCtx = elseCtx, ifCtx
+		}
+		noneEnds := noneCtx != nil && noneCtx.Term != nil
+		if noneEnds && (notNoneCtx == nil || notNoneCtx.Term != nil || notNoneCtx.isNarrowed(name)) {
+			c.narrow(name, true)
+		}
+	}
 
 	// Continue with endif block
 	c.Block = endif.Block
@@ -0,0 +1,158 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileIndexExpression(indexExp *ast.IndexExpression) error {
+	if err := c.compile(indexExp.Left); err != nil {
+		return err
+	}
+	container := c.hold(c.popReg(), indexExp.Index)
+	if err := checkUsedNotNone(container, indexExp.Left, indexExp.Token); err != nil {
+		return err
+	}
+
+	// Tuples are values, their elements are read directly from the struct
+	if elemTyps, ok := tupleElems(container.Type()); ok {
+		index, err := tupleIndex(indexExp, len(elemTyps))
+		if err != nil {
+			return err
+		}
+		c.pushReg(c.NewExtractValue(container, index))
+		return nil
+	}
+
+	if _, ok := c.compiler.classOf(container.Type()); ok {
+		return c.compileGetItem(container, indexExp)
+	}
+
+	ptr, err := c.indexAddress(container, indexExp, false)
+	if err != nil {
+		return err
+	}
+
+	elemTyp := ptr.Type().(*types.PointerType).ElemType
+	c.pushReg(c.NewLoad(elemTyp, ptr))
+	return nil
+}
+
+// Compile the address of an indexed element, for reading or assigning it.
+// Assigning into a missing dict key inserts it, reading it raises a KeyError
+func (c *context) compileIndexAddress(indexExp *ast.IndexExpression, insert bool) (value.Value, error) {
+	if err := c.compile(indexExp.Left); err != nil {
+		return nil, err
+	}
+
+	return c.indexAddress(c.hold(c.popReg(), indexExp.Index), indexExp, insert)
+}
+
+// Compile the address of an element of an already compiled container
+func (c *context) indexAddress(container value.Value, indexExp *ast.IndexExpression, insert bool) (value.Value, error) {
+	key, err := c.compileKey(container, indexExp)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.elementAddress(container, key, insert, indexExp.Token), nil
+}
+
+// Compile the key of a dict element or the index of a list element, checked
+// against the type of the container
+func (c *context) compileKey(container value.Value, indexExp *ast.IndexExpression) (value.Value, error) {
+	if _, ok := tupleElems(container.Type()); ok {
+		return nil, newError("'tuple' object does not support item assignment", TypeError, indexExp.Token)
+	}
+
+	if keyTyp, _, ok := c.compiler.dictElems(container.Type()); ok {
+		key, err := c.compileExpected(indexExp.Index, keyTyp, indexExp.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		if !key.Type().Equal(keyTyp) {
+			return nil, newError(fmt.Sprintf("dict keys must be %s, not %s", c.compiler.displayType(keyTyp), c.compiler.displayType(key.Type())), TypeError, indexExp.Token)
+		}
+		return key, nil
+	}
+
+	if err := c.compile(indexExp.Index); err != nil {
+		return nil, err
+	}
+	index := c.popReg()
+
+	if _, ok := c.compiler.listElem(container.Type()); !ok {
+		return nil, newError(fmt.Sprintf("type %s is not subscriptable", c.compiler.displayType(container.Type())), TypeError, indexExp.Token)
+	}
+
+	if !index.Type().Equal(Int) {
+		return nil, newError(fmt.Sprintf("list indices must be integers, not %s", c.compiler.displayType(index.Type())), TypeError, indexExp.Token)
+	}
+	return index, nil
+}
+
+// The address of the element of a dict or list at a compiled key or index.
+// Assigning into a missing dict key inserts it, reading it raises a KeyError
+func (c *context) elementAddress(container, key value.Value, insert bool, tok token.Token) value.Value {
+	if keyTyp, valTyp, ok := c.compiler.dictElems(container.Type()); ok {
+		if insert {
+			return c.NewCall(c.compiler.dictSlotFunc(keyTyp, valTyp), container, key)
+		}
+		ptr := c.NewCall(c.compiler.dictAtFunc(keyTyp, valTyp), container, key)
+		c.check(c.NewICmp(enum.IPredEQ, ptr, constant.NewNull(types.NewPointer(valTyp))), "KeyError", func(b *ir.Block) value.Value {
+			return c.compiler.keyRepr(b, keyTyp, key)
+		}, tok)
+		return ptr
+	}
+
+	elemTyp, _ := c.compiler.listElem(container.Type())
+	ptr := c.NewCall(c.compiler.listAtFunc(elemTyp), container, key)
+	c.check(c.NewICmp(enum.IPredEQ, ptr, constant.NewNull(types.NewPointer(elemTyp))), "IndexError", func(*ir.Block) value.Value {
+		return c.compiler.cString("list index out of range")
+	}, tok)
+	return ptr
+}
+
+// Tuple elements have different types, so a tuple can only be indexed by a constant
+func tupleIndex(indexExp *ast.IndexExpression, length int) (uint64, error) {
+	index, ok := intLiteralValue(indexExp.Index)
+	if !ok {
+		return 0, newError("tuple indices must be integer constants", TypeError, indexExp.Token)
+	}
+
+	if index < 0 {
+		index += int64(length)
+	}
+
+	if index < 0 || index >= int64(length) {
+		return 0, newError("tuple index out of range", TypeError, indexExp.Token)
+	}
+
+	return uint64(index), nil
+}
+
+// Index an object through its __getitem__ method
+func (c *context) compileGetItem(object value.Value, indexExp *ast.IndexExpression) error {
+	if err := c.compile(indexExp.Index); err != nil {
+		return err
+	}
+
+	res, ok, err := c.callSpecialMethod(object, "__getitem__", []value.Value{c.popReg()}, indexExp.Token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return newError(fmt.Sprintf("type %s is not subscriptable", c.compiler.displayType(object.Type())), TypeError, indexExp.Token)
+	}
+
+	c.pushReg(res)
+	return nil
+}
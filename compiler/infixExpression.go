@@ -5,6 +5,8 @@ import (
 
 	"github.com/hvuhsg/spython/ast"
 	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
@@ -27,31 +29,56 @@ var tokenToOpFloat = map[string]enum.FPred{
 }
 
 func (c *context) compileInfixExpression(infixExp *ast.InfixExpression) error {
-	if infixExp.Operator == token.Assign {
-		return c.compileAssignInfix(infixExp)
+	if infixExp.Operator == token.In {
+		return c.compileInExpression(infixExp)
+	}
+
+	if infixExp.Operator == token.Is || infixExp.Operator == token.Is+" "+token.Not {
+		return c.compileIsExpression(infixExp)
 	}
 
 	if err := c.compile(infixExp.Left); err != nil {
 		return err
 	}
-	lreg := c.popReg()
+	lreg := c.hold(c.popReg(), infixExp.Right)
 
 	if err := c.compile(infixExp.Right); err != nil {
 		return err
 	}
 	rreg := c.popReg()
 
-	if types.IsPointer(lreg.Type()) {
-		ptrTyp := lreg.Type().(*types.PointerType)
-		lreg = c.NewLoad(ptrTyp.ElemType, lreg)
+	res, err := c.compileBinaryOperation(infixExp.Operator, lreg, rreg, infixExp.Token)
+	if err != nil {
+		return err
+	}
+
+	c.pushReg(res)
+	return nil
+}
+
+// Apply a binary operator on two loaded values
+func (c *context) compileBinaryOperation(operator string, lreg, rreg value.Value, tok token.Token) (value.Value, error) {
+	// Operators on objects call the special method of the left operand class
+	if method, ok := operatorMethods[operator]; ok {
+		res, ok, err := c.callSpecialMethod(lreg, method, []value.Value{rreg}, tok)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if operator == token.NotEqual {
+				res = c.NewXor(res, constant.True)
+			}
+			return res, nil
+		}
 	}
-	if types.IsPointer(rreg.Type()) {
-		ptrTyp := rreg.Type().(*types.PointerType)
-		rreg = c.NewLoad(ptrTyp.ElemType, rreg)
+
+	_, isObject := c.compiler.classOf(lreg.Type())
+	if isObject || !lreg.Type().Equal(rreg.Type()) {
+		return nil, newError(fmt.Sprintf("unsupported operand types for %s: '%s' and '%s'", operator, c.compiler.displayType(lreg.Type()), c.compiler.displayType(rreg.Type())), TypeError, tok)
 	}
 
 	var res value.Value
-	switch infixExp.Operator {
+	switch operator {
 	case token.Plus:
 		if lreg.Type().Equal(Int) {
 			res = c.NewAdd(lreg, rreg)
@@ -72,62 +99,118 @@ func (c *context) compileInfixExpression(infixExp *ast.InfixExpression) error {
 		}
 	case token.Slash:
 		if types.IsInt(lreg.Type()) {
+			c.checkDivisor(rreg, "division by zero", tok)
 			res = c.NewSDiv(lreg, rreg)
 		} else if types.IsFloat(lreg.Type()) {
+			c.checkDivisor(rreg, "float division by zero", tok)
 			res = c.NewFDiv(lreg, rreg)
 		}
 	case token.Mod:
 		if types.IsInt(lreg.Type()) {
+			c.checkDivisor(rreg, "integer modulo by zero", tok)
 			res = c.NewSRem(lreg, rreg)
 		} else if types.IsFloat(lreg.Type()) {
+			c.checkDivisor(rreg, "float modulo", tok)
 			res = c.NewFRem(lreg, rreg)
 		}
+	case token.BitAnd, token.BitOr, token.BitXor, token.LeftShift, token.RightShift:
+		if !types.IsInt(lreg.Type()) || !types.IsInt(rreg.Type()) {
+			return nil, newError(fmt.Sprintf("unsupported operand types for %s: '%s' and '%s'", operator, c.compiler.displayType(lreg.Type()), c.compiler.displayType(rreg.Type())), TypeError, tok)
+		}
+
+		switch operator {
+		case token.BitAnd:
+			res = c.NewAnd(lreg, rreg)
+		case token.BitOr:
+			res = c.NewOr(lreg, rreg)
+		case token.BitXor:
+			res = c.NewXor(lreg, rreg)
+		case token.LeftShift, token.RightShift:
+			// a shifted bool is an int in Python, it is not converted
+			if lreg.Type().Equal(Bool) {
+				return nil, newError(fmt.Sprintf("unsupported operand types for %s: '%s' and '%s'", operator, c.compiler.displayType(lreg.Type()), c.compiler.displayType(rreg.Type())), TypeError, tok)
+			}
+			res = c.compileShift(operator, lreg, rreg, tok)
+		}
 	default:
-		_, ok := tokenToOpInt[infixExp.Operator]
+		_, ok := tokenToOpInt[operator]
 		if !ok {
-			return newError("unsupported operator", UnsupportedError, infixExp.Token)
+			return nil, newError("unsupported operator", UnsupportedError, tok)
 		}
 
 		if types.IsInt(lreg.Type()) {
-			op := tokenToOpInt[infixExp.Operator]
+			op := tokenToOpInt[operator]
 			res = c.NewICmp(op, lreg, rreg)
 		} else if types.IsFloat(lreg.Type()) {
-			op := tokenToOpFloat[infixExp.Operator]
+			op := tokenToOpFloat[operator]
 			res = c.NewFCmp(op, lreg, rreg)
 		}
 	}
 
-	c.pushReg(res)
-	return nil
+	if res == nil {
+		return nil, newError("unsupported operator", UnsupportedError, tok)
+	}
+
+	return res, nil
 }
 
-func (c *context) compileAssignInfix(assignExp *ast.InfixExpression) error {
-	if err := c.compile(assignExp.Right); err != nil {
+// Compile key in container
+func (c *context) compileInExpression(infixExp *ast.InfixExpression) error {
+	if err := c.compile(infixExp.Left); err != nil {
 		return err
 	}
-	reg := c.popReg()
+	key := c.popReg()
 
-	identifier, ok := assignExp.Left.(*ast.Identifier)
+	if err := c.compile(infixExp.Right); err != nil {
+		return err
+	}
+	container := c.popReg()
+
+	keyTyp, valTyp, ok := c.compiler.dictElems(container.Type())
 	if !ok {
-		return newError("can assign only into identifier", TypeError, assignExp.Token)
+		return newError(fmt.Sprintf("argument of type %s is not a container", c.compiler.displayType(container.Type())), TypeError, infixExp.Token)
 	}
 
-	varName := identifier.TokenLiteral()
+	key, err := convertLiteral(key, infixExp.Left, keyTyp, infixExp.Token)
+	if err != nil {
+		return err
+	}
 
-	vr := c.getVar(varName)
-	if vr == nil {
-		// Create new variable
-		vr := c.NewAlloca(reg.Type())
-		vr.SetName(varName)
-		c.NewStore(reg, vr)
-		c.createVar(varName, vr)
-	} else {
-		// Check if reg type is identical to var type
-		if reg.Type().String()+"*" != vr.Type().String() {
-			return newError(fmt.Sprintf("can not assign type %s into %s", reg.Type().String(), varName), TypeError, assignExp.Token)
-		}
-		c.NewStore(reg, vr)
+	if !key.Type().Equal(keyTyp) {
+		return newError(fmt.Sprintf("dict keys must be %s, not %s", c.compiler.displayType(keyTyp), c.compiler.displayType(key.Type())), TypeError, infixExp.Token)
 	}
 
+	c.pushReg(c.NewCall(c.compiler.dictContainsFunc(keyTyp, valTyp), container, key))
 	return nil
 }
+
+// Shift an int, a negative count raises a ValueError. Shifting by the width
+// of the int or more, which LLVM leaves undefined, shifts every bit out: a
+// left shift gives 0 and a right shift the sign, 0 or -1
+func (c *context) compileShift(operator string, lreg, rreg value.Value, tok token.Token) value.Value {
+	typ := lreg.Type().(*types.IntType)
+	c.check(c.NewICmp(enum.IPredSLT, rreg, constant.NewInt(typ, 0)), "ValueError", func(*ir.Block) value.Value {
+		return c.compiler.cString("negative shift count")
+	}, tok)
+
+	last := constant.NewInt(typ, int64(typ.BitSize)-1)
+	outside := c.NewICmp(enum.IPredSGT, rreg, last)
+	if operator == token.LeftShift {
+		return c.NewSelect(outside, constant.NewInt(typ, 0), c.NewShl(lreg, rreg))
+	}
+	return c.NewAShr(lreg, c.NewSelect(outside, last, rreg))
+}
+
+// Raise a ZeroDivisionError when the divisor of a division or modulo is zero
+func (c *context) checkDivisor(divisor value.Value, msg string, tok token.Token) {
+	var isZero value.Value
+	if types.IsFloat(divisor.Type()) {
+		isZero = c.NewFCmp(enum.FPredOEQ, divisor, constant.NewFloat(divisor.Type().(*types.FloatType), 0))
+	} else {
+		isZero = c.NewICmp(enum.IPredEQ, divisor, constant.NewInt(divisor.Type().(*types.IntType), 0))
+	}
+
+	c.check(isZero, "ZeroDivisionError", func(*ir.Block) value.Value {
+		return c.compiler.cString(msg)
+	}, tok)
+}
@@ -0,0 +1,314 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+// A library is a module compiled on its own, without a main function, to be
+// linked with the programs that import it. Its symbols are named like the
+// symbols of an imported module:
+//
+//	name.f              the module level function f
+//	module.name.C       the struct of class C, C.vtable its vtable type
+//	name.C.m            method m of class C, C.new its constructor and C.vtable its vtable
+//	name.x              the module level variable x
+//	name.<module>       runs the module level code, once
+//	name.<loaded>       set when the module level code starts running
+//
+// Its interface declares these symbols for the units that import it
+
+// Compile the program as the library module named by the options
+func (c *context) compileLibrary(program *ast.Program) error {
+	if err := c.compileExceptionClasses(); err != nil {
+		return err
+	}
+
+	return c.compileInit(program)
+}
+
+// Declare the symbols of a separately compiled module from its interface,
+// its functions and methods are defined by the unit the module is compiled in
+func (comp *compiler) declareModule(mod *namespace, program *ast.Program) error {
+	mod.init = comp.module.NewFunc(mod.symbol("<module>"), types.Void)
+	mod.loaded = comp.externalGlobal(mod.symbol("<loaded>"), Bool)
+
+	// Declarations do not compile any code into the init function
+	ctx := newContext(comp, mod.init, nil)
+
+	for _, statement := range program.Statements {
+		switch statement := statement.(type) {
+		case *ast.ImportStatement:
+			for _, name := range statement.Modules {
+				imported, err := comp.loadModule(name.Name)
+				if err != nil {
+					return err
+				}
+				mod.modules[name.Bound()] = imported
+			}
+		case *ast.FromImportStatement:
+			imported, err := comp.loadModule(statement.Module)
+			if err != nil {
+				return err
+			}
+			for _, name := range statement.Names {
+				cls, ok := imported.classes[name.Name.Value]
+				if !ok {
+					return newError(fmt.Sprintf("can not import name '%s' from module '%s'", name.Name.Value, imported.name), ImportError, name.Name.Token)
+				}
+				mod.classes[name.Bound()] = cls
+			}
+		case *ast.ClassStatement:
+			if err := ctx.compileClassStatement(statement); err != nil {
+				return err
+			}
+		case *ast.ExternStatement:
+			if err := ctx.compileExternStatement(statement); err != nil {
+				return err
+			}
+		case *ast.AnnotatedAssignStatement:
+			typ, err := ctx.resolveType(statement.Type, statement.Token)
+			if err != nil {
+				return err
+			}
+			mod.globals[statement.Name.Value] = comp.externalGlobal(mod.symbol(statement.Name.Value), typ)
+		case *ast.ExpressionStatement:
+			funcLit, ok := statement.Expression.(*ast.FunctionLiteral)
+			if !ok {
+				return newError(fmt.Sprintf("the interface of module '%s' can only declare classes, functions and variables", mod.name), UnsupportedError, statement.Token)
+			}
+			if err := ctx.compileFunctionLiteral(funcLit); err != nil {
+				return err
+			}
+		default:
+			return newError(fmt.Sprintf("the interface of module '%s' can only declare classes, functions and variables", mod.name), UnsupportedError, token.Token{})
+		}
+	}
+
+	return nil
+}
+
+// Declare a global defined by another compilation unit
+func (comp *compiler) externalGlobal(name string, typ types.Type) *ir.Global {
+	global := comp.module.NewGlobal(name, typ)
+	global.Linkage = enum.LinkageExternal
+	return global
+}
+
+// Interface returns the interface of the compiled module, the declarations
+// of its externs, classes, functions and module level variables in SPython
+// syntax. A module importing it from a name.spi file is type checked against
+// it and links with the separately compiled module
+func (c *compiler) Interface() string {
+	w := &interfaceWriter{comp: c, ns: c.ns, imports: make(map[string]bool), names: make(map[*class]string)}
+
+	// Classes are referred to by the name the module binds them to
+	bound := make([]string, 0, len(c.ns.classes))
+	for name := range c.ns.classes {
+		bound = append(bound, name)
+	}
+	sort.Strings(bound)
+	for _, name := range bound {
+		if _, ok := w.names[c.ns.classes[name]]; !ok {
+			w.names[c.ns.classes[name]] = name
+		}
+	}
+
+	var body strings.Builder
+	var from []string
+	for _, name := range bound {
+		cls := c.ns.classes[name]
+		if module, ok := w.moduleOf(cls); ok && module != c.ns.name {
+			line := fmt.Sprintf("from %s import %s", module, cls.name)
+			if name != cls.name {
+				line += " as " + name
+			}
+			from = append(from, line+"\n")
+		}
+	}
+
+	// C functions are not prefixed, so an extern is declared again by the
+	// modules importing the interface
+	for _, name := range sortedKeys(c.ns.externs) {
+		if ext := c.ns.externs[name]; ext.name == name {
+			fmt.Fprintf(&body, "%s\n", ext.decl.String())
+		}
+	}
+
+	for _, cls := range c.ns.defined {
+		w.writeClass(&body, cls)
+	}
+
+	for _, name := range sortedKeys(c.ns.functions) {
+		if fn := c.ns.functions[name]; fn.Name() == c.ns.symbol(name) {
+			w.writeFunction(&body, "", name, fn.Params, fn.Sig.RetType)
+		}
+	}
+
+	for _, name := range sortedKeys(c.ns.globals) {
+		if global := c.ns.globals[name]; global.Name() == c.ns.symbol(name) {
+			fmt.Fprintf(&body, "%s: %s\n", name, w.annotation(global.ContentType))
+		}
+	}
+
+	var out strings.Builder
+	for _, module := range sortedKeys(w.imports) {
+		fmt.Fprintf(&out, "import %s\n", module)
+	}
+	for _, line := range from {
+		out.WriteString(line)
+	}
+	out.WriteString(body.String())
+
+	return out.String()
+}
+
+type interfaceWriter struct {
+	comp    *compiler
+	ns      *namespace
+	imports map[string]bool   // modules the declarations refer to
+	names   map[*class]string // the names the module binds classes to
+}
+
+// The module a class is defined in, built-in classes have no module
+func (w *interfaceWriter) moduleOf(cls *class) (string, bool) {
+	name := cls.typ.Name()
+	i := strings.LastIndex(name, ".")
+	if !strings.HasPrefix(name, moduleTypePrefix) || i < 0 {
+		return "", false
+	}
+
+	return name[len(moduleTypePrefix):i], true
+}
+
+func (w *interfaceWriter) writeClass(out *strings.Builder, cls *class) {
+	var fields []*field
+	var slots []string
+	if cls.base != nil {
+		fmt.Fprintf(out, "class %s(%s):\n", cls.name, w.className(cls.base))
+		fields = cls.fields[len(cls.base.fields):]
+		slots = cls.slots[len(cls.base.slots):]
+	} else {
+		fmt.Fprintf(out, "class %s:\n", cls.name)
+		fields = cls.fields
+		slots = cls.slots
+	}
+
+	if len(fields) == 0 && len(cls.methods) == 0 {
+		out.WriteString("\tpass\n")
+	}
+
+	for _, f := range fields {
+		fmt.Fprintf(out, "\t%s: %s\n", f.name, w.annotation(f.typ))
+	}
+
+	// The methods that add vtable slots are declared in slot order, so the
+	// vtable of the declared class has the layout of the compiled one
+	methods := make([]string, 0, len(cls.methods))
+	if _, ok := cls.methods["__init__"]; ok {
+		methods = append(methods, "__init__")
+	}
+	methods = append(methods, slots...)
+	for _, name := range sortedKeys(cls.methods) {
+		if name != "__init__" && !contains(slots, name) {
+			methods = append(methods, name)
+		}
+	}
+
+	for _, name := range methods {
+		fn := cls.methods[name]
+		w.writeFunction(out, "\t", name, fn.Params, fn.Sig.RetType)
+	}
+}
+
+func (w *interfaceWriter) writeFunction(out *strings.Builder, indent string, name string, params []*ir.Param, ret types.Type) {
+	decls := make([]string, 0, len(params))
+	for i, param := range params {
+		if i == 0 && indent != "" {
+			decls = append(decls, param.Name())
+			continue
+		}
+		decls = append(decls, fmt.Sprintf("%s: %s", param.Name(), w.annotation(param.Type())))
+	}
+
+	fmt.Fprintf(out, "%sdef %s(%s) -> %s:\n%s\tpass\n", indent, name, strings.Join(decls, ", "), w.annotation(ret), indent)
+}
+
+func (w *interfaceWriter) className(cls *class) string {
+	if name, ok := w.names[cls]; ok {
+		return name
+	}
+
+	module, ok := w.moduleOf(cls)
+	if !ok {
+		return cls.name
+	}
+	w.imports[module] = true
+	return module + "." + cls.name
+}
+
+// The type annotation of a type
+func (w *interfaceWriter) annotation(typ types.Type) string {
+	for name, t := range nameToType {
+		if t.Equal(typ) {
+			return name
+		}
+	}
+
+	if opt, ok := typ.(*optionalType); ok {
+		return fmt.Sprintf("Optional[%s]", w.annotation(opt.PointerType))
+	}
+	if cls, ok := w.comp.classOf(typ); ok {
+		return w.className(cls)
+	}
+	if elem, ok := w.comp.listElem(typ); ok {
+		return fmt.Sprintf("list[%s]", w.annotation(elem))
+	}
+	if key, val, ok := w.comp.dictElems(typ); ok {
+		return fmt.Sprintf("dict[%s, %s]", w.annotation(key), w.annotation(val))
+	}
+	if elems, ok := tupleElems(typ); ok {
+		return fmt.Sprintf("tuple[%s]", w.annotations(elems))
+	}
+	if sig, ok := w.comp.callableSig(typ); ok {
+		return fmt.Sprintf("Callable[[%s], %s]", w.annotations(sig.Params[1:]), w.annotation(sig.RetType))
+	}
+
+	panic(fmt.Sprintf("type %s has no annotation", typ.String()))
+}
+
+func (w *interfaceWriter) annotations(typs []types.Type) string {
+	names := make([]string, 0, len(typs))
+	for _, typ := range typs {
+		names = append(names, w.annotation(typ))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
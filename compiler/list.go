@@ -0,0 +1,202 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Lists are pointers to a heap allocated { i64 len, i64 cap, T* data }
+const (
+	listLen = iota
+	listCap
+	listData
+)
+
+const listMinCap = 4
+
+// The type of list[elem], its struct type is defined in the module on first use
+func (comp *compiler) listOf(elem types.Type) *types.PointerType {
+	name := "list." + typeTag(elem)
+
+	st, ok := comp.lists[name]
+	if !ok {
+		st = types.NewStruct(Int, Int, types.NewPointer(elem))
+		comp.module.NewTypeDef(name, st)
+		comp.lists[name] = st
+	}
+
+	return types.NewPointer(st)
+}
+
+// Get the element type of a list type
+func (comp *compiler) listElem(typ types.Type) (types.Type, bool) {
+	ptr, ok := typ.(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+
+	st, ok := ptr.ElemType.(*types.StructType)
+	if !ok || comp.lists[st.Name()] != st {
+		return nil, false
+	}
+
+	return st.Fields[listData].(*types.PointerType).ElemType, true
+}
+
+// A name for a type that can be part of a symbol name
+func typeTag(typ types.Type) string {
+	if typ.Equal(Str) {
+		return "str"
+	}
+
+	if ptr, ok := typ.(*types.PointerType); ok {
+		if st, ok := ptr.ElemType.(*types.StructType); ok && st.Name() != "" {
+			return strings.ReplaceAll(st.Name(), ".", "_")
+		}
+		return typeTag(ptr.ElemType) + "ptr"
+	}
+
+	if elems, ok := tupleElems(typ); ok {
+		return tupleTag(elems)
+	}
+
+	if st, ok := typ.(*types.StructType); ok {
+		return strings.ReplaceAll(st.Name(), ".", "_")
+	}
+
+	return typ.String()
+}
+
+// Pointer to a field of a list
+func listField(b *ir.Block, list value.Value, field int64) value.Value {
+	st := list.Type().(*types.PointerType).ElemType
+	return b.NewGetElementPtr(st, list, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// list* spython_list_T_new(i64 cap) allocates an empty list
+func (comp *compiler) listNewFunc(elem types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_list_"+typeTag(elem)+"_new", func(name string) *ir.Func {
+		listTyp := comp.listOf(elem)
+		capacity := ir.NewParam("cap", Int)
+		fn := comp.module.NewFunc(name, listTyp, capacity)
+
+		entry := fn.NewBlock("entry")
+		st := listTyp.ElemType.(*types.StructType)
+		list := comp.alloc(entry, st, comp.listDropFunc(elem), st.Name())
+
+		// Keep room for a few elements so the data is never a zero sized allocation
+		minCap := constant.NewInt(Int, listMinCap)
+		small := entry.NewICmp(enum.IPredSLT, capacity, minCap)
+		realCap := entry.NewSelect(small, minCap, capacity)
+		rawData := entry.NewCall(comp.libcFunc("malloc"), entry.NewMul(realCap, sizeOf(elem)))
+		data := entry.NewBitCast(rawData, types.NewPointer(elem))
+
+		entry.NewStore(constant.NewInt(Int, 0), listField(entry, list, listLen))
+		entry.NewStore(realCap, listField(entry, list, listCap))
+		entry.NewStore(data, listField(entry, list, listData))
+		entry.NewRet(list)
+
+		return fn
+	})
+}
+
+// void spython_list_T_drop(i8* list) releases the elements of a list and frees its data
+func (comp *compiler) listDropFunc(elem types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_list_"+typeTag(elem)+"_drop", func(name string) *ir.Func {
+		raw := ir.NewParam("list", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, raw)
+
+		entry := fn.NewBlock("entry")
+		list := entry.NewBitCast(raw, comp.listOf(elem))
+		data := entry.NewLoad(types.NewPointer(elem), listField(entry, list, listData))
+
+		if comp.isManaged(elem) {
+			loop := fn.NewBlock("loop")
+			body := fn.NewBlock("body")
+			done := fn.NewBlock("done")
+
+			length := entry.NewLoad(Int, listField(entry, list, listLen))
+			entry.NewBr(loop)
+
+			i := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, 0), entry))
+			loop.NewCondBr(loop.NewICmp(enum.IPredSLT, i, length), body, done)
+
+			comp.release(body, body.NewLoad(elem, body.NewGetElementPtr(elem, data, i)))
+			i.Incs = append(i.Incs, ir.NewIncoming(body.NewAdd(i, constant.NewInt(Int, 1)), body))
+			body.NewBr(loop)
+
+			entry = done
+		}
+
+		entry.NewCall(comp.libcFunc("free"), entry.NewBitCast(data, I8Ptr))
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// void spython_list_T_append(list* l, T value) adds value at the end, growing the data when it is full
+func (comp *compiler) listAppendFunc(elem types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_list_"+typeTag(elem)+"_append", func(name string) *ir.Func {
+		list := ir.NewParam("list", comp.listOf(elem))
+		val := ir.NewParam("value", elem)
+		fn := comp.module.NewFunc(name, types.Void, list, val)
+
+		entry := fn.NewBlock("entry")
+		grow := fn.NewBlock("grow")
+		store := fn.NewBlock("store")
+
+		length := entry.NewLoad(Int, listField(entry, list, listLen))
+		capacity := entry.NewLoad(Int, listField(entry, list, listCap))
+		full := entry.NewICmp(enum.IPredEQ, length, capacity)
+		entry.NewCondBr(full, grow, store)
+
+		newCap := grow.NewMul(capacity, constant.NewInt(Int, 2))
+		oldData := grow.NewLoad(types.NewPointer(elem), listField(grow, list, listData))
+		rawData := grow.NewCall(comp.libcFunc("realloc"), grow.NewBitCast(oldData, I8Ptr), grow.NewMul(newCap, sizeOf(elem)))
+		grow.NewStore(grow.NewBitCast(rawData, types.NewPointer(elem)), listField(grow, list, listData))
+		grow.NewStore(newCap, listField(grow, list, listCap))
+		grow.NewBr(store)
+
+		data := store.NewLoad(types.NewPointer(elem), listField(store, list, listData))
+		comp.retain(store, val)
+		store.NewStore(val, store.NewGetElementPtr(elem, data, length))
+		store.NewStore(store.NewAdd(length, constant.NewInt(Int, 1)), listField(store, list, listLen))
+		store.NewRet(nil)
+
+		return fn
+	})
+}
+
+// T* spython_list_T_at(list* l, i64 index) returns the address of an element,
+// negative indexes count from the end and out of range indexes return null
+func (comp *compiler) listAtFunc(elem types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_list_"+typeTag(elem)+"_at", func(name string) *ir.Func {
+		list := ir.NewParam("list", comp.listOf(elem))
+		index := ir.NewParam("index", Int)
+		fn := comp.module.NewFunc(name, types.NewPointer(elem), list, index)
+
+		entry := fn.NewBlock("entry")
+		outOfRange := fn.NewBlock("out_of_range")
+		inRange := fn.NewBlock("in_range")
+
+		length := entry.NewLoad(Int, listField(entry, list, listLen))
+		negative := entry.NewICmp(enum.IPredSLT, index, constant.NewInt(Int, 0))
+		i := entry.NewSelect(negative, entry.NewAdd(index, length), index)
+		tooSmall := entry.NewICmp(enum.IPredSLT, i, constant.NewInt(Int, 0))
+		tooBig := entry.NewICmp(enum.IPredSGE, i, length)
+		entry.NewCondBr(entry.NewOr(tooSmall, tooBig), outOfRange, inRange)
+
+		outOfRange.NewRet(constant.NewNull(types.NewPointer(elem)))
+
+		data := inRange.NewLoad(types.NewPointer(elem), listField(inRange, list, listData))
+		inRange.NewRet(inRange.NewGetElementPtr(elem, data, i))
+
+		return fn
+	})
+}
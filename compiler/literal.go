@@ -16,3 +16,8 @@ func (c *context) compileFloatLiteral(floatLit *ast.FloatLiteral) error {
 	c.pushReg(cnst)
 	return nil
 }
+
+func (c *context) compileStringLiteral(strLit *ast.StringLiteral) error {
+	c.pushReg(c.compiler.cString(strLit.Value))
+	return nil
+}
@@ -0,0 +1,422 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/lexer"
+	"github.com/hvuhsg/spython/parser"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The module name the symbols of the program are prefixed with
+const mainModule = "__main__"
+
+// Prefixes the struct types of the classes that modules define
+const moduleTypePrefix = "module."
+
+// A namespace holds the module level names of a source file, the program or a
+// module it imports. Every module is compiled into the same LLVM module, so
+// the symbols of a module are prefixed with its name. The prefix also keeps
+// them from colliding with main, libc and the runtime
+type namespace struct {
+	name        string                 // the module name, empty for the program
+	prefix      string                 // prefixes the LLVM names of its functions and variables
+	file        string                 // the source file, shown in tracebacks
+	dir         string                 // where the modules it imports are searched first
+	init        *ir.Func               // runs the module level code
+	loaded      *ir.Global             // set when the module level code starts running
+	globals     map[string]*ir.Global  // module level variables that functions and importers can access
+	sharedNames map[string]bool        // names referenced inside function bodies
+	functions   map[string]*ir.Func    // module level functions by name
+	externs     map[string]*externFunc // C functions declared with extern def
+	classes     map[string]*class      // classes by name, including imported ones
+	modules     map[string]*namespace  // imported modules by the name they are bound to
+	defined     []*class               // the classes the module defines, in definition order
+	separate    bool                   // compiled separately, its symbols are only declared
+
+	globalBodies map[string][]func() error // queued bodies of the functions declaring a name 'global'
+}
+
+func newNamespace(name string, file string, dir string) *namespace {
+	prefix := name + "."
+	if name == "" {
+		prefix = mainModule + "."
+	}
+
+	return &namespace{
+		name:        name,
+		prefix:      prefix,
+		file:        file,
+		dir:         dir,
+		globals:     make(map[string]*ir.Global),
+		sharedNames: make(map[string]bool),
+		functions:   make(map[string]*ir.Func),
+		externs:     make(map[string]*externFunc),
+		classes:     make(map[string]*class),
+		modules:     make(map[string]*namespace),
+
+		globalBodies: make(map[string][]func() error),
+	}
+}
+
+// The LLVM name of a module level function or variable
+func (ns *namespace) symbol(name string) string {
+	return ns.prefix + name
+}
+
+// The name of the struct type of a class, types are not linked so the
+// classes of the program keep their own name. The prefix keeps the classes
+// of a module named list or dict from being named like a list or dict type
+func (ns *namespace) typeName(name string) string {
+	if ns.name == "" {
+		return name
+	}
+
+	return moduleTypePrefix + ns.name + "." + name
+}
+
+// The name of a function as written in the source, for error messages
+func displayName(fn *ir.Func) string {
+	return strings.TrimPrefix(fn.Name(), mainModule+".")
+}
+
+// A variable by name. A module level variable that only a function declaring
+// it 'global' assigns is created by compiling the body of that function, which
+// is otherwise compiled after the code using the variable
+func (c *context) lookupVar(name string) (value.Value, error) {
+	if vr := c.getVar(name); vr != nil {
+		return vr, nil
+	}
+
+	ns := c.compiler.ns
+	bodies := ns.globalBodies[name]
+	delete(ns.globalBodies, name)
+	for _, body := range bodies {
+		if err := body(); err != nil {
+			return nil, err
+		}
+	}
+
+	if global, ok := ns.globals[name]; ok {
+		return global, nil
+	}
+	return nil, nil
+}
+
+// A def, an extern or a class of a module can not reuse the name of another
+// one the module defines. kind is function or class
+func (ns *namespace) checkNewName(kind string, name string, tok token.Token) error {
+	defined := ""
+	if fn, ok := ns.functions[name]; ok && fn.Name() == ns.symbol(name) {
+		defined = "function"
+	} else if _, ok := ns.externs[name]; ok {
+		defined = "function"
+	} else if cls, ok := ns.classes[name]; ok && cls.symbol == ns.symbol(name) {
+		defined = "class"
+	}
+
+	if defined == kind {
+		return newError(fmt.Sprintf("%s '%s' is already defined", kind, name), NameError, tok)
+	} else if defined != "" {
+		return newError(fmt.Sprintf("name '%s' is already defined as a %s", name, defined), NameError, tok)
+	}
+
+	return nil
+}
+
+// A class by name, the built-in classes are visible in every module
+func (comp *compiler) lookupClass(name string) (*class, bool) {
+	if cls, ok := comp.ns.classes[name]; ok {
+		return cls, true
+	}
+
+	cls, ok := comp.builtins.classes[name]
+	return cls, ok
+}
+
+// The module an expression names, a variable shadows a module of its name
+func (c *context) moduleOf(exp ast.Expression) (*namespace, bool) {
+	ident, ok := exp.(*ast.Identifier)
+	if !ok || c.getVar(ident.Value) != nil {
+		return nil, false
+	}
+
+	mod, ok := c.compiler.ns.modules[ident.Value]
+	return mod, ok
+}
+
+// The class an expression names, e.g. Node or shapes.Circle
+func (c *context) classNamed(exp ast.Expression) (*class, bool) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return c.compiler.lookupClass(exp.Value)
+	case *ast.AttributeExpression:
+		mod, ok := c.moduleOf(exp.Object)
+		if !ok {
+			return nil, false
+		}
+		cls, ok := mod.classes[exp.Attribute.Value]
+		return cls, ok
+	default:
+		return nil, false
+	}
+}
+
+// Call a function or create an object of a class of an imported module,
+// e.g. shapes.area(c)
+func (c *context) compileModuleCall(mod *namespace, attr *ast.AttributeExpression, callExp *ast.CallExpression) error {
+	name := attr.Attribute.Value
+	if fn, ok := mod.functions[name]; ok {
+		return c.compileCall(fn, attr.String(), callExp.Arguments, callExp.Token)
+	}
+	if ext, ok := mod.externs[name]; ok {
+		return c.compileExternCall(ext, attr.String(), callExp.Arguments, callExp.Token)
+	}
+	if cls, ok := mod.classes[name]; ok {
+		return c.compileCall(cls.ctor, attr.String(), callExp.Arguments, callExp.Token)
+	}
+
+	// A module level variable holding a function value
+	if err := c.compile(attr); err != nil {
+		return err
+	}
+	return c.compileIndirectCall(c.popReg(), attr.String(), callExp)
+}
+
+func (c *context) compileImportStatement(importStat *ast.ImportStatement) error {
+	if !c.isModuleLevel() {
+		return newError("imports are only supported at module level", UnsupportedError, importStat.Token)
+	}
+
+	for _, name := range importStat.Modules {
+		mod, err := c.importModule(name.Name)
+		if err != nil {
+			return err
+		}
+		c.compiler.ns.modules[name.Bound()] = mod
+	}
+
+	return nil
+}
+
+// from module import name binds the function, extern or class of the module in the
+// importing module, a module level variable is copied into a variable of the
+// importing module like an assignment
+func (c *context) compileFromImportStatement(fromStat *ast.FromImportStatement) error {
+	if !c.isModuleLevel() {
+		return newError("imports are only supported at module level", UnsupportedError, fromStat.Token)
+	}
+
+	mod, err := c.importModule(fromStat.Module)
+	if err != nil {
+		return err
+	}
+
+	ns := c.compiler.ns
+	for _, name := range fromStat.Names {
+		if fn, ok := mod.functions[name.Name.Value]; ok {
+			ns.functions[name.Bound()] = fn
+		} else if ext, ok := mod.externs[name.Name.Value]; ok {
+			ns.externs[name.Bound()] = ext
+		} else if cls, ok := mod.classes[name.Name.Value]; ok {
+			ns.classes[name.Bound()] = cls
+		} else if global, ok := mod.globals[name.Name.Value]; ok {
+			vr := c.getVar(name.Bound())
+			if vr == nil {
+				vr = c.newVariable(name.Bound(), global.ContentType)
+				c.createVar(name.Bound(), vr)
+			} else if !vr.Type().(*types.PointerType).ElemType.Equal(global.ContentType) {
+				return newError(fmt.Sprintf("can not assign type %s into %s", c.compiler.displayType(global.ContentType), name.Bound()), TypeError, name.Name.Token)
+			}
+			c.store(c.NewLoad(global.ContentType, global), vr)
+		} else {
+			return newError(fmt.Sprintf("can not import name '%s' from module '%s'", name.Name.Value, mod.name), ImportError, name.Name.Token)
+		}
+	}
+
+	return nil
+}
+
+// Load a module the first time it is imported and run its module level code
+// the first time the program reaches an import of it
+func (c *context) importModule(ident *ast.Identifier) (*namespace, error) {
+	mod, err := c.compiler.loadModule(ident)
+	if err != nil {
+		return nil, err
+	}
+
+	c.setLocation(ident.Token)
+	run := c.newContext("import." + mod.name)
+	done := c.newContext("import.done")
+	c.NewCondBr(c.NewLoad(Bool, mod.loaded), done.Block, run.Block)
+	run.NewCall(mod.init)
+	run.checkException()
+	run.NewBr(done.Block)
+	c.Block = done.Block
+
+	return mod, nil
+}
+
+// Compile a module into its own namespace, or declare the symbols of a
+// separately compiled module from its interface
+func (comp *compiler) loadModule(ident *ast.Identifier) (*namespace, error) {
+	name := ident.Value
+
+	for i, importing := range comp.importing {
+		if importing.name == name {
+			chain := make([]string, 0, len(comp.importing)-i+1)
+			for _, mod := range comp.importing[i:] {
+				chain = append(chain, mod.name)
+			}
+			chain = append(chain, name)
+			return nil, newError(fmt.Sprintf("import cycle: %s", strings.Join(chain, " -> ")), ImportError, ident.Token)
+		}
+	}
+
+	if mod, ok := comp.modules[name]; ok {
+		return mod, nil
+	}
+
+	path, ok := comp.findModule(name)
+	if !ok {
+		return nil, newError(fmt.Sprintf("no module named '%s'", name), ImportError, ident.Token)
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, newError(fmt.Sprintf("can not read module '%s': %s", name, err), ImportError, ident.Token)
+	}
+
+	l := lexer.New(string(source))
+	p := parser.New(&l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newError(fmt.Sprintf("can not parse module '%s': %s", name, strings.Join(p.Errors(), ", ")), ImportError, ident.Token)
+	}
+
+	mod := newNamespace(name, path, filepath.Dir(path))
+	mod.separate = filepath.Ext(path) == ".spi"
+	comp.modules[name] = mod
+	comp.namespaces = append(comp.namespaces, mod)
+
+	// The bodies queued by the importing module are compiled in its namespace
+	importer, pending := comp.ns, comp.pendingBodies
+	comp.ns, comp.pendingBodies = mod, nil
+	comp.importing = append(comp.importing, mod)
+	defer func() {
+		comp.ns, comp.pendingBodies = importer, pending
+		comp.importing = comp.importing[:len(comp.importing)-1]
+	}()
+
+	if mod.separate {
+		err = comp.declareModule(mod, program)
+	} else {
+		err = comp.compileModule(mod, program)
+	}
+	if err != nil {
+		return nil, inFile(err, path)
+	}
+
+	return mod, nil
+}
+
+// The path of a module, it is searched next to the importing file and then
+// in the search path. In every directory the interface of a separately
+// compiled module, name.spi, is used before the source of the module unless
+// the source was changed after the interface was written
+func (comp *compiler) findModule(name string) (string, bool) {
+	dirs := append([]string{comp.ns.dir}, comp.options.Path...)
+	for _, dir := range dirs {
+		spi, hasSpi := moduleFile(filepath.Join(dir, name+".spi"))
+		sp, hasSp := moduleFile(filepath.Join(dir, name+".sp"))
+		switch {
+		case hasSpi && (!hasSp || !sp.ModTime().After(spi.ModTime())):
+			return filepath.Join(dir, name+".spi"), true
+		case hasSp:
+			return filepath.Join(dir, name+".sp"), true
+		}
+	}
+
+	return "", false
+}
+
+func moduleFile(path string) (os.FileInfo, bool) {
+	info, err := os.Stat(path)
+	return info, err == nil && !info.IsDir()
+}
+
+// Compile a module, its module level code is compiled into the init
+// function of the module
+func (comp *compiler) compileModule(mod *namespace, program *ast.Program) error {
+	mod.init = comp.module.NewFunc(mod.symbol("<module>"), types.Void)
+	mod.loaded = comp.module.NewGlobalDef(mod.symbol("<loaded>"), constant.False)
+
+	ctx := newContext(comp, mod.init, mod.init.NewBlock("entry"))
+	return ctx.compileInit(program)
+}
+
+// Compile the init function of a module, it marks the module as loaded
+// before running the module level code
+func (c *context) compileInit(program *ast.Program) error {
+	c.NewStore(constant.True, c.compiler.ns.loaded)
+	if err := c.compileModuleCode(program); err != nil {
+		return err
+	}
+
+	if c.Term == nil {
+		c.NewRet(nil)
+	}
+
+	if err := c.compiler.compilePendingBodies(); err != nil {
+		return err
+	}
+	c.releaseFrame()
+
+	return nil
+}
+
+// Resolve the scopes of a source file and compile its module level statements
+func (c *context) compileModuleCode(program *ast.Program) error {
+	// Module level variables used by functions must be stored in globals
+	scopes, shared := resolveScopes(program)
+	for node, scope := range scopes {
+		c.compiler.scopes[node] = scope
+	}
+	for name := range shared {
+		c.compiler.ns.sharedNames[name] = true
+	}
+
+	c.enterFunction("<module>")
+
+	for _, statement := range program.Statements {
+		c.skipTerminated()
+		if err := c.compile(statement); err != nil {
+			return err
+		}
+		c.endStatement()
+	}
+
+	return nil
+}
+
+// Compile the queued function bodies, compiling a body can queue the bodies
+// of nested functions
+func (comp *compiler) compilePendingBodies() error {
+	for len(comp.pendingBodies) > 0 {
+		body := comp.pendingBodies[0]
+		comp.pendingBodies = comp.pendingBodies[1:]
+		if err := body(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+)
+
+// Names declared 'nonlocal' are resolved to the cells of an enclosing
+// function before compiling, so the declaration only needs checking
+func (c *context) compileNonlocalStatement(nonlocalStat *ast.NonlocalStatement) error {
+	if c.isModuleLevel() {
+		return newError("nonlocal declaration not allowed at module level", NameError, nonlocalStat.Token)
+	}
+
+	for _, name := range nonlocalStat.Names {
+		if !c.scope.isFree(name.Value) {
+			return newError(fmt.Sprintf("no binding for nonlocal '%s' found", name.Value), NameError, name.Token)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/hvuhsg/spython/optimizer"
+)
+
+// OptimizedIR returns the IR of the program optimised by the LLVM opt tool,
+// with the pass pipeline of the options or the default pipeline of their
+// optimisation level. From level 1 the passes of the optimizer package clean
+// the IR first, level 1 runs only them so it needs no LLVM tools. At level 0
+// without a pipeline it is the IR as compiled, every variable in its own
+// stack slot, which keeps it easy to debug
+func (c *compiler) OptimizedIR() (string, error) {
+	level, passes := c.options.OptLevel, c.options.Passes
+	if level < 0 || level > 3 {
+		return "", fmt.Errorf("optimisation level %d is not one of 0, 1, 2 and 3", level)
+	}
+
+	if level > 0 {
+		optimizer.Optimize(c.module, optimizer.DefaultPasses()...)
+	}
+	code := c.IR()
+
+	if passes == "" {
+		if level < 2 {
+			return code, nil
+		}
+		passes = fmt.Sprintf("default<O%d>", level)
+	}
+
+	opt, err := exec.LookPath("opt")
+	if err != nil {
+		return "", fmt.Errorf("optimising needs the LLVM opt tool: %w", err)
+	}
+
+	cmd := exec.Command(opt, "-passes="+passes, "-S", "-o", "-")
+	cmd.Stdin = strings.NewReader(code)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("opt failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,241 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Optional[T], also written T | None, is a list, dict or object type that can
+// be None. It is the same LLVM pointer as T with None stored as null, but a
+// different type for the type checker. A variable is narrowed to T by the code
+// a comparison with None guards, e.g. the body of 'if x is not None:' or the
+// code after 'if x is None: return', and by assigning it a value of type T,
+// until it is assigned again. Variables that a call can assign, module level
+// variables shared with functions and variables shared with nested functions,
+// are not narrowed. Neither are fields, e.g. a.nxt, any call or an alias of a
+// could assign them, a field is narrowed by reading it into a variable first
+type optionalType struct {
+	*types.PointerType
+}
+
+func (t *optionalType) Equal(u types.Type) bool {
+	other, ok := u.(*optionalType)
+	return ok && t.PointerType.Equal(other.PointerType)
+}
+
+func isOptional(typ types.Type) bool {
+	_, ok := typ.(*optionalType)
+	return ok
+}
+
+// None has a type of its own until it is converted into an optional type
+var noneType = types.NewPointer(&types.StructType{TypeName: "None", Opaque: true})
+
+var noneValue = constant.NewNull(noneType)
+
+func isNoneLiteral(exp ast.Expression) bool {
+	ident, ok := exp.(*ast.Identifier)
+	return ok && ident.Token.Type == token.None
+}
+
+// Resolve Optional[T] and T | None
+func (c *context) resolveOptionalType(annotation ast.Expression, tok token.Token) (types.Type, error) {
+	elem, err := c.resolveType(annotation, tok)
+	if err != nil {
+		return nil, err
+	}
+
+	if isOptional(elem) {
+		return elem, nil
+	}
+
+	ptr, ok := elem.(*types.PointerType)
+	if !ok || !c.compiler.isManaged(elem) {
+		return nil, newError(fmt.Sprintf("Optional is only supported for lists, dicts and objects, not %s", c.compiler.displayType(elem)), TypeError, tok)
+	}
+
+	return &optionalType{ptr}, nil
+}
+
+// Use a value of type T or None where Optional[T] is expected
+func (c *context) toOptional(reg value.Value, typ *optionalType) value.Value {
+	if reg == noneValue {
+		return constant.NewBitCast(constant.NewNull(typ.PointerType), typ)
+	}
+
+	// An optional of a derived class is an optional of its base class
+	if from, ok := reg.Type().(*optionalType); ok {
+		fromCls, ok := c.compiler.classOf(from.PointerType)
+		toCls, isClass := c.compiler.classOf(typ.PointerType)
+		if ok && isClass && fromCls != toCls && fromCls.isSubclassOf(toCls) {
+			return c.NewBitCast(reg, typ)
+		}
+		return reg
+	}
+
+	reg = c.upcast(reg, typ.PointerType)
+	if !reg.Type().Equal(typ.PointerType) {
+		return reg
+	}
+	return c.NewBitCast(reg, typ)
+}
+
+// An optional value can only be used as its type after it was narrowed
+func checkNotNone(reg value.Value, typ types.Type, what string, tok token.Token) error {
+	if isOptional(reg.Type()) && !isOptional(typ) {
+		return newError(fmt.Sprintf("%s may be None, check it with 'is not None' first", what), TypeError, tok)
+	}
+
+	return nil
+}
+
+// An optional value used as an object, its field or element, must have been
+// narrowed. A field is never narrowed, so its check does not help
+func checkUsedNotNone(reg value.Value, exp ast.Expression, tok token.Token) error {
+	if _, ok := exp.(*ast.AttributeExpression); ok && isOptional(reg.Type()) {
+		return newError(fmt.Sprintf("%s may be None, only variables are narrowed, read it into a variable and check that with 'is not None'", exp.String()), TypeError, tok)
+	}
+
+	return checkNotNone(reg, nil, exp.String(), tok)
+}
+
+// x is None, x is not None and the identity of two objects
+func (c *context) compileIsExpression(isExp *ast.InfixExpression) error {
+	operands := make([]value.Value, 0, 2)
+	for _, exp := range []ast.Expression{isExp.Left, isExp.Right} {
+		if err := c.compile(exp); err != nil {
+			return err
+		}
+		reg := c.popReg()
+
+		switch typ := reg.Type(); {
+		case reg == noneValue:
+			operands = append(operands, constant.NewNull(I8Ptr))
+		case isOptional(typ), typ.Equal(Ptr), types.IsPointer(typ) && c.compiler.isManaged(typ):
+			operands = append(operands, c.NewBitCast(reg, I8Ptr))
+		default:
+			return newError(fmt.Sprintf("'%s' is only supported for objects and None, got %s", isExp.Operator, c.compiler.displayType(typ)), TypeError, isExp.Token)
+		}
+	}
+
+	pred := enum.IPredEQ
+	if isExp.Operator != token.Is {
+		pred = enum.IPredNE
+	}
+	c.pushReg(c.NewICmp(pred, operands[0], operands[1]))
+	return nil
+}
+
+// The optional variable a condition compares with None, and whether it is
+// not None when the condition is true. A field compared with None is not
+// narrowed, see optionalType
+func (c *context) noneCheck(cond ast.Expression) (string, bool, bool) {
+	isExp, ok := cond.(*ast.InfixExpression)
+	if !ok || (isExp.Operator != token.Is && isExp.Operator != token.Is+" "+token.Not) {
+		return "", false, false
+	}
+
+	ident, ok := isExp.Left.(*ast.Identifier)
+	if !ok || !isNoneLiteral(isExp.Right) {
+		ident, ok = isExp.Right.(*ast.Identifier)
+		if !ok || !isNoneLiteral(isExp.Left) {
+			return "", false, false
+		}
+	}
+
+	vr := c.getVar(ident.Value)
+	if vr == nil {
+		return "", false, false
+	}
+	ptr, ok := vr.Type().(*types.PointerType)
+	if !ok || !isOptional(ptr.ElemType) {
+		return "", false, false
+	}
+
+	return ident.Value, isExp.Operator != token.Is, true
+}
+
+// Whether an optional value was converted from a value of its type, which is
+// never None
+func isSome(reg value.Value) bool {
+	cast, ok := reg.(*ir.InstBitCast)
+	return ok && isOptional(cast.To) && !isOptional(cast.From.Type())
+}
+
+// Only variables that no call can assign are narrowed, module level variables
+// shared with functions and the variables of closures can be assigned None by
+// any call
+func (c *context) narrow(name string, narrowed bool) {
+	if narrowed && !c.isPrivate(name) {
+		return
+	}
+	if c.narrowed == nil {
+		c.narrowed = make(map[string]bool)
+	}
+	c.narrowed[name] = narrowed
+}
+
+// Whether only the code of the function using a variable can assign it
+func (c *context) isPrivate(name string) bool {
+	if _, ok := c.getVar(name).(*ir.Global); ok {
+		return false
+	}
+
+	return c.scope == nil || (!c.scope.captured[name] && !c.scope.isFree(name))
+}
+
+func (c *context) isNarrowed(name string) bool {
+	for ctx := c; ctx != nil && ctx.fn == c.fn; ctx = ctx.parent {
+		if narrowed, ok := ctx.narrowed[name]; ok {
+			return narrowed
+		}
+	}
+
+	return false
+}
+
+// Assigning an optional variable ends its narrowing in the enclosing blocks
+// too, as they continue after the block that assigned it
+func (c *context) widen(name string) {
+	for ctx := c; ctx != nil && ctx.fn == c.fn; ctx = ctx.parent {
+		ctx.narrow(name, false)
+	}
+}
+
+// The variables a loop body assigns are not narrowed when it starts, a later
+// iteration can start after they were assigned None
+func (c *context) widenAssigned(body ast.Node) {
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral, *ast.LambdaExpression:
+			return false
+		case *ast.AssignStatement:
+			for _, target := range node.Targets {
+				c.widenTarget(target)
+			}
+		case *ast.AnnotatedAssignStatement:
+			c.narrow(node.Name.Value, false)
+		case *ast.AugmentedAssignStatement:
+			c.widenTarget(node.Target)
+		}
+		return true
+	})
+}
+
+func (c *context) widenTarget(target ast.Expression) {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		c.narrow(target.Value, false)
+	case *ast.TupleLiteral:
+		for _, element := range target.Elements {
+			c.widenTarget(element)
+		}
+	}
+}
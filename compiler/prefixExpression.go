@@ -0,0 +1,40 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compilePrefixExpression(prefixExp *ast.PrefixExpression) error {
+	if err := c.compile(prefixExp.Right); err != nil {
+		return err
+	}
+	reg := c.popReg()
+
+	var res value.Value
+	switch prefixExp.Operator {
+	case token.Minus:
+		if types.IsInt(reg.Type()) {
+			res = c.NewSub(constant.NewInt(reg.Type().(*types.IntType), 0), reg)
+		} else if types.IsFloat(reg.Type()) {
+			res = c.NewFNeg(reg)
+		}
+	case token.Tilde:
+		if !types.IsInt(reg.Type()) {
+			return newError(fmt.Sprintf("bad operand type for unary ~: '%s'", c.compiler.displayType(reg.Type())), TypeError, prefixExp.Token)
+		}
+		res = c.NewXor(reg, constant.NewInt(reg.Type().(*types.IntType), -1))
+	}
+
+	if res == nil {
+		return newError("unsupported operator", UnsupportedError, prefixExp.Token)
+	}
+
+	c.pushReg(res)
+	return nil
+}
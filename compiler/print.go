@@ -0,0 +1,146 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+const stderr = 2
+
+// print(a, b) writes its arguments separated by spaces and a new line to
+// stdout, after evaluating all of them
+func (c *context) compilePrint(callExp *ast.CallExpression) error {
+	regs := make([]value.Value, 0, len(callExp.Arguments))
+	for i, arg := range callExp.Arguments {
+		if err := c.compile(arg); err != nil {
+			return err
+		}
+		regs = append(regs, c.hold(c.popReg(), callExp.Arguments[i+1:]...))
+	}
+
+	for i, reg := range regs {
+		if i > 0 {
+			c.printString(c.compiler.cString(" "))
+		}
+
+		if err := c.printValue(reg, callExp); err != nil {
+			return err
+		}
+	}
+
+	c.pushReg(c.printString(c.compiler.cString("\n")))
+	return nil
+}
+
+func (c *context) printValue(reg value.Value, callExp *ast.CallExpression) error {
+	typ := reg.Type()
+
+	switch {
+	case typ.Equal(Int):
+		c.printf("%ld", reg)
+	case typ.Equal(Bool):
+		c.printString(c.NewSelect(reg, c.compiler.cString("True"), c.compiler.cString("False")))
+	case typ.Equal(Float):
+		c.NewCall(c.compiler.printFloatFunc(), reg)
+	case typ.Equal(Str):
+		c.printString(reg)
+	case reg == noneValue:
+		c.printString(c.compiler.cString("None"))
+	case isOptional(typ):
+		return c.printOptional(reg, callExp)
+	default:
+		cls, ok := c.compiler.classOf(typ)
+		if !ok {
+			return newError(fmt.Sprintf("print() does not support type %s", c.compiler.displayType(typ)), TypeError, callExp.Token)
+		}
+
+		str, ok, err := c.callSpecialMethod(reg, "__str__", nil, callExp.Token)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.printString(str)
+		} else {
+			c.printf("<%s object at %p>", c.compiler.cString(cls.name), reg)
+		}
+	}
+
+	return nil
+}
+
+// Print None or the value of an optional
+func (c *context) printOptional(reg value.Value, callExp *ast.CallExpression) error {
+	opt := reg.Type().(*optionalType)
+	none := c.newContext("print.none")
+	some := c.newContext("print.some")
+	end := c.newContext("print.end")
+	ptr := c.NewBitCast(reg, opt.PointerType)
+	c.NewCondBr(c.NewICmp(enum.IPredEQ, ptr, constant.NewNull(opt.PointerType)), none.Block, some.Block)
+
+	none.printString(c.compiler.cString("None"))
+	none.NewBr(end.Block)
+
+	some.temps = c.temps
+	if err := some.printValue(ptr, callExp); err != nil {
+		return err
+	}
+	some.NewBr(end.Block)
+
+	c.Block = end.Block
+	return nil
+}
+
+func (c *context) printString(str value.Value) value.Value {
+	return c.NewCall(c.compiler.printStringFunc(), str)
+}
+
+// print writes through stdio, like the C functions a program calls, so their
+// output is not reordered by the stdout buffer
+func (c *context) printf(format string, args ...value.Value) {
+	args = append([]value.Value{c.compiler.cString(format)}, args...)
+	c.NewCall(c.compiler.libcFunc("printf"), args...)
+}
+
+// void spython_print_str(i8* str) writes a string to stdout
+func (comp *compiler) printStringFunc() *ir.Func {
+	return comp.runtimeFunc("spython_print_str", func(name string) *ir.Func {
+		str := ir.NewParam("str", Str)
+		fn := comp.module.NewFunc(name, types.Void, str)
+
+		entry := fn.NewBlock("entry")
+		entry.NewCall(comp.libcFunc("printf"), comp.cString("%s"), str)
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// void spython_print_float(float x) writes a float to stdout the way python
+// does, with a trailing .0 when it has no fraction e.g. 2.0
+func (comp *compiler) printFloatFunc() *ir.Func {
+	return comp.runtimeFunc("spython_print_float", func(name string) *ir.Func {
+		x := ir.NewParam("x", Float)
+		fn := comp.module.NewFunc(name, types.Void, x)
+
+		entry := fn.NewBlock("entry")
+		bufTyp := types.NewArray(32, types.I8)
+		buf := entry.NewBitCast(entry.NewAlloca(bufTyp), I8Ptr)
+		entry.NewCall(comp.libcFunc("snprintf"), buf, constant.NewInt(types.I64, 32), comp.cString("%g"), entry.NewFPExt(x, types.Double))
+
+		// Only digits and a sign means the number has no fraction or exponent
+		length := entry.NewCall(comp.libcFunc("strlen"), buf)
+		digits := entry.NewCall(comp.libcFunc("strspn"), buf, comp.cString("-0123456789"))
+		whole := entry.NewICmp(enum.IPredEQ, length, digits)
+		format := entry.NewSelect(whole, comp.cString("%s.0"), comp.cString("%s"))
+		entry.NewCall(comp.libcFunc("printf"), format, buf)
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
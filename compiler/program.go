@@ -6,19 +6,34 @@ import (
 )
 
 func (c *context) compileProgram(program *ast.Program) error {
-	for _, statement := range program.Statements {
-		err := c.compile(statement)
-		if err != nil {
-			return err
-		}
+	if c.compiler.options.Library != "" {
+		return c.compileLibrary(program)
+	}
+
+	if err := c.compileExceptionClasses(); err != nil {
+		return err
+	}
+
+	if err := c.compileModuleCode(program); err != nil {
+		return err
 	}
 
 	retVal := c.popReg()
-	if retVal != nil {
+	if c.Term != nil {
+		// The module ended with a return statement
+	} else if retVal != nil && retVal.Type().Equal(Int) {
 		c.NewRet(retVal)
 	} else {
 		c.NewRet(constant.NewInt(Int, 0))
 	}
 
+	if err := c.compiler.compilePendingBodies(); err != nil {
+		return err
+	}
+
+	// Globals can be declared by function bodies, so main releases them last
+	c.releaseFrame()
+	c.releaseGlobals()
+
 	return nil
 }
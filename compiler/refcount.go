@@ -0,0 +1,490 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Heap objects, lists, dicts, class instances, closure envs and the cells of
+// captured variables, are reference counted. They are allocated by
+// spython_alloc with a header in front of them:
+//
+//	{ i64 refcount, void (i8*)* drop, i8* name, header* prev, header* next }
+//
+// A new object has a refcount of 1. When the refcount drops to 0 the drop
+// function releases the objects it references and the memory is freed.
+// prev and next link the live objects when leaks are reported.
+//
+// Values that come out of an expression are borrowed, except for the results
+// of calls and allocations that are owned temporaries, released at the end
+// of the statement that created them. A borrowed value is retained as a
+// temporary too when an operand compiled after it can run code, e.g. a call
+// that reassigns the variable it was read from. Storing a value into a variable, field
+// or container element retains it and releases the value it replaces, so
+// arguments are retained when a function stores them in its parameters.
+// Functions release their local variables when they return and return an
+// owned value, main also releases the module level variables. Strings are
+// constants and are not reference counted.
+//
+// Reference counting can not free cycles, e.g. an object that stores itself
+// in one of its fields or two nested functions that call each other, they
+// stay alive until the program exits. Compiled with Options.DebugLeaks the
+// objects still alive when main returns are reported on stderr.
+
+// The variables of a function that hold references
+type frame struct {
+	locals     []value.Value // stack variables holding managed values
+	cells      []value.Value // the cells of captured variables
+	stackEntry value.Value   // the entry of the function on the shadow call stack
+	unwind     *ir.Block     // returns when an exception is raised outside try statements
+	names      map[string]int
+}
+
+// Variables of different blocks of a function can have the same name, e.g.
+// the names bound by two except clauses, so later ones get a suffix
+func (f *frame) uniqueName(name string) string {
+	if f.names == nil {
+		f.names = make(map[string]int)
+	}
+
+	n := f.names[name]
+	f.names[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%d", name, n)
+}
+
+const (
+	headerRefcount = iota
+	headerDrop
+	headerName
+	headerPrev
+	headerNext
+)
+
+var dropFuncType = types.NewPointer(types.NewFunc(types.Void, I8Ptr))
+
+func (comp *compiler) headerType() *types.StructType {
+	if comp.header == nil {
+		comp.header = types.NewStruct()
+		comp.module.NewTypeDef("spython.header", comp.header)
+		comp.header.Fields = []types.Type{Int, dropFuncType, I8Ptr, types.NewPointer(comp.header), types.NewPointer(comp.header)}
+	}
+
+	return comp.header
+}
+
+// Check if values of a type hold references to heap objects
+func (comp *compiler) isManaged(typ types.Type) bool {
+	if _, ok := comp.listElem(typ); ok {
+		return true
+	}
+	if _, _, ok := comp.dictElems(typ); ok {
+		return true
+	}
+	if _, ok := comp.classOf(typ); ok {
+		return true
+	}
+	if _, ok := comp.callableSig(typ); ok {
+		return true
+	}
+	if opt, ok := typ.(*optionalType); ok {
+		return comp.isManaged(opt.PointerType)
+	}
+	if elems, ok := tupleElems(typ); ok {
+		for _, elem := range elems {
+			if comp.isManaged(elem) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Increment the refcount of every object a value references
+func (comp *compiler) retain(b *ir.Block, val value.Value) {
+	comp.refcount(b, val, comp.increfFunc())
+}
+
+// Decrement the refcount of every object a value references
+func (comp *compiler) release(b *ir.Block, val value.Value) {
+	comp.refcount(b, val, comp.decrefFunc())
+}
+
+func (comp *compiler) refcount(b *ir.Block, val value.Value, fn *ir.Func) {
+	typ := val.Type()
+
+	switch {
+	case !comp.isManaged(typ):
+	case types.IsPointer(typ), isOptional(typ):
+		b.NewCall(fn, b.NewBitCast(val, I8Ptr))
+	case types.IsStruct(typ):
+		if _, ok := comp.callableSig(typ); ok {
+			b.NewCall(fn, b.NewExtractValue(val, callableEnv))
+			return
+		}
+
+		elems, _ := tupleElems(typ)
+		for i, elem := range elems {
+			if comp.isManaged(elem) {
+				comp.refcount(b, b.NewExtractValue(val, uint64(i)), fn)
+			}
+		}
+	}
+}
+
+// Store a value retaining it and releasing the value it replaces, the
+// location must hold a valid or zero value
+func (c *context) store(val value.Value, ptr value.Value) {
+	if !c.compiler.isManaged(val.Type()) {
+		c.NewStore(val, ptr)
+		return
+	}
+
+	c.compiler.retain(c.Block, val)
+	old := c.NewLoad(val.Type(), ptr)
+	c.NewStore(val, ptr)
+	c.compiler.release(c.Block, old)
+}
+
+// Register an owned value to be released at the end of the statement
+func (c *context) temp(val value.Value) value.Value {
+	if c.compiler.isManaged(val.Type()) {
+		c.temps = append(c.temps, val)
+	}
+
+	return val
+}
+
+// Keep a borrowed value alive until the end of the statement when the
+// expressions compiled after it can run code that releases it
+func (c *context) hold(val value.Value, later ...ast.Expression) value.Value {
+	if !c.compiler.isManaged(val.Type()) || !mayRunCode(later) {
+		return val
+	}
+
+	c.compiler.retain(c.Block, val)
+	return c.temp(val)
+}
+
+// Whether compiling expressions can run code of the program, calls and the
+// operators that call special methods of objects
+func mayRunCode(exps []ast.Expression) bool {
+	run := false
+	for _, exp := range exps {
+		ast.Inspect(exp, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.LambdaExpression:
+				return false
+			case *ast.CallExpression, *ast.IndexExpression:
+				run = true
+			case *ast.InfixExpression:
+				if _, ok := operatorMethods[node.Operator]; ok {
+					run = true
+				}
+			}
+			return !run
+		})
+	}
+
+	return run
+}
+
+// Release the temporaries of the statement that was compiled
+func (c *context) releaseTemps() {
+	for _, val := range c.temps {
+		c.compiler.release(c.Block, val)
+	}
+	c.temps = nil
+}
+
+// Release the temporaries of a statement that did not return, a return
+// statement releases them before it returns
+func (c *context) endStatement() {
+	if c.Term == nil {
+		c.releaseTemps()
+	}
+	c.temps = nil
+}
+
+// Release the local variables of the function and pop its shadow call stack
+// entry before every return, this runs after the body is compiled so every
+// variable is known
+func (c *context) releaseFrame() {
+	for _, block := range c.fn.Blocks {
+		if _, ok := block.Term.(*ir.TermRet); !ok {
+			continue
+		}
+
+		for _, local := range c.frame.locals {
+			typ := local.Type().(*types.PointerType).ElemType
+			c.compiler.release(block, block.NewLoad(typ, local))
+		}
+		for _, cell := range c.frame.cells {
+			block.NewCall(c.compiler.decrefFunc(), block.NewBitCast(cell, I8Ptr))
+		}
+		if c.frame.stackEntry != nil {
+			entryPtr := types.NewPointer(c.compiler.stackEntryType())
+			prev := block.NewLoad(entryPtr, c.compiler.stackField(block, c.frame.stackEntry, entryPrev))
+			block.NewStore(prev, c.compiler.callStack())
+		}
+	}
+}
+
+// Release the module level variables of every module before main returns and
+// report the objects that are still alive when leaks are reported
+func (c *context) releaseGlobals() {
+	var globals []*ir.Global
+	for _, ns := range c.compiler.namespaces {
+		names := make([]string, 0, len(ns.globals))
+		for name, global := range ns.globals {
+			if c.compiler.isManaged(global.ContentType) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			globals = append(globals, ns.globals[name])
+		}
+	}
+
+	for _, block := range c.fn.Blocks {
+		if _, ok := block.Term.(*ir.TermRet); !ok {
+			continue
+		}
+
+		for _, global := range globals {
+			c.compiler.release(block, block.NewLoad(global.ContentType, global))
+		}
+		if c.compiler.options.DebugLeaks {
+			block.NewCall(c.compiler.reportLeaksFunc())
+		}
+	}
+}
+
+// void spython_cell_T_drop(i8* cell) releases the value of a captured variable
+func (comp *compiler) cellDropFunc(typ types.Type) *ir.Func {
+	return comp.runtimeFunc("spython_cell_"+typeTag(typ)+"_drop", func(name string) *ir.Func {
+		cell := ir.NewParam("cell", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, cell)
+
+		entry := fn.NewBlock("entry")
+		comp.release(entry, entry.NewLoad(typ, entry.NewBitCast(cell, types.NewPointer(typ))))
+		entry.NewRet(nil)
+
+		return fn
+	})
+}
+
+// Pointer to the header of an object
+func (comp *compiler) headerOf(b *ir.Block, obj value.Value) value.Value {
+	headerPtr := types.NewPointer(comp.headerType())
+	return b.NewGetElementPtr(headerPtr.ElemType, b.NewBitCast(obj, headerPtr), constant.NewInt(Int, -1))
+}
+
+func (comp *compiler) headerField(b *ir.Block, header value.Value, field int64) value.Value {
+	return b.NewGetElementPtr(comp.headerType(), header, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// Allocate a reference counted object of a type with the function that
+// releases the objects it references, nil when it references none
+func (comp *compiler) alloc(b *ir.Block, typ types.Type, drop *ir.Func, name string) value.Value {
+	var dropFn constant.Constant = constant.NewNull(dropFuncType)
+	if drop != nil {
+		dropFn = drop
+	}
+
+	raw := b.NewCall(comp.allocFunc(), sizeOf(typ), dropFn, comp.cString(name))
+	return b.NewBitCast(raw, types.NewPointer(typ))
+}
+
+// i8* spython_alloc(i64 size, void (i8*)* drop, i8* name) allocates an object with a refcount of 1
+func (comp *compiler) allocFunc() *ir.Func {
+	return comp.runtimeFunc("spython_alloc", func(name string) *ir.Func {
+		size := ir.NewParam("size", Int)
+		drop := ir.NewParam("drop", dropFuncType)
+		typeName := ir.NewParam("name", I8Ptr)
+		fn := comp.module.NewFunc(name, I8Ptr, size, drop, typeName)
+
+		headerTyp := comp.headerType()
+		entry := fn.NewBlock("entry")
+		raw := entry.NewCall(comp.libcFunc("malloc"), entry.NewAdd(sizeOf(headerTyp), size))
+		header := entry.NewBitCast(raw, types.NewPointer(headerTyp))
+		entry.NewStore(constant.NewInt(Int, 1), comp.headerField(entry, header, headerRefcount))
+		entry.NewStore(drop, comp.headerField(entry, header, headerDrop))
+		entry.NewStore(typeName, comp.headerField(entry, header, headerName))
+		entry.NewStore(constant.NewNull(types.NewPointer(headerTyp)), comp.headerField(entry, header, headerPrev))
+
+		// Link the object in front of the live objects list
+		null := constant.NewNull(types.NewPointer(headerTyp))
+		if comp.options.DebugLeaks {
+			live := comp.liveObjects()
+			head := entry.NewLoad(live.ContentType, live)
+			entry.NewStore(head, comp.headerField(entry, header, headerNext))
+			entry.NewStore(header, live)
+
+			link := fn.NewBlock("link")
+			done := fn.NewBlock("done")
+			entry.NewCondBr(entry.NewICmp(enum.IPredNE, head, null), link, done)
+			link.NewStore(header, comp.headerField(link, head, headerPrev))
+			link.NewBr(done)
+			entry = done
+		} else {
+			entry.NewStore(null, comp.headerField(entry, header, headerNext))
+		}
+
+		body := entry.NewGetElementPtr(headerTyp, header, constant.NewInt(Int, 1))
+		entry.NewRet(entry.NewBitCast(body, I8Ptr))
+
+		return fn
+	})
+}
+
+// The head of the live objects list, only used when leaks are reported
+func (comp *compiler) liveObjects() *ir.Global {
+	headerPtr := types.NewPointer(comp.headerType())
+	if comp.live == nil {
+		comp.live = comp.runtimeGlobal("spython_live", constant.NewNull(headerPtr))
+	}
+
+	return comp.live
+}
+
+// void spython_incref(i8* obj)
+func (comp *compiler) increfFunc() *ir.Func {
+	return comp.runtimeFunc("spython_incref", func(name string) *ir.Func {
+		obj := ir.NewParam("obj", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, obj)
+
+		entry := fn.NewBlock("entry")
+		inc := fn.NewBlock("inc")
+		done := fn.NewBlock("done")
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, obj, constant.NewNull(I8Ptr)), done, inc)
+
+		refcount := comp.headerField(inc, comp.headerOf(inc, obj), headerRefcount)
+		inc.NewStore(inc.NewAdd(inc.NewLoad(Int, refcount), constant.NewInt(Int, 1)), refcount)
+		inc.NewBr(done)
+
+		done.NewRet(nil)
+
+		return fn
+	})
+}
+
+// void spython_decref(i8* obj) drops and frees an object when its refcount reaches 0
+func (comp *compiler) decrefFunc() *ir.Func {
+	return comp.runtimeFunc("spython_decref", func(name string) *ir.Func {
+		obj := ir.NewParam("obj", I8Ptr)
+		fn := comp.module.NewFunc(name, types.Void, obj)
+
+		entry := fn.NewBlock("entry")
+		dec := fn.NewBlock("dec")
+		drop := fn.NewBlock("drop")
+		callDrop := fn.NewBlock("call_drop")
+		free := fn.NewBlock("free")
+		done := fn.NewBlock("done")
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, obj, constant.NewNull(I8Ptr)), done, dec)
+
+		header := comp.headerOf(dec, obj)
+		refcountPtr := comp.headerField(dec, header, headerRefcount)
+		refcount := dec.NewSub(dec.NewLoad(Int, refcountPtr), constant.NewInt(Int, 1))
+		dec.NewStore(refcount, refcountPtr)
+		dec.NewCondBr(dec.NewICmp(enum.IPredEQ, refcount, constant.NewInt(Int, 0)), drop, done)
+
+		dropFn := drop.NewLoad(dropFuncType, comp.headerField(drop, header, headerDrop))
+		drop.NewCondBr(drop.NewICmp(enum.IPredEQ, dropFn, constant.NewNull(dropFuncType)), free, callDrop)
+
+		callDrop.NewCall(dropFn, obj)
+		callDrop.NewBr(free)
+
+		if comp.options.DebugLeaks {
+			free = comp.unlinkObject(fn, free, header)
+		}
+		free.NewCall(comp.libcFunc("free"), free.NewBitCast(header, I8Ptr))
+		free.NewBr(done)
+
+		done.NewRet(nil)
+
+		return fn
+	})
+}
+
+// Remove a freed object from the live objects list, returns the block that
+// continues after it
+func (comp *compiler) unlinkObject(fn *ir.Func, b *ir.Block, header value.Value) *ir.Block {
+	headerPtr := types.NewPointer(comp.headerType())
+	null := constant.NewNull(headerPtr)
+
+	hasPrev := fn.NewBlock("has_prev")
+	isHead := fn.NewBlock("is_head")
+	linkNext := fn.NewBlock("link_next")
+	hasNext := fn.NewBlock("has_next")
+	unlinked := fn.NewBlock("unlinked")
+
+	prev := b.NewLoad(headerPtr, comp.headerField(b, header, headerPrev))
+	next := b.NewLoad(headerPtr, comp.headerField(b, header, headerNext))
+	b.NewCondBr(b.NewICmp(enum.IPredEQ, prev, null), isHead, hasPrev)
+
+	hasPrev.NewStore(next, comp.headerField(hasPrev, prev, headerNext))
+	hasPrev.NewBr(linkNext)
+
+	isHead.NewStore(next, comp.liveObjects())
+	isHead.NewBr(linkNext)
+
+	linkNext.NewCondBr(linkNext.NewICmp(enum.IPredEQ, next, null), unlinked, hasNext)
+
+	hasNext.NewStore(prev, comp.headerField(hasNext, next, headerPrev))
+	hasNext.NewBr(unlinked)
+
+	return unlinked
+}
+
+// void spython_report_leaks() writes the objects that are still alive to stderr
+func (comp *compiler) reportLeaksFunc() *ir.Func {
+	return comp.runtimeFunc("spython_report_leaks", func(name string) *ir.Func {
+		fn := comp.module.NewFunc(name, types.Void)
+		headerPtr := types.NewPointer(comp.headerType())
+
+		entry := fn.NewBlock("entry")
+		loop := fn.NewBlock("loop")
+		report := fn.NewBlock("report")
+		summary := fn.NewBlock("summary")
+		total := fn.NewBlock("total")
+		done := fn.NewBlock("done")
+
+		head := entry.NewLoad(headerPtr, comp.liveObjects())
+		entry.NewBr(loop)
+
+		current := loop.NewPhi(ir.NewIncoming(head, entry))
+		count := loop.NewPhi(ir.NewIncoming(constant.NewInt(Int, 0), entry))
+		loop.NewCondBr(loop.NewICmp(enum.IPredEQ, current, constant.NewNull(headerPtr)), summary, report)
+
+		typeName := report.NewLoad(I8Ptr, comp.headerField(report, current, headerName))
+		refcount := report.NewLoad(Int, comp.headerField(report, current, headerRefcount))
+		obj := report.NewGetElementPtr(comp.headerType(), current, constant.NewInt(Int, 1))
+		report.NewCall(comp.libcFunc("dprintf"), constant.NewInt(types.I32, stderr), comp.cString("leaked %s object at %p with refcount %ld\n"), typeName, obj, refcount)
+		next := report.NewLoad(headerPtr, comp.headerField(report, current, headerNext))
+		current.Incs = append(current.Incs, ir.NewIncoming(next, report))
+		count.Incs = append(count.Incs, ir.NewIncoming(report.NewAdd(count, constant.NewInt(Int, 1)), report))
+		report.NewBr(loop)
+
+		summary.NewCondBr(summary.NewICmp(enum.IPredEQ, count, constant.NewInt(Int, 0)), done, total)
+
+		total.NewCall(comp.libcFunc("dprintf"), constant.NewInt(types.I32, stderr), comp.cString("%ld objects leaked\n"), count)
+		total.NewBr(done)
+
+		done.NewRet(nil)
+
+		return fn
+	})
+}
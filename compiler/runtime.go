@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+var I8Ptr = types.I8Ptr
+
+// Get a runtime function, defining it with build on first use. Every
+// compilation unit defines the runtime functions it uses, so they are linked
+// once and the linker keeps one of the definitions
+func (comp *compiler) runtimeFunc(name string, build func(name string) *ir.Func) *ir.Func {
+	if fn, ok := comp.runtime[name]; ok {
+		return fn
+	}
+
+	fn := build(name)
+	if len(fn.Blocks) > 0 {
+		fn.Linkage = enum.LinkageLinkOnceODR
+	}
+	comp.runtime[name] = fn
+	return fn
+}
+
+// Define a global of the runtime, shared by the compilation units of a program
+func (comp *compiler) runtimeGlobal(name string, init constant.Constant) *ir.Global {
+	global := comp.module.NewGlobalDef(name, init)
+	global.Linkage = enum.LinkageLinkOnceODR
+	return global
+}
+
+// Declare a libc function used by the runtime
+func (comp *compiler) libcFunc(name string) *ir.Func {
+	return comp.runtimeFunc(name, func(name string) *ir.Func {
+		fn := comp.declareLibc(name)
+		if fn == nil {
+			panic("unknown libc function " + name)
+		}
+		return fn
+	})
+}
+
+// Declare a libc function with the signature the runtime calls it with, nil
+// for a function the runtime does not use
+func (comp *compiler) declareLibc(name string) *ir.Func {
+	switch name {
+	case "malloc":
+		return comp.module.NewFunc(name, I8Ptr, ir.NewParam("size", types.I64))
+	case "realloc":
+		return comp.module.NewFunc(name, I8Ptr, ir.NewParam("ptr", I8Ptr), ir.NewParam("size", types.I64))
+	case "free":
+		return comp.module.NewFunc(name, types.Void, ir.NewParam("ptr", I8Ptr))
+	case "strlen":
+		return comp.module.NewFunc(name, types.I64, ir.NewParam("str", I8Ptr))
+	case "write":
+		return comp.module.NewFunc(name, types.I64, ir.NewParam("fd", types.I32), ir.NewParam("buf", I8Ptr), ir.NewParam("count", types.I64))
+	case "calloc":
+		return comp.module.NewFunc(name, I8Ptr, ir.NewParam("count", types.I64), ir.NewParam("size", types.I64))
+	case "strcmp":
+		return comp.module.NewFunc(name, types.I32, ir.NewParam("x", I8Ptr), ir.NewParam("y", I8Ptr))
+	case "dprintf":
+		fn := comp.module.NewFunc(name, types.I32, ir.NewParam("fd", types.I32), ir.NewParam("format", I8Ptr))
+		fn.Sig.Variadic = true
+		return fn
+	case "printf":
+		fn := comp.module.NewFunc(name, types.I32, ir.NewParam("format", I8Ptr))
+		fn.Sig.Variadic = true
+		return fn
+	case "snprintf":
+		fn := comp.module.NewFunc(name, types.I32, ir.NewParam("buf", I8Ptr), ir.NewParam("size", types.I64), ir.NewParam("format", I8Ptr))
+		fn.Sig.Variadic = true
+		return fn
+	case "strspn":
+		return comp.module.NewFunc(name, types.I64, ir.NewParam("str", I8Ptr), ir.NewParam("accept", I8Ptr))
+	case "exit":
+		return comp.module.NewFunc(name, types.Void, ir.NewParam("status", types.I32))
+	default:
+		return nil
+	}
+}
+
+// A pointer to a null terminated string constant
+func (comp *compiler) cString(s string) constant.Constant {
+	global, ok := comp.strings[s]
+	if !ok {
+		global = comp.module.NewGlobalDef(fmt.Sprintf(".str.%d", len(comp.strings)), constant.NewCharArrayFromString(s+"\x00"))
+		global.Immutable = true
+		global.Linkage = enum.LinkagePrivate
+		comp.strings[s] = global
+	}
+
+	zero := constant.NewInt(types.I64, 0)
+	return constant.NewGetElementPtr(global.ContentType, global, zero, zero)
+}
+
+// The size of a type in bytes, as a constant expression
+func sizeOf(typ types.Type) constant.Constant {
+	ptrTyp := types.NewPointer(typ)
+	end := constant.NewGetElementPtr(typ, constant.NewNull(ptrTyp), constant.NewInt(types.I32, 1))
+	return constant.NewPtrToInt(end, types.I64)
+}
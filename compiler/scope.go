@@ -0,0 +1,199 @@
+package compiler
+
+import "github.com/hvuhsg/spython/ast"
+
+// The names a function binds and the names it shares with the functions
+// around it and inside it
+type funcScope struct {
+	locals   map[string]bool // parameters, assigned variables and nested functions
+	globals  map[string]bool // names declared 'global'
+	captured map[string]bool // locals used by nested functions, stored in heap cells
+	free     []string        // locals of enclosing functions used by this function or the functions nested in it, in env order
+	self     bool            // a nested function that uses its own name
+}
+
+func (scope *funcScope) isFree(name string) bool {
+	for _, free := range scope.free {
+		if free == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (scope *funcScope) addFree(name string) {
+	if !scope.isFree(name) {
+		scope.free = append(scope.free, name)
+	}
+}
+
+// Resolve the names used by every function of the program to the function
+// that binds them, names that no enclosing function binds are module level
+// names that functions share with the module
+func resolveScopes(program *ast.Program) (map[ast.Node]*funcScope, map[string]bool) {
+	scopes := make(map[ast.Node]*funcScope)
+	shared := make(map[string]bool)
+
+	// fn is a function literal or a lambda expression
+	var resolve func(fn ast.Node, enclosing []*funcScope)
+	resolve = func(fn ast.Node, enclosing []*funcScope) {
+		scope := newFuncScope(fn, len(enclosing) > 0)
+		scopes[fn] = scope
+		chain := append(enclosing[:len(enclosing):len(enclosing)], scope)
+
+		use := func(name string) {
+			if scope.locals[name] {
+				return
+			}
+
+			if !scope.globals[name] {
+				for i := len(enclosing) - 1; i >= 0; i-- {
+					if enclosing[i].locals[name] {
+						// every function between the binding one and this one passes the cell along
+						enclosing[i].captured[name] = true
+						for _, inner := range chain[i+1:] {
+							inner.addFree(name)
+						}
+						return
+					}
+				}
+			}
+
+			shared[name] = true
+		}
+
+		var visit func(node ast.Node) bool
+		visit = func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.FunctionLiteral, *ast.LambdaExpression:
+				resolve(node, chain)
+				return false
+			case *ast.AttributeExpression:
+				// attribute names are not variables
+				ast.Inspect(node.Object, visit)
+				return false
+			case *ast.Identifier:
+				use(node.Value)
+			}
+			return true
+		}
+
+		switch fn := fn.(type) {
+		case *ast.FunctionLiteral:
+			ast.Inspect(fn.Body, visit)
+		case *ast.LambdaExpression:
+			ast.Inspect(fn.Body, visit)
+		}
+	}
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.FunctionLiteral, *ast.LambdaExpression:
+			resolve(node, nil)
+			return false
+		}
+		return true
+	})
+
+	return scopes, shared
+}
+
+// Find the names bound inside a function, its parameters, nested functions
+// and assigned variables that are not declared 'global' or 'nonlocal'
+func newFuncScope(fn ast.Node, nested bool) *funcScope {
+	scope := &funcScope{
+		locals:   make(map[string]bool),
+		globals:  make(map[string]bool),
+		captured: make(map[string]bool),
+	}
+
+	// a lambda only binds its parameters
+	if lambda, ok := fn.(*ast.LambdaExpression); ok {
+		for _, param := range lambda.Parameters {
+			scope.locals[param.Value] = true
+		}
+		return scope
+	}
+
+	funcLit := fn.(*ast.FunctionLiteral)
+	nonlocals := make(map[string]bool)
+
+	for _, param := range funcLit.Parameters {
+		scope.locals[param.TokenLiteral()] = true
+	}
+
+	var addTarget func(target ast.Expression)
+	addTarget = func(target ast.Expression) {
+		switch target := target.(type) {
+		case *ast.Identifier:
+			scope.locals[target.Value] = true
+		case *ast.TupleLiteral:
+			for _, element := range target.Elements {
+				addTarget(element)
+			}
+		}
+	}
+
+	ast.Inspect(funcLit.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral:
+			// the names of a nested function belong to it
+			scope.locals[node.TokenLiteral()] = true
+			return false
+		case *ast.LambdaExpression:
+			return false
+		case *ast.AssignStatement:
+			for _, target := range node.Targets {
+				addTarget(target)
+			}
+		case *ast.AnnotatedAssignStatement:
+			addTarget(node.Name)
+		case *ast.AugmentedAssignStatement:
+			addTarget(node.Target)
+		case *ast.ExceptClause:
+			if node.Name != nil {
+				addTarget(node.Name)
+			}
+		case *ast.GlobalStatement:
+			for _, name := range node.Names {
+				scope.globals[name.Value] = true
+			}
+		case *ast.NonlocalStatement:
+			for _, name := range node.Names {
+				nonlocals[name.Value] = true
+			}
+		}
+		return true
+	})
+
+	for name := range scope.globals {
+		delete(scope.locals, name)
+	}
+	for name := range nonlocals {
+		delete(scope.locals, name)
+	}
+
+	// A nested function binds its own name to itself instead of capturing
+	// the variable of the enclosing function holding it, which would make
+	// the env of the function reference itself
+	name := funcLit.TokenLiteral()
+	if nested && !scope.locals[name] && !scope.globals[name] && !nonlocals[name] && usesName(funcLit.Body, name) {
+		scope.locals[name] = true
+		scope.self = true
+	}
+
+	return scope
+}
+
+func usesName(node ast.Node, name string) bool {
+	used := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok && ident.Value == name {
+			used = true
+		}
+		return !used
+	})
+
+	return used
+}
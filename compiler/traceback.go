@@ -0,0 +1,237 @@
+package compiler
+
+import (
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Runtime errors are reported with a Python style traceback. Every function
+// pushes an entry on a shadow call stack when it is called and pops it when
+// it returns:
+//
+//	{ i8* file, i8* func, i64 row, i64 col, entry* prev }
+//
+// The row and col of an entry are updated before every call and runtime
+// check, so when an exception is raised the stack holds the line each
+// function is at, from main to the function that raised it
+const (
+	entryFile = iota
+	entryFunc
+	entryRow
+	entryCol
+	entryPrev
+)
+
+func (comp *compiler) stackEntryType() *types.StructType {
+	if comp.stackEntry == nil {
+		comp.stackEntry = types.NewStruct()
+		comp.module.NewTypeDef("spython.stack_entry", comp.stackEntry)
+		comp.stackEntry.Fields = []types.Type{I8Ptr, I8Ptr, Int, Int, types.NewPointer(comp.stackEntry)}
+	}
+
+	return comp.stackEntry
+}
+
+// The innermost entry of the shadow call stack
+func (comp *compiler) callStack() *ir.Global {
+	if comp.stack == nil {
+		entryPtr := types.NewPointer(comp.stackEntryType())
+		comp.stack = comp.runtimeGlobal("spython_call_stack", constant.NewNull(entryPtr))
+	}
+
+	return comp.stack
+}
+
+func (comp *compiler) stackField(b *ir.Block, entry value.Value, field int64) value.Value {
+	return b.NewGetElementPtr(comp.stackEntryType(), entry, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// The source file name shown in tracebacks
+func (comp *compiler) fileName() string {
+	if comp.ns != nil {
+		return comp.ns.file
+	}
+
+	if comp.options.File == "" {
+		return "<string>"
+	}
+
+	return comp.options.File
+}
+
+// Push the entry of the function being compiled on the shadow call stack,
+// releaseFrame pops it before the function returns
+func (c *context) enterFunction(name string) {
+	entryTyp := c.compiler.stackEntryType()
+	entry := c.newLocal("stack_entry", entryTyp)
+
+	c.NewStore(c.compiler.cString(c.compiler.fileName()), c.compiler.stackField(c.Block, entry, entryFile))
+	c.NewStore(c.compiler.cString(name), c.compiler.stackField(c.Block, entry, entryFunc))
+	c.NewStore(constant.NewInt(Int, 0), c.compiler.stackField(c.Block, entry, entryRow))
+	c.NewStore(constant.NewInt(Int, 0), c.compiler.stackField(c.Block, entry, entryCol))
+	c.NewStore(c.NewLoad(types.NewPointer(entryTyp), c.compiler.callStack()), c.compiler.stackField(c.Block, entry, entryPrev))
+	c.NewStore(entry, c.compiler.callStack())
+
+	c.frame.stackEntry = entry
+}
+
+// Record the source location the function is at, before a call or a
+// runtime check that can fail
+func (c *context) setLocation(tok token.Token) {
+	if c.frame.stackEntry == nil {
+		return
+	}
+
+	c.NewStore(constant.NewInt(Int, int64(tok.Row+1)), c.compiler.stackField(c.Block, c.frame.stackEntry, entryRow))
+	c.NewStore(constant.NewInt(Int, int64(tok.Col+1)), c.compiler.stackField(c.Block, c.frame.stackEntry, entryCol))
+}
+
+// The stack of the last exception that was raised, copied when it was raised
+func (comp *compiler) tracebackGlobal() *ir.Global {
+	if comp.traceback == nil {
+		entryPtr := types.NewPointer(comp.stackEntryType())
+		comp.traceback = comp.runtimeGlobal("spython_traceback", constant.NewNull(entryPtr))
+	}
+
+	return comp.traceback
+}
+
+// void spython_raise(Exception* exc, i8* file, i64 row, i64 col) makes exc
+// the pending exception, it takes the reference of the caller, and copies the
+// shadow call stack so the traceback can be shown after it was unwound
+func (comp *compiler) raiseFunc() *ir.Func {
+	return comp.runtimeFunc("spython_raise", func(name string) *ir.Func {
+		excTyp := comp.exceptionClass().ptrType()
+		exc := ir.NewParam("exc", excTyp)
+		file := ir.NewParam("file", I8Ptr)
+		row := ir.NewParam("row", Int)
+		col := ir.NewParam("col", Int)
+		fn := comp.module.NewFunc(name, types.Void, exc, file, row, col)
+		entryTyp := comp.stackEntryType()
+		entryPtr := types.NewPointer(entryTyp)
+		null := constant.NewNull(entryPtr)
+
+		entry := fn.NewBlock("entry")
+		freeLoop := fn.NewBlock("free_loop")
+		freeEntry := fn.NewBlock("free_entry")
+		inFunction := fn.NewBlock("in_function")
+		freed := fn.NewBlock("freed")
+		copyLoop := fn.NewBlock("copy_loop")
+		copyEntry := fn.NewBlock("copy_entry")
+		done := fn.NewBlock("done")
+
+		entry.NewStore(exc, comp.currentException())
+		old := entry.NewLoad(entryPtr, comp.tracebackGlobal())
+		top := entry.NewLoad(entryPtr, comp.callStack())
+		entry.NewBr(freeLoop)
+
+		// Free the copy of the stack of the previous exception
+		current := freeLoop.NewPhi(ir.NewIncoming(old, entry))
+		freeLoop.NewCondBr(freeLoop.NewICmp(enum.IPredEQ, current, null), freed, freeEntry)
+
+		next := freeEntry.NewLoad(entryPtr, comp.stackField(freeEntry, current, entryPrev))
+		freeEntry.NewCall(comp.libcFunc("free"), freeEntry.NewBitCast(current, I8Ptr))
+		current.Incs = append(current.Incs, ir.NewIncoming(next, freeEntry))
+		freeEntry.NewBr(freeLoop)
+
+		// The innermost function is at the location that raised
+		freed.NewCondBr(freed.NewICmp(enum.IPredEQ, top, null), done, inFunction)
+		inFunction.NewStore(row, comp.stackField(inFunction, top, entryRow))
+		inFunction.NewStore(col, comp.stackField(inFunction, top, entryCol))
+		inFunction.NewBr(copyLoop)
+
+		// Copy the entries linking every copy from the prev field of the copy before it
+		src := copyLoop.NewPhi(ir.NewIncoming(top, inFunction))
+		link := copyLoop.NewPhi(ir.NewIncoming(comp.tracebackGlobal(), inFunction))
+		copyLoop.NewCondBr(copyLoop.NewICmp(enum.IPredEQ, src, null), done, copyEntry)
+
+		raw := copyEntry.NewCall(comp.libcFunc("malloc"), sizeOf(entryTyp))
+		dst := copyEntry.NewBitCast(raw, entryPtr)
+		copyEntry.NewStore(copyEntry.NewLoad(entryTyp, src), dst)
+		copyEntry.NewStore(dst, link)
+		src.Incs = append(src.Incs, ir.NewIncoming(copyEntry.NewLoad(entryPtr, comp.stackField(copyEntry, src, entryPrev)), copyEntry))
+		link.Incs = append(link.Incs, ir.NewIncoming(comp.stackField(copyEntry, dst, entryPrev), copyEntry))
+		copyEntry.NewBr(copyLoop)
+
+		last := done.NewPhi(ir.NewIncoming(comp.tracebackGlobal(), freed), ir.NewIncoming(link, copyLoop))
+		done.NewStore(null, last)
+		done.NewRet(nil)
+
+		return fn
+	})
+}
+
+// void spython_panic(Exception* exc) writes the traceback of an exception
+// that was not handled and the exception to stderr and exits with status 1
+func (comp *compiler) panicFunc() *ir.Func {
+	return comp.runtimeFunc("spython_panic", func(name string) *ir.Func {
+		cls := comp.exceptionClass()
+		exc := ir.NewParam("exc", cls.ptrType())
+		fn := comp.module.NewFunc(name, types.Void, exc)
+		entryPtr := types.NewPointer(comp.stackEntryType())
+		stderrFd := constant.NewInt(types.I32, stderr)
+		dprintf := comp.libcFunc("dprintf")
+
+		entry := fn.NewBlock("entry")
+		withMessage := fn.NewBlock("with_message")
+		withoutMessage := fn.NewBlock("without_message")
+		exit := fn.NewBlock("exit")
+
+		entry.NewCall(dprintf, stderrFd, comp.cString("Traceback (most recent call last):\n"))
+		entry.NewCall(comp.printStackFunc(), entry.NewLoad(entryPtr, comp.tracebackGlobal()))
+
+		// The type of an exception is the class name in its header
+		typeName := entry.NewLoad(I8Ptr, comp.headerField(entry, comp.headerOf(entry, exc), headerName))
+		index, _, _ := cls.field("message")
+		msg := entry.NewLoad(Str, objectField(entry, exc, index))
+		empty := entry.NewICmp(enum.IPredEQ, entry.NewLoad(types.I8, msg), constant.NewInt(types.I8, 0))
+		entry.NewCondBr(empty, withoutMessage, withMessage)
+
+		withMessage.NewCall(dprintf, stderrFd, comp.cString("%s: %s\n"), typeName, msg)
+		withMessage.NewBr(exit)
+
+		withoutMessage.NewCall(dprintf, stderrFd, comp.cString("%s\n"), typeName)
+		withoutMessage.NewBr(exit)
+
+		exit.NewCall(comp.libcFunc("exit"), constant.NewInt(types.I32, 1))
+		exit.NewUnreachable()
+
+		return fn
+	})
+}
+
+// void spython_print_stack(entry* e) writes the entries of a shadow call
+// stack from e, the outermost first
+func (comp *compiler) printStackFunc() *ir.Func {
+	return comp.runtimeFunc("spython_print_stack", func(name string) *ir.Func {
+		entryPtr := types.NewPointer(comp.stackEntryType())
+		e := ir.NewParam("e", entryPtr)
+		fn := comp.module.NewFunc(name, types.Void, e)
+
+		entry := fn.NewBlock("entry")
+		outer := fn.NewBlock("outer")
+		print := fn.NewBlock("print")
+		done := fn.NewBlock("done")
+
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, e, constant.NewNull(entryPtr)), done, outer)
+
+		outer.NewCall(fn, outer.NewLoad(entryPtr, comp.stackField(outer, e, entryPrev)))
+		outer.NewBr(print)
+
+		file := print.NewLoad(I8Ptr, comp.stackField(print, e, entryFile))
+		funcName := print.NewLoad(I8Ptr, comp.stackField(print, e, entryFunc))
+		row := print.NewLoad(Int, comp.stackField(print, e, entryRow))
+		col := print.NewLoad(Int, comp.stackField(print, e, entryCol))
+		format := comp.cString("  File \"%s\", line %ld, col %ld, in %s\n")
+		print.NewCall(comp.libcFunc("dprintf"), constant.NewInt(types.I32, stderr), format, file, row, col, funcName)
+		print.NewBr(done)
+
+		done.NewRet(nil)
+
+		return fn
+	})
+}
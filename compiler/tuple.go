@@ -0,0 +1,35 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir/types"
+)
+
+// Tuples are LLVM literal struct values, e.g. tuple[int, float] is { i64, float },
+// so they are passed and returned by value without any heap allocation
+func tupleOf(elems ...types.Type) *types.StructType {
+	return types.NewStruct(elems...)
+}
+
+// Get the element types of a tuple type
+func tupleElems(typ types.Type) ([]types.Type, bool) {
+	st, ok := typ.(*types.StructType)
+	if !ok || st.Name() != "" {
+		return nil, false
+	}
+
+	return st.Fields, true
+}
+
+// A name for a tuple type that can be part of a symbol name, the arity
+// keeps nested tuples apart e.g. tuple2_tuple2_i64_i64_float
+func tupleTag(elems []types.Type) string {
+	tags := []string{fmt.Sprintf("tuple%d", len(elems))}
+	for _, elem := range elems {
+		tags = append(tags, typeTag(elem))
+	}
+
+	return strings.Join(tags, "_")
+}
@@ -0,0 +1,46 @@
+package compiler
+
+import (
+	"github.com/hvuhsg/spython/ast"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func (c *context) compileTupleLiteral(tupleLit *ast.TupleLiteral) error {
+	return c.compileTuple(tupleLit, nil)
+}
+
+// Compile a tuple literal into a struct value, the element types are taken
+// from the elements unless they are known from an annotation
+func (c *context) compileTuple(tupleLit *ast.TupleLiteral, elemTyps []types.Type) error {
+	elems := make([]value.Value, 0, len(tupleLit.Elements))
+	typs := make([]types.Type, 0, len(tupleLit.Elements))
+
+	for i, element := range tupleLit.Elements {
+		var elemTyp types.Type
+		if elemTyps != nil {
+			elemTyp = elemTyps[i]
+		}
+
+		elem, err := c.compileElement(element, elemTyp, tupleLit.Token)
+		if err != nil {
+			return err
+		}
+
+		if elem.Type().Equal(None) {
+			return newError("tuple elements can not be None", TypeError, tupleLit.Token)
+		}
+
+		elems = append(elems, c.hold(elem, tupleLit.Elements[i+1:]...))
+		typs = append(typs, elem.Type())
+	}
+
+	var tuple value.Value = constant.NewUndef(tupleOf(typs...))
+	for i, elem := range elems {
+		tuple = c.NewInsertValue(tuple, elem, uint64(i))
+	}
+
+	c.pushReg(tuple)
+	return nil
+}
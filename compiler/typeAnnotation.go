@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/hvuhsg/spython/ast"
+	"github.com/hvuhsg/spython/token"
+	"github.com/llir/llvm/ir/types"
+)
+
+// Resolve a type annotation expression, e.g. int, into its LLVM type
+func (c *context) resolveType(annotation ast.Expression, tok token.Token) (types.Type, error) {
+	switch annotation := annotation.(type) {
+	case *ast.Identifier:
+		if cls, ok := c.compiler.lookupClass(annotation.Value); ok {
+			return cls.ptrType(), nil
+		}
+
+		typ, ok := nameToType[annotation.Value]
+		if !ok {
+			return nil, newError(fmt.Sprintf("'%s' is not a valid type", annotation.Value), NameError, annotation.Token)
+		}
+		if typ == Ptr {
+			c.compiler.declarePtr()
+		}
+		return typ, nil
+	case *ast.AttributeExpression:
+		// a class of an imported module, e.g. shapes.Circle
+		if cls, ok := c.classNamed(annotation); ok {
+			return cls.ptrType(), nil
+		}
+		return nil, newError(fmt.Sprintf("'%s' is not a valid type", annotation.String()), NameError, annotation.Token)
+	case *ast.IndexExpression:
+		return c.resolveGenericType(annotation, tok)
+	case *ast.InfixExpression:
+		// T | None
+		if annotation.Operator == token.BitOr && isNoneLiteral(annotation.Right) {
+			return c.resolveOptionalType(annotation.Left, tok)
+		} else if annotation.Operator == token.BitOr && isNoneLiteral(annotation.Left) {
+			return c.resolveOptionalType(annotation.Right, tok)
+		}
+		return nil, newError(fmt.Sprintf("'%s' is not a valid type", annotation.String()), TypeError, tok)
+	default:
+		return nil, newError(fmt.Sprintf("'%s' is not a valid type", annotation.String()), TypeError, tok)
+	}
+}
+
+// Resolve a parameterized type annotation, e.g. list[int]
+func (c *context) resolveGenericType(annotation *ast.IndexExpression, tok token.Token) (types.Type, error) {
+	name, ok := annotation.Left.(*ast.Identifier)
+	if !ok {
+		return nil, newError(fmt.Sprintf("'%s' is not a valid type", annotation.String()), TypeError, tok)
+	}
+
+	switch name.Value {
+	case "Optional":
+		return c.resolveOptionalType(annotation.Index, tok)
+	case "list":
+		elem, err := c.resolveType(annotation.Index, tok)
+		if err != nil {
+			return nil, err
+		}
+		if elem.Equal(None) {
+			return nil, newError("list elements can not be None", TypeError, tok)
+		}
+		return c.compiler.listOf(elem), nil
+	case "dict":
+		params, ok := annotation.Index.(*ast.TupleLiteral)
+		if !ok || len(params.Elements) != 2 {
+			return nil, newError("dict type takes a key and a value type, e.g. dict[str, int]", TypeError, tok)
+		}
+		key, err := c.resolveType(params.Elements[0], tok)
+		if err != nil {
+			return nil, err
+		}
+		val, err := c.resolveType(params.Elements[1], tok)
+		if err != nil {
+			return nil, err
+		}
+		if !isHashable(key) {
+			return nil, newError(fmt.Sprintf("dict keys must be int, float or str, not %s", c.compiler.displayType(key)), TypeError, tok)
+		}
+		if val.Equal(None) {
+			return nil, newError("dict values can not be None", TypeError, tok)
+		}
+		return c.compiler.dictOf(key, val), nil
+	case "tuple":
+		params := []ast.Expression{annotation.Index}
+		if tuple, ok := annotation.Index.(*ast.TupleLiteral); ok {
+			params = tuple.Elements
+		}
+
+		elems := make([]types.Type, 0, len(params))
+		for _, param := range params {
+			elem, err := c.resolveType(param, tok)
+			if err != nil {
+				return nil, err
+			}
+			if elem.Equal(None) {
+				return nil, newError("tuple elements can not be None", TypeError, tok)
+			}
+			elems = append(elems, elem)
+		}
+		return tupleOf(elems...), nil
+	case "Callable":
+		sig, ok := annotation.Index.(*ast.TupleLiteral)
+		if !ok || len(sig.Elements) != 2 {
+			return nil, newError("Callable type takes a list of parameter types and a return type, e.g. Callable[[int], int]", TypeError, tok)
+		}
+		paramList, ok := sig.Elements[0].(*ast.ArrayLiteral)
+		if !ok {
+			return nil, newError("Callable type takes a list of parameter types and a return type, e.g. Callable[[int], int]", TypeError, tok)
+		}
+
+		params := make([]types.Type, 0, len(paramList.Elements))
+		for _, param := range paramList.Elements {
+			paramTyp, err := c.resolveType(param, tok)
+			if err != nil {
+				return nil, err
+			}
+			if paramTyp.Equal(None) {
+				return nil, newError("Callable parameters can not be None", TypeError, tok)
+			}
+			params = append(params, paramTyp)
+		}
+
+		ret, err := c.resolveType(sig.Elements[1], tok)
+		if err != nil {
+			return nil, err
+		}
+		return c.compiler.callableOf(params, ret), nil
+	default:
+		return nil, newError(fmt.Sprintf("'%s' is not a generic type", name.Value), TypeError, name.Token)
+	}
+}
@@ -9,23 +9,33 @@ func (c *context) compileWhileExpression(whileExp *ast.WhileExpression) error {
 
 	// Create while block
 	condition := c.newContext("while.condition")
+	conditionEntry := condition.Block
+	condition.widenAssigned(whileExp.Consequence)
 	if err := condition.compile(whileExp.Condition); err != nil {
 		return err
 	}
 	cond := condition.popReg()
+	condition.releaseTemps()
 
 	// Create loop block
 	loop := c.newContext("while.loop")
+	loopEntry := loop.Block
+	loop.widenAssigned(whileExp.Consequence)
+	if name, notNone, ok := c.noneCheck(whileExp.Condition); ok && notNone {
+		loop.narrow(name, true)
+	}
 	if err := loop.compile(whileExp.Consequence); err != nil {
 		return err
 	}
-	loop.NewBr(condition.Block)
+	if loop.Term == nil {
+		loop.NewBr(conditionEntry)
+	}
 
 	// Create loop condition
-	condition.NewCondBr(cond, loop.Block, endwhile.Block)
+	condition.NewCondBr(cond, loopEntry, endwhile.Block)
 
 	// Jump to while
-	c.NewBr(condition.Block)
+	c.NewBr(conditionEntry)
 
 	// Continue with endif block
 	c.Block = endwhile.Block
@@ -19,24 +19,57 @@ type Lexer struct {
 func New(data string) Lexer {
 	lexer := Lexer{data: data}
 
-	lexer.registerSimpleMatcher("None", token.None)
-	lexer.registerSimpleMatcher("if", token.If)
-	lexer.registerSimpleMatcher("else", token.Else)
-	lexer.registerSimpleMatcher("def", token.Function)
-	lexer.registerSimpleMatcher("return", token.Return)
-	lexer.registerSimpleMatcher("while", token.While)
+	lexer.registerKeywordMatcher("None", token.None)
+	lexer.registerKeywordMatcher("if", token.If)
+	lexer.registerKeywordMatcher("else", token.Else)
+	lexer.registerKeywordMatcher("def", token.Function)
+	lexer.registerKeywordMatcher("return", token.Return)
+	lexer.registerKeywordMatcher("while", token.While)
+	lexer.registerKeywordMatcher("global", token.Global)
+	lexer.registerKeywordMatcher("nonlocal", token.Nonlocal)
+	lexer.registerKeywordMatcher("lambda", token.Lambda)
+	lexer.registerKeywordMatcher("in", token.In)
+	lexer.registerKeywordMatcher("class", token.Class)
+	lexer.registerKeywordMatcher("try", token.Try)
+	lexer.registerKeywordMatcher("except", token.Except)
+	lexer.registerKeywordMatcher("finally", token.Finally)
+	lexer.registerKeywordMatcher("raise", token.Raise)
+	lexer.registerKeywordMatcher("as", token.As)
+	lexer.registerKeywordMatcher("assert", token.Assert)
+	lexer.registerKeywordMatcher("pass", token.Pass)
+	lexer.registerKeywordMatcher("is", token.Is)
+	lexer.registerKeywordMatcher("not", token.Not)
+	lexer.registerKeywordMatcher("import", token.Import)
+	lexer.registerKeywordMatcher("from", token.From)
+	lexer.registerKeywordMatcher("extern", token.Extern)
+	lexer.registerRegexMatcher(`"([^"\\\n]|\\.)*"`, token.String)
+	lexer.registerRegexMatcher(`'([^'\\\n]|\\.)*'`, token.String)
 	lexer.registerRegexMatcher(`[0-9]*\.[0-9]+`, token.Float)
 	lexer.registerRegexMatcher(`\d*`, token.Int)
 	lexer.registerSimpleMatcher("\n", token.ENDL)
 
 	// logic gates
-	lexer.registerSimpleMatcher("or", token.Or)
-	lexer.registerSimpleMatcher("and", token.And)
+	lexer.registerKeywordMatcher("or", token.Or)
+	lexer.registerKeywordMatcher("and", token.And)
+
+	// augmented assignment, must be matched before the operators they start with
+	lexer.registerSimpleMatcher("<<=", token.LeftShiftAssign)
+	lexer.registerSimpleMatcher(">>=", token.RightShiftAssign)
+	lexer.registerSimpleMatcher("+=", token.PlusAssign)
+	lexer.registerSimpleMatcher("-=", token.MinusAssign)
+	lexer.registerSimpleMatcher("*=", token.AsteriskAssign)
+	lexer.registerSimpleMatcher("/=", token.SlashAssign)
+	lexer.registerSimpleMatcher("%=", token.ModAssign)
+	lexer.registerSimpleMatcher("&=", token.BitAndAssign)
+	lexer.registerSimpleMatcher("|=", token.BitOrAssign)
+	lexer.registerSimpleMatcher("^=", token.BitXorAssign)
 
 	lexer.registerSimpleMatcher("%", token.Mod)
 	lexer.registerSimpleMatcher("->", token.Arrow)
 	lexer.registerSimpleMatcher("==", token.Equal)
 	lexer.registerSimpleMatcher("!=", token.NotEqual)
+	lexer.registerSimpleMatcher("<<", token.LeftShift)
+	lexer.registerSimpleMatcher(">>", token.RightShift)
 	lexer.registerSimpleMatcher(">=", token.GreaterThenEqual)
 	lexer.registerSimpleMatcher("<=", token.LessThenEqual)
 	lexer.registerSimpleMatcher("<", token.LessThan)
@@ -45,13 +78,24 @@ func New(data string) Lexer {
 	lexer.registerSimpleMatcher("-", token.Minus)
 	lexer.registerSimpleMatcher("*", token.Asterisk)
 	lexer.registerSimpleMatcher("/", token.Slash)
+	lexer.registerSimpleMatcher("&", token.BitAnd)
+	lexer.registerSimpleMatcher("|", token.BitOr)
+	lexer.registerSimpleMatcher("^", token.BitXor)
+	lexer.registerSimpleMatcher("~", token.Tilde)
+	lexer.registerSimpleMatcher("@", token.At)
 	lexer.registerSimpleMatcher(`=`, token.Assign)
 	lexer.registerSimpleMatcher(":", token.Colon)
+	lexer.registerSimpleMatcher("...", token.Ellipsis)
+	lexer.registerSimpleMatcher(".", token.Dot)
 	lexer.registerSimpleMatcher(",", token.Comma)
 	lexer.registerSimpleMatcher("(", token.LeftParen)
 	lexer.registerSimpleMatcher(")", token.RightParen)
+	lexer.registerSimpleMatcher("[", token.LeftBracket)
+	lexer.registerSimpleMatcher("]", token.RightBracket)
+	lexer.registerSimpleMatcher("{", token.LeftBrace)
+	lexer.registerSimpleMatcher("}", token.RightBrace)
 
-	lexer.registerRegexMatcher("[a-zA-Z]([a-zA-Z0-9]*)", token.Identifier)
+	lexer.registerRegexMatcher("[a-zA-Z_]([a-zA-Z0-9_]*)", token.Identifier)
 
 	return lexer
 }
@@ -145,3 +189,17 @@ func (l *Lexer) registerSimpleMatcher(word string, tokenTyp token.TokenType) {
 
 	l.matchers = append(l.matchers, matcher)
 }
+
+// Match a keyword only as a whole word, so "define" is not lexed as "def" + "ine"
+func (l *Lexer) registerKeywordMatcher(word string, tokenTyp token.TokenType) {
+	re := regexp.MustCompile(`^` + word + `\b`)
+	matcher := func(data string, l *Lexer) token.Token {
+		if !re.MatchString(data) {
+			return l.newToken(token.Illegal, "")
+		}
+
+		return l.newToken(tokenTyp, word)
+	}
+
+	l.matchers = append(l.matchers, matcher)
+}
@@ -115,3 +115,178 @@ func TestIfElse(t *testing.T) {
 		}
 	}
 }
+
+func TestBitwiseOperators(t *testing.T) {
+	lexer := New("a & b | c ^ ~d << 2 >> 1")
+
+	expectedTokens := []token.Token{
+		{Type: token.Identifier, Literal: "a"},
+		{Type: token.BitAnd, Literal: "&"},
+		{Type: token.Identifier, Literal: "b"},
+		{Type: token.BitOr, Literal: "|"},
+		{Type: token.Identifier, Literal: "c"},
+		{Type: token.BitXor, Literal: "^"},
+		{Type: token.Tilde, Literal: "~"},
+		{Type: token.Identifier, Literal: "d"},
+		{Type: token.LeftShift, Literal: "<<"},
+		{Type: token.Int, Literal: "2"},
+		{Type: token.RightShift, Literal: ">>"},
+		{Type: token.Int, Literal: "1"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	for index, et := range expectedTokens {
+		token := lexer.NextToken()
+
+		if token.Type != et.Type {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token type %s got %s", et.Type, token.Type)
+		}
+
+		if token.Literal != et.Literal {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token value '%s' got '%s'", et.Literal, token.Literal)
+		}
+	}
+}
+
+func TestAugmentedAssignment(t *testing.T) {
+	lexer := New("a += 1 -= *= /= %= &= |= ^= <<= >>=")
+
+	expectedTokens := []token.Token{
+		{Type: token.Identifier, Literal: "a"},
+		{Type: token.PlusAssign, Literal: "+="},
+		{Type: token.Int, Literal: "1"},
+		{Type: token.MinusAssign, Literal: "-="},
+		{Type: token.AsteriskAssign, Literal: "*="},
+		{Type: token.SlashAssign, Literal: "/="},
+		{Type: token.ModAssign, Literal: "%="},
+		{Type: token.BitAndAssign, Literal: "&="},
+		{Type: token.BitOrAssign, Literal: "|="},
+		{Type: token.BitXorAssign, Literal: "^="},
+		{Type: token.LeftShiftAssign, Literal: "<<="},
+		{Type: token.RightShiftAssign, Literal: ">>="},
+	}
+
+	for index, et := range expectedTokens {
+		token := lexer.NextToken()
+
+		if token.Type != et.Type {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token type %s got %s", et.Type, token.Type)
+		}
+
+		if token.Literal != et.Literal {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token value '%s' got '%s'", et.Literal, token.Literal)
+		}
+	}
+}
+
+func TestKeywordPrefixIdentifier(t *testing.T) {
+	lexer := New("define iffy order global nonlocal lambda assert assertion try except raise as finally pass is not island import from fromage extern external ... .")
+
+	expectedTokens := []token.Token{
+		{Type: token.Identifier, Literal: "define"},
+		{Type: token.Identifier, Literal: "iffy"},
+		{Type: token.Identifier, Literal: "order"},
+		{Type: token.Global, Literal: "global"},
+		{Type: token.Nonlocal, Literal: "nonlocal"},
+		{Type: token.Lambda, Literal: "lambda"},
+		{Type: token.Assert, Literal: "assert"},
+		{Type: token.Identifier, Literal: "assertion"},
+		{Type: token.Try, Literal: "try"},
+		{Type: token.Except, Literal: "except"},
+		{Type: token.Raise, Literal: "raise"},
+		{Type: token.As, Literal: "as"},
+		{Type: token.Finally, Literal: "finally"},
+		{Type: token.Pass, Literal: "pass"},
+		{Type: token.Is, Literal: "is"},
+		{Type: token.Not, Literal: "not"},
+		{Type: token.Identifier, Literal: "island"},
+		{Type: token.Import, Literal: "import"},
+		{Type: token.From, Literal: "from"},
+		{Type: token.Identifier, Literal: "fromage"},
+		{Type: token.Extern, Literal: "extern"},
+		{Type: token.Identifier, Literal: "external"},
+		{Type: token.Ellipsis, Literal: "..."},
+		{Type: token.Dot, Literal: "."},
+	}
+
+	for index, et := range expectedTokens {
+		token := lexer.NextToken()
+
+		if token.Type != et.Type {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token type %s got %s", et.Type, token.Type)
+		}
+
+		if token.Literal != et.Literal {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token value '%s' got '%s'", et.Literal, token.Literal)
+		}
+	}
+}
+
+func TestDictLiteral(t *testing.T) {
+	lexer := New("d = {\"a\": 1, 'b c': 2}")
+
+	expectedTokens := []token.Token{
+		{Type: token.Identifier, Literal: "d"},
+		{Type: token.Assign, Literal: "="},
+		{Type: token.LeftBrace, Literal: "{"},
+		{Type: token.String, Literal: "\"a\""},
+		{Type: token.Colon, Literal: ":"},
+		{Type: token.Int, Literal: "1"},
+		{Type: token.Comma, Literal: ","},
+		{Type: token.String, Literal: "'b c'"},
+		{Type: token.Colon, Literal: ":"},
+		{Type: token.Int, Literal: "2"},
+		{Type: token.RightBrace, Literal: "}"},
+	}
+
+	for index, et := range expectedTokens {
+		token := lexer.NextToken()
+
+		if token.Type != et.Type {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token type %s got %s", et.Type, token.Type)
+		}
+
+		if token.Literal != et.Literal {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token value '%s' got '%s'", et.Literal, token.Literal)
+		}
+	}
+}
+
+func TestClass(t *testing.T) {
+	lexer := New("class Point:\n\tdef __init__(self):")
+
+	expectedTokens := []token.Token{
+		{Type: token.Class, Literal: "class"},
+		{Type: token.Identifier, Literal: "Point"},
+		{Type: token.Colon, Literal: ":"},
+		{Type: token.ENDL, Literal: "\n"},
+		{Type: token.Function, Literal: "def"},
+		{Type: token.Identifier, Literal: "__init__"},
+		{Type: token.LeftParen, Literal: "("},
+		{Type: token.Identifier, Literal: "self"},
+		{Type: token.RightParen, Literal: ")"},
+		{Type: token.Colon, Literal: ":"},
+	}
+
+	for index, et := range expectedTokens {
+		token := lexer.NextToken()
+
+		if token.Type != et.Type {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token type %s got %s", et.Type, token.Type)
+		}
+
+		if token.Literal != et.Literal {
+			t.Errorf("At index: %d", index)
+			t.Fatalf("Expected token value '%s' got '%s'", et.Literal, token.Literal)
+		}
+	}
+}
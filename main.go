@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/hvuhsg/spython/compiler"
 	"github.com/hvuhsg/spython/lexer"
@@ -10,30 +13,51 @@ import (
 )
 
 func main() {
+	library := flag.Bool("library", false, "compile the file as a library module, without main, and write its interface next to it")
+	optLevel := flag.Int("O", 0, "optimisation level, 0 to 3, the IR is cleaned from 1 and optimised with LLVM opt from 2")
+	passes := flag.String("passes", "", "an opt pass pipeline to run instead of the default one of the optimisation level")
+	debugLeaks := flag.Bool("debug-leaks", false, "report the heap objects that are still alive when the program exits")
+	noAsserts := flag.Bool("no-asserts", false, "compile assert statements to nothing")
+	emit := flag.String("emit", "", "write only this output to stdout instead of the compilation steps and files, ir for the LLVM IR")
+	flag.Parse()
+
+	if *emit != "" && *emit != "ir" {
+		fmt.Printf("Unknown output %s to emit, only ir is supported.\n", *emit)
+		os.Exit(2)
+	}
+	// The compilation steps are printed unless a single output is emitted
+	verbose := *emit == ""
+
 	// Read SPython code
-	// if len(os.Args) < 2 {
-	// 	fmt.Println("Please specify a file path as an argument.")
-	// 	return
-	// }
+	if flag.NArg() < 1 {
+		fmt.Println("Please specify a file path as an argument.")
+		return
+	}
 
-	// path := os.Args[1]
+	path := flag.Arg(0)
 
-	// data, errr := os.ReadFile(path)
-	// if errr != nil {
-	// 	fmt.Println("Error reading file:", errr)
-	// 	return
-	// }
+	data, errr := os.ReadFile(path)
+	if errr != nil {
+		fmt.Println("Error reading file:", errr)
+		return
+	}
 
-	// code := string(data)
-	code := "def b():\n\tif 1 > 2:\n\t\ta = 1\nelse:\n\t\ta = 2\n\n\treturn 2"
+	code := string(data)
 
-	fmt.Println("Code:")
-	fmt.Println(code)
-	fmt.Println()
+	if verbose {
+		fmt.Println("Code:")
+		fmt.Println(code)
+		fmt.Println()
+	}
 
 	lexer := lexer.New(code)
 	parser := parser.New(&lexer)
-	compiler := compiler.New()
+	// Imported modules are resolved relative to the source file
+	options := compiler.Options{File: path, OptLevel: *optLevel, Passes: *passes, DebugLeaks: *debugLeaks, NoAsserts: *noAsserts}
+	if *library {
+		options.Library = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	compiler := compiler.NewWithOptions(options)
 
 	ast := parser.ParseProgram()
 
@@ -42,9 +66,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Println("Parser:")
-	fmt.Println(ast.String())
-	fmt.Println()
+	if verbose {
+		fmt.Println("Parser:")
+		fmt.Println(ast.String())
+		fmt.Println()
+	}
 
 	err := compiler.Compile(ast)
 	if err != nil {
@@ -52,9 +78,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	llvmCode := compiler.IR()
+	llvmCode, err := compiler.OptimizedIR()
+	if err != nil {
+		fmt.Printf("Optimiser error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if *emit == "ir" {
+		fmt.Print(llvmCode)
+		return
+	}
 	fmt.Println("LLVM IR:")
 	fmt.Println(llvmCode)
 
+	if *library {
+		// Modules importing the library are compiled against its interface
+		spi := filepath.Join(filepath.Dir(path), options.Library+".spi")
+		os.WriteFile(spi, []byte(compiler.Interface()), 0644)
+		os.WriteFile("./"+options.Library+".ll", []byte(llvmCode), 0644)
+		// C code calls the functions the library exports through its header
+		os.WriteFile("./"+options.Library+".h", []byte(compiler.Header()), 0644)
+		return
+	}
+
 	os.WriteFile("./code.ll", []byte(llvmCode), 0644)
 }
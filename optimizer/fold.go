@@ -0,0 +1,352 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func foldConstants(fn *ir.Func) bool {
+	changed := false
+	folded := make(map[value.Value]value.Value)
+
+	// Use the folded value of an operand, an instruction folds to a value
+	// that may have been folded before it
+	replace := func(ops []*value.Value) {
+		for _, op := range ops {
+			// Phis of a loop no path reaches may fold to each other
+			for i := 0; i <= len(folded); i++ {
+				v, ok := folded[*op]
+				if !ok {
+					break
+				}
+				*op = v
+				changed = true
+			}
+		}
+	}
+
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Insts {
+			replace(inst.Operands())
+			if v, ok := fold(inst); ok {
+				folded[inst.(value.Value)] = v
+			}
+		}
+		replace(block.Term.Operands())
+
+		if br, ok := block.Term.(*ir.TermCondBr); ok {
+			if cond, ok := br.Cond.(*constant.Int); ok {
+				target := br.TargetFalse.(*ir.Block)
+				if cond.X.Sign() != 0 {
+					target = br.TargetTrue.(*ir.Block)
+				}
+				block.NewBr(target)
+				changed = true
+			}
+		}
+	}
+
+	// Phis and the blocks of loops use values defined after them
+	replace(operands(fn))
+
+	if changed {
+		fixPhis(fn)
+	}
+	return changed
+}
+
+// The value an instruction always computes
+func fold(inst ir.Instruction) (value.Value, bool) {
+	switch inst := inst.(type) {
+	case *ir.InstAdd:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x + y, true })
+	case *ir.InstSub:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x - y, true })
+	case *ir.InstMul:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x * y, true })
+	case *ir.InstSDiv:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) {
+			if y == 0 || (x == math.MinInt64 && y == -1) {
+				return 0, false
+			}
+			return x / y, true
+		})
+	case *ir.InstSRem:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) {
+			if y == 0 || (x == math.MinInt64 && y == -1) {
+				return 0, false
+			}
+			return x % y, true
+		})
+	case *ir.InstAnd:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x & y, true })
+	case *ir.InstOr:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x | y, true })
+	case *ir.InstXor:
+		return foldInt(inst.X, inst.Y, func(x, y int64) (int64, bool) { return x ^ y, true })
+	case *ir.InstShl:
+		return foldShift(inst.X, inst.Y, func(x *constant.Int, n uint64) int64 { return signed(x) << n })
+	case *ir.InstAShr:
+		return foldShift(inst.X, inst.Y, func(x *constant.Int, n uint64) int64 { return signed(x) >> n })
+	case *ir.InstLShr:
+		return foldShift(inst.X, inst.Y, func(x *constant.Int, n uint64) int64 { return int64(unsigned(x) >> n) })
+	case *ir.InstICmp:
+		return foldICmp(inst)
+	case *ir.InstFAdd:
+		return foldFloat(inst.X, inst.Y, func(x, y float64) float64 { return x + y })
+	case *ir.InstFSub:
+		return foldFloat(inst.X, inst.Y, func(x, y float64) float64 { return x - y })
+	case *ir.InstFMul:
+		return foldFloat(inst.X, inst.Y, func(x, y float64) float64 { return x * y })
+	case *ir.InstFDiv:
+		return foldFloat(inst.X, inst.Y, func(x, y float64) float64 { return x / y })
+	case *ir.InstFNeg:
+		return foldFloat(inst.X, inst.X, func(x, _ float64) float64 { return -x })
+	case *ir.InstFCmp:
+		return foldFCmp(inst)
+	case *ir.InstZExt:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConst(inst.To, int64(unsigned(x)))
+		}
+	case *ir.InstSExt:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConst(inst.To, signed(x))
+		}
+	case *ir.InstTrunc:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return intConst(inst.To, signed(x))
+		}
+	case *ir.InstSIToFP:
+		if x, ok := inst.From.(*constant.Int); ok {
+			return floatConst(inst.To, float64(signed(x)))
+		}
+	case *ir.InstFPExt:
+		if x, ok := floatValue(inst.From); ok {
+			return floatConst(inst.To, x)
+		}
+	case *ir.InstFPTrunc:
+		if x, ok := floatValue(inst.From); ok {
+			return floatConst(inst.To, x)
+		}
+	case *ir.InstSelect:
+		if cond, ok := inst.Cond.(*constant.Int); ok {
+			if cond.X.Sign() != 0 {
+				return inst.ValueTrue, true
+			}
+			return inst.ValueFalse, true
+		}
+	case *ir.InstPhi:
+		// A phi whose incoming values are all the same value
+		var same value.Value
+		for _, inc := range inst.Incs {
+			if inc.X == inst || inc.X == same {
+				continue
+			}
+			if same != nil {
+				return nil, false
+			}
+			same = inc.X
+		}
+		return same, same != nil
+	}
+
+	return nil, false
+}
+
+func foldInt(x, y value.Value, op func(x, y int64) (int64, bool)) (value.Value, bool) {
+	a, ok := x.(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+	b, ok := y.(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+
+	result, ok := op(signed(a), signed(b))
+	if !ok {
+		return nil, false
+	}
+	return intConst(a.Typ, result)
+}
+
+// Shifting by the width of the type or more is poison, it is not folded
+func foldShift(x, y value.Value, op func(x *constant.Int, n uint64) int64) (value.Value, bool) {
+	a, ok := x.(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+	b, ok := y.(*constant.Int)
+	if !ok || unsigned(b) >= a.Typ.BitSize {
+		return nil, false
+	}
+
+	return intConst(a.Typ, op(a, unsigned(b)))
+}
+
+func foldICmp(inst *ir.InstICmp) (value.Value, bool) {
+	a, ok := inst.X.(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+	b, ok := inst.Y.(*constant.Int)
+	if !ok {
+		return nil, false
+	}
+
+	x, y := signed(a), signed(b)
+	ux, uy := unsigned(a), unsigned(b)
+	var result bool
+	switch inst.Pred {
+	case enum.IPredEQ:
+		result = x == y
+	case enum.IPredNE:
+		result = x != y
+	case enum.IPredSGT:
+		result = x > y
+	case enum.IPredSGE:
+		result = x >= y
+	case enum.IPredSLT:
+		result = x < y
+	case enum.IPredSLE:
+		result = x <= y
+	case enum.IPredUGT:
+		result = ux > uy
+	case enum.IPredUGE:
+		result = ux >= uy
+	case enum.IPredULT:
+		result = ux < uy
+	case enum.IPredULE:
+		result = ux <= uy
+	default:
+		return nil, false
+	}
+
+	return constant.NewBool(result), true
+}
+
+func foldFloat(x, y value.Value, op func(x, y float64) float64) (value.Value, bool) {
+	a, ok := floatValue(x)
+	if !ok {
+		return nil, false
+	}
+	b, ok := floatValue(y)
+	if !ok {
+		return nil, false
+	}
+
+	return floatConst(x.Type(), op(a, b))
+}
+
+// The constants are never NaN, so the unordered predicates compare like the
+// ordered ones
+func foldFCmp(inst *ir.InstFCmp) (value.Value, bool) {
+	x, ok := floatValue(inst.X)
+	if !ok {
+		return nil, false
+	}
+	y, ok := floatValue(inst.Y)
+	if !ok {
+		return nil, false
+	}
+
+	var result bool
+	switch inst.Pred {
+	case enum.FPredFalse:
+		result = false
+	case enum.FPredTrue:
+		result = true
+	case enum.FPredOEQ, enum.FPredUEQ:
+		result = x == y
+	case enum.FPredONE, enum.FPredUNE:
+		result = x != y
+	case enum.FPredOGT, enum.FPredUGT:
+		result = x > y
+	case enum.FPredOGE, enum.FPredUGE:
+		result = x >= y
+	case enum.FPredOLT, enum.FPredULT:
+		result = x < y
+	case enum.FPredOLE, enum.FPredULE:
+		result = x <= y
+	case enum.FPredORD:
+		result = true
+	case enum.FPredUNO:
+		result = false
+	default:
+		return nil, false
+	}
+
+	return constant.NewBool(result), true
+}
+
+// The value of an integer constant sign extended from the width of its type
+func signed(c *constant.Int) int64 {
+	x := c.X.Int64()
+	if bits := c.Typ.BitSize; bits < 64 {
+		shift := 64 - bits
+		x = x << shift >> shift
+	}
+	return x
+}
+
+// The value of an integer constant zero extended from the width of its type
+func unsigned(c *constant.Int) uint64 {
+	x := uint64(c.X.Int64())
+	if bits := c.Typ.BitSize; bits < 64 {
+		x &= 1<<bits - 1
+	}
+	return x
+}
+
+// An integer constant of the value wrapped to the width of the type
+func intConst(typ types.Type, x int64) (value.Value, bool) {
+	intTyp, ok := typ.(*types.IntType)
+	if !ok || intTyp.BitSize > 64 {
+		return nil, false
+	}
+
+	if bits := intTyp.BitSize; bits == 1 {
+		x &= 1
+	} else if bits < 64 {
+		shift := 64 - bits
+		x = x << shift >> shift
+	}
+	return constant.NewInt(intTyp, x), true
+}
+
+func floatValue(v value.Value) (float64, bool) {
+	c, ok := v.(*constant.Float)
+	if !ok || c.NaN {
+		return 0, false
+	}
+
+	x, _ := c.X.Float64()
+	return x, true
+}
+
+// A float constant of the value rounded to the precision of the type, results
+// that are not finite are not folded
+func floatConst(typ types.Type, x float64) (value.Value, bool) {
+	floatTyp, ok := typ.(*types.FloatType)
+	if !ok {
+		return nil, false
+	}
+
+	switch floatTyp.Kind {
+	case types.FloatKindFloat:
+		x = float64(float32(x))
+	case types.FloatKindDouble:
+	default:
+		return nil, false
+	}
+
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return nil, false
+	}
+	return constant.NewFloat(floatTyp, x), true
+}
@@ -0,0 +1,205 @@
+// Package optimizer runs optimisation passes over the functions of an LLVM
+// module, without the external LLVM tools
+package optimizer
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A Pass transforms a function, it reports whether it changed it
+type Pass struct {
+	Name string
+	Run  func(fn *ir.Func) bool
+}
+
+var (
+	// Replace instructions on constants with their result and branches on a
+	// constant condition with a branch to their target
+	ConstantFolding = Pass{Name: "constant-folding", Run: foldConstants}
+	// Remove the blocks no path from the entry block reaches
+	UnreachableBlocks = Pass{Name: "unreachable-blocks", Run: removeUnreachableBlocks}
+	// Remove instructions without side effects whose result is not used
+	DeadCode = Pass{Name: "dead-code", Run: removeDeadCode}
+)
+
+// The passes Optimize runs when it is given none
+func DefaultPasses() []Pass {
+	return []Pass{ConstantFolding, UnreachableBlocks, DeadCode}
+}
+
+// Optimize runs the passes over every function defined in the module until
+// none of them changes it
+func Optimize(m *ir.Module, passes ...Pass) {
+	if len(passes) == 0 {
+		passes = DefaultPasses()
+	}
+
+	for _, fn := range m.Funcs {
+		if len(fn.Blocks) == 0 {
+			continue
+		}
+
+		for changed := true; changed; {
+			changed = false
+			for _, pass := range passes {
+				if pass.Run(fn) {
+					changed = true
+				}
+			}
+		}
+
+		resetIDs(fn)
+	}
+}
+
+// Unnamed values keep the IDs they got when the function was printed, they
+// are numbered again the next time it is printed
+func resetIDs(fn *ir.Func) {
+	type unnamed interface {
+		IsUnnamed() bool
+		SetID(id int64)
+	}
+	reset := func(v interface{}) {
+		if v, ok := v.(unnamed); ok && v.IsUnnamed() {
+			v.SetID(0)
+		}
+	}
+
+	for _, param := range fn.Params {
+		reset(param)
+	}
+	for _, block := range fn.Blocks {
+		reset(block)
+		for _, inst := range block.Insts {
+			reset(inst)
+		}
+		reset(block.Term)
+	}
+}
+
+// The operands of the instructions and terminators of a function
+func operands(fn *ir.Func) []*value.Value {
+	var ops []*value.Value
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Insts {
+			ops = append(ops, inst.Operands()...)
+		}
+		ops = append(ops, block.Term.Operands()...)
+	}
+
+	return ops
+}
+
+// The blocks that branch to every block of a function
+func predecessors(fn *ir.Func) map[*ir.Block]map[*ir.Block]bool {
+	preds := make(map[*ir.Block]map[*ir.Block]bool)
+	for _, block := range fn.Blocks {
+		for _, succ := range block.Term.Succs() {
+			if preds[succ] == nil {
+				preds[succ] = make(map[*ir.Block]bool)
+			}
+			preds[succ][block] = true
+		}
+	}
+
+	return preds
+}
+
+// Drop the incoming values of phis from blocks that no longer branch to them
+func fixPhis(fn *ir.Func) {
+	preds := predecessors(fn)
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Insts {
+			phi, ok := inst.(*ir.InstPhi)
+			if !ok {
+				continue
+			}
+
+			incs := phi.Incs[:0]
+			for _, inc := range phi.Incs {
+				if pred, ok := inc.Pred.(*ir.Block); ok && preds[block][pred] {
+					incs = append(incs, inc)
+				}
+			}
+			phi.Incs = incs
+		}
+	}
+}
+
+func removeUnreachableBlocks(fn *ir.Func) bool {
+	reached := map[*ir.Block]bool{fn.Blocks[0]: true}
+	work := []*ir.Block{fn.Blocks[0]}
+	for len(work) > 0 {
+		block := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, succ := range block.Term.Succs() {
+			if !reached[succ] {
+				reached[succ] = true
+				work = append(work, succ)
+			}
+		}
+	}
+
+	if len(reached) == len(fn.Blocks) {
+		return false
+	}
+
+	blocks := fn.Blocks[:0]
+	for _, block := range fn.Blocks {
+		if reached[block] {
+			blocks = append(blocks, block)
+		}
+	}
+	fn.Blocks = blocks
+	fixPhis(fn)
+
+	return true
+}
+
+func removeDeadCode(fn *ir.Func) bool {
+	changed := false
+	for removed := true; removed; {
+		removed = false
+
+		used := make(map[value.Value]bool)
+		for _, op := range operands(fn) {
+			used[*op] = true
+		}
+
+		for _, block := range fn.Blocks {
+			insts := block.Insts[:0]
+			for _, inst := range block.Insts {
+				if v, ok := inst.(value.Value); ok && !used[v] && isPure(inst) {
+					removed = true
+					continue
+				}
+				insts = append(insts, inst)
+			}
+			block.Insts = insts
+		}
+		changed = changed || removed
+	}
+
+	return changed
+}
+
+// Whether an instruction only computes its result, so it can be removed
+// when the result is not used
+func isPure(inst ir.Instruction) bool {
+	switch inst := inst.(type) {
+	case *ir.InstAdd, *ir.InstFAdd, *ir.InstSub, *ir.InstFSub, *ir.InstMul, *ir.InstFMul,
+		*ir.InstUDiv, *ir.InstSDiv, *ir.InstFDiv, *ir.InstURem, *ir.InstSRem, *ir.InstFRem, *ir.InstFNeg,
+		*ir.InstShl, *ir.InstLShr, *ir.InstAShr, *ir.InstAnd, *ir.InstOr, *ir.InstXor,
+		*ir.InstTrunc, *ir.InstZExt, *ir.InstSExt, *ir.InstFPTrunc, *ir.InstFPExt,
+		*ir.InstFPToUI, *ir.InstFPToSI, *ir.InstUIToFP, *ir.InstSIToFP,
+		*ir.InstPtrToInt, *ir.InstIntToPtr, *ir.InstBitCast, *ir.InstAddrSpaceCast,
+		*ir.InstICmp, *ir.InstFCmp, *ir.InstPhi, *ir.InstSelect, *ir.InstGetElementPtr,
+		*ir.InstExtractValue, *ir.InstInsertValue, *ir.InstAlloca:
+		return true
+	case *ir.InstLoad:
+		return !inst.Volatile
+	default:
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		build    func(b *ir.Block) ir.Instruction
+		expected string
+	}{
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewAdd(constant.NewInt(types.I64, 2), constant.NewInt(types.I64, 3))
+		}, "i64 5"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewMul(constant.NewInt(types.I8, 16), constant.NewInt(types.I8, 16))
+		}, "i8 0"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewSDiv(constant.NewInt(types.I64, -7), constant.NewInt(types.I64, 2))
+		}, "i64 -3"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewSDiv(constant.NewInt(types.I64, 1), constant.NewInt(types.I64, 0))
+		}, "sdiv i64 1, 0"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewShl(constant.NewInt(types.I64, 1), constant.NewInt(types.I64, 64))
+		}, "shl i64 1, 64"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewLShr(constant.NewInt(types.I8, -1), constant.NewInt(types.I8, 4))
+		}, "i8 15"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewICmp(enum.IPredULT, constant.NewInt(types.I64, -1), constant.NewInt(types.I64, 1))
+		}, "i1 false"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewFAdd(constant.NewFloat(types.Float, 0.5), constant.NewFloat(types.Float, 1.25))
+		}, "float 1.75"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewFDiv(constant.NewFloat(types.Float, 1), constant.NewFloat(types.Float, 0))
+		}, "fdiv float 1.0, 0.0"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewFCmp(enum.FPredOLT, constant.NewFloat(types.Float, 1), constant.NewFloat(types.Float, 2))
+		}, "i1 true"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewSExt(constant.NewInt(types.I32, -2), types.I64)
+		}, "i64 -2"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewZExt(constant.True, types.I64)
+		}, "i64 1"},
+		{func(b *ir.Block) ir.Instruction {
+			return b.NewSIToFP(constant.NewInt(types.I64, 3), types.Float)
+		}, "float 3.0"},
+	}
+
+	for _, tt := range tests {
+		m := ir.NewModule()
+		fn := m.NewFunc("f", types.Void)
+		entry := fn.NewBlock("entry")
+		result := tt.build(entry).(value.Value)
+		// The result is stored so it stays used after folding
+		entry.NewStore(result, entry.NewAlloca(result.Type()))
+		entry.NewRet(nil)
+
+		Optimize(m)
+		if out := fn.LLString(); !strings.Contains(out, tt.expected) {
+			t.Errorf("Expecting %q in\n%s", tt.expected, out)
+		}
+	}
+}
+
+func TestBranches(t *testing.T) {
+	m := ir.NewModule()
+	x := ir.NewParam("x", types.I64)
+	fn := m.NewFunc("f", types.I64, x)
+	entry := fn.NewBlock("entry")
+	then := fn.NewBlock("then")
+	orphan := fn.NewBlock("orphan")
+	end := fn.NewBlock("end")
+
+	cond := entry.NewICmp(enum.IPredEQ, constant.NewInt(types.I64, 1), constant.NewInt(types.I64, 2))
+	entry.NewCondBr(cond, then, end)
+	then.NewBr(end)
+	orphan.NewBr(end)
+	// The sum is only used by the phi of a branch that is never taken
+	sum := end.NewAdd(x, x)
+	end.NewMul(x, x)
+	phi := end.NewPhi(ir.NewIncoming(constant.NewInt(types.I64, 7), entry), ir.NewIncoming(sum, then), ir.NewIncoming(x, orphan))
+	end.NewRet(end.NewAdd(phi, x))
+
+	// IDs assigned when printing are numbered again after the passes
+	fn.LLString()
+	Optimize(m)
+	out := fn.LLString()
+
+	expected := "define i64 @f(i64 %x) {\nentry:\n\tbr label %end\n\nend:\n\t%0 = add i64 7, %x\n\tret i64 %0\n}"
+	if out != expected {
+		t.Errorf("Expecting\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestSideEffects(t *testing.T) {
+	m := ir.NewModule()
+	callee := m.NewFunc("g", types.I64)
+	fn := m.NewFunc("f", types.Void)
+	entry := fn.NewBlock("")
+	slot := entry.NewAlloca(types.I64)
+	entry.NewStore(constant.NewInt(types.I64, 1), slot)
+	entry.NewCall(callee)
+	volatile := entry.NewLoad(types.I64, slot)
+	volatile.Volatile = true
+	entry.NewLoad(types.I64, slot)
+	entry.NewRet(nil)
+
+	Optimize(m, DeadCode)
+	if len(entry.Insts) != 4 {
+		t.Errorf("Expecting the alloca, store, call and volatile load to be kept got\n%s", fn.LLString())
+	}
+}
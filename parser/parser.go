@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/hvuhsg/spython/ast"
 	"github.com/hvuhsg/spython/lexer"
@@ -16,9 +17,13 @@ const (
 	LogicGate     // or and
 	Equals        // ==
 	LessOrGreater // < > >= <=
+	BitwiseOr     // |
+	BitwiseXor    // ^
+	BitwiseAnd    // &
+	Shift         // << >>
 	Sum           // + -
 	Product       // * /
-	Prefix        // -X or !X
+	Prefix        // -X or !X or ~X
 	Call          // myFunction(X)
 	Index         // array[index]
 )
@@ -26,6 +31,8 @@ const (
 var precedences = map[token.TokenType]int{
 	token.Equal:       Equals,
 	token.NotEqual:    Equals,
+	token.In:          Equals,
+	token.Is:          Equals,
 	token.LessThan:    LessOrGreater,
 	token.GreaterThan: LessOrGreater,
 	token.Plus:        Sum,
@@ -35,9 +42,29 @@ var precedences = map[token.TokenType]int{
 	token.Mod:         Product,
 	token.LeftParen:   Call,
 	token.LeftBracket: Index,
+	token.Dot:         Index,
 	token.Assign:      Assign,
 	token.Or:          LogicGate,
 	token.And:         LogicGate,
+	token.BitOr:       BitwiseOr,
+	token.BitXor:      BitwiseXor,
+	token.BitAnd:      BitwiseAnd,
+	token.LeftShift:   Shift,
+	token.RightShift:  Shift,
+}
+
+// Augmented assignment tokens and the binary operator they apply
+var augmentedAssignments = map[token.TokenType]string{
+	token.PlusAssign:       token.Plus,
+	token.MinusAssign:      token.Minus,
+	token.AsteriskAssign:   token.Asterisk,
+	token.SlashAssign:      token.Slash,
+	token.ModAssign:        token.Mod,
+	token.BitAndAssign:     token.BitAnd,
+	token.BitOrAssign:      token.BitOr,
+	token.BitXorAssign:     token.BitXor,
+	token.LeftShiftAssign:  token.LeftShift,
+	token.RightShiftAssign: token.RightShift,
 }
 
 type (
@@ -65,18 +92,21 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.Float, p.parseFloatLiteral)
 	p.registerPrefix(token.Bang, p.parsePrefixExpression)
 	p.registerPrefix(token.Minus, p.parsePrefixExpression)
+	p.registerPrefix(token.Tilde, p.parsePrefixExpression)
 	p.registerPrefix(token.True, p.parseBoolean)
 	p.registerPrefix(token.False, p.parseBoolean)
 	p.registerPrefix(token.LeftParen, p.parseGroupedExpression)
 	p.registerPrefix(token.If, p.parseIfExpression)
 	p.registerPrefix(token.While, p.parseWhileExpression)
 	p.registerPrefix(token.Function, p.parseFunctionLiteral)
+	p.registerPrefix(token.Lambda, p.parseLambdaExpression)
+	p.registerPrefix(token.None, p.parseIdentifier)
 	p.registerPrefix(token.String, p.parseStringLiteral)
 	p.registerPrefix(token.LeftBracket, p.parseArrayLiteral)
 	p.registerPrefix(token.LeftBrace, p.parseHashLiteral)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
-	p.registerInfix(token.Assign, p.parseInfixExpression)
+	p.registerInfix(token.Assign, p.parseAssignInExpression)
 	p.registerInfix(token.Plus, p.parseInfixExpression)
 	p.registerInfix(token.Minus, p.parseInfixExpression)
 	p.registerInfix(token.Mod, p.parseInfixExpression)
@@ -84,12 +114,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.Asterisk, p.parseInfixExpression)
 	p.registerInfix(token.Equal, p.parseInfixExpression)
 	p.registerInfix(token.NotEqual, p.parseInfixExpression)
+	p.registerInfix(token.In, p.parseInfixExpression)
+	p.registerInfix(token.Is, p.parseIsExpression)
 	p.registerInfix(token.LessThan, p.parseInfixExpression)
 	p.registerInfix(token.GreaterThan, p.parseInfixExpression)
 	p.registerInfix(token.Or, p.parseInfixExpression)
 	p.registerInfix(token.And, p.parseInfixExpression)
+	p.registerInfix(token.BitOr, p.parseInfixExpression)
+	p.registerInfix(token.BitXor, p.parseInfixExpression)
+	p.registerInfix(token.BitAnd, p.parseInfixExpression)
+	p.registerInfix(token.LeftShift, p.parseInfixExpression)
+	p.registerInfix(token.RightShift, p.parseInfixExpression)
 	p.registerInfix(token.LeftParen, p.parseCallExpression)
 	p.registerInfix(token.LeftBracket, p.parseIndexExpression)
+	p.registerInfix(token.Dot, p.parseAttributeExpression)
 
 	// Read two tokens, so currentToken and peekToken are both set
 	p.nextToken()
@@ -124,19 +162,139 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.ENDL:
 		p.nextToken()
 		return p.parseStatement()
+	case token.EOF:
+		return nil
 	case token.Return:
 		return p.parseReturnStatement()
+	case token.Global:
+		return p.parseGlobalStatement()
+	case token.Nonlocal:
+		return p.parseNonlocalStatement()
+	case token.Class:
+		return p.parseClassStatement()
+	case token.Try:
+		return p.parseTryStatement()
+	case token.Raise:
+		return p.parseRaiseStatement()
+	case token.Assert:
+		return p.parseAssertStatement()
+	case token.Import:
+		return p.parseImportStatement()
+	case token.From:
+		return p.parseFromImportStatement()
+	case token.Extern:
+		return p.parseExternStatement()
+	case token.At:
+		return p.parseDecoratedStatement()
+	case token.Pass:
+		stmt := &ast.PassStatement{Token: p.currentToken}
+		if p.peekTokenIs(token.ENDL) {
+			p.nextToken()
+		}
+		return stmt
 	default:
-		return p.parseExpressionStatement()
+		return p.parseSimpleStatement()
 	}
 }
 
+// Parse an expression statement or an assignment into the expression
+func (p *Parser) parseSimpleStatement() ast.Statement {
+	firstToken := p.currentToken
+	exp := p.parseExpressionTuple(Assign)
+
+	var stmt ast.Statement
+	if p.peekTokenIs(token.Colon) {
+		stmt = p.parseAnnotatedAssignStatement(exp)
+	} else if p.peekTokenIs(token.Assign) {
+		stmt = p.parseAssignStatement(exp)
+	} else if _, ok := augmentedAssignments[p.peekToken.Type]; ok {
+		stmt = p.parseAugmentedAssignStatement(exp)
+	} else {
+		stmt = &ast.ExpressionStatement{Token: firstToken, Expression: exp}
+	}
+
+	if p.peekTokenIs(token.ENDL) || p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAssignStatement(target ast.Expression) *ast.AssignStatement {
+	stmt := &ast.AssignStatement{Token: p.peekToken, Targets: []ast.Expression{target}}
+
+	for p.peekTokenIs(token.Assign) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Value = p.parseExpressionTuple(Assign)
+
+		// a = b = value, the previous value is another target
+		if p.peekTokenIs(token.Assign) {
+			stmt.Targets = append(stmt.Targets, stmt.Value)
+		}
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAnnotatedAssignStatement(target ast.Expression) ast.Statement {
+	// the target failed to parse and reported an error already
+	if target == nil {
+		return nil
+	}
+
+	name, ok := target.(*ast.Identifier)
+	if !ok {
+		msg := fmt.Sprintf("only a single name can be annotated, got %s", target.String())
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	p.nextToken()
+	stmt := &ast.AnnotatedAssignStatement{Token: p.currentToken, Name: name}
+
+	// get type
+	p.nextToken()
+	stmt.Type = p.parseExpression(Assign)
+
+	if p.peekTokenIs(token.Assign) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Value = p.parseExpressionTuple(Assign)
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAugmentedAssignStatement(target ast.Expression) *ast.AugmentedAssignStatement {
+	stmt := &ast.AugmentedAssignStatement{Target: target}
+
+	// get operator
+	p.nextToken()
+	stmt.Token = p.currentToken
+	stmt.Operator = augmentedAssignments[p.currentToken.Type]
+
+	// get value
+	p.nextToken()
+	stmt.Value = p.parseExpressionTuple(Assign)
+
+	return stmt
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.currentToken}
 
-	// get value
+	// a bare return has no value
+	if p.peekTokenIs(token.ENDL) {
+		p.nextToken()
+		return stmt
+	} else if p.peekTokenIs(token.EOF) {
+		return stmt
+	}
+
+	// get value, return a, b returns a tuple
 	p.nextToken()
-	stmt.ReturnValue = p.parseExpression(Lowest)
+	stmt.ReturnValue = p.parseExpressionTuple(Lowest)
 
 	if p.peekTokenIs(token.ENDL) {
 		p.nextToken()
@@ -145,6 +303,343 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseClassStatement() ast.Statement {
+	stmt := &ast.ClassStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	// class Circle(Shape): or class Circle(shapes.Shape):
+	if p.peekTokenIs(token.LeftParen) {
+		p.nextToken()
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+		stmt.Base = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+		for p.peekTokenIs(token.Dot) {
+			p.nextToken()
+			if stmt.Base = p.parseAttributeExpression(stmt.Base); stmt.Base == nil {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.RightParen) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.Colon) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.Colon) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	// The clauses of the statement are indented like the 'try' keyword
+	for p.peekTokenIs(token.Except) && p.peekToken.Tab == stmt.Token.Tab {
+		p.nextToken()
+		handler := p.parseExceptClause()
+		if handler == nil {
+			return nil
+		}
+		stmt.Handlers = append(stmt.Handlers, handler)
+	}
+
+	if len(stmt.Handlers) > 0 && p.peekTokenIs(token.Else) && p.peekToken.Tab == stmt.Token.Tab {
+		p.nextToken()
+		if !p.expectPeek(token.Colon) {
+			return nil
+		}
+		stmt.Else = p.parseBlockStatement()
+		if stmt.Else == nil {
+			return nil
+		}
+	}
+
+	if p.peekTokenIs(token.Finally) && p.peekToken.Tab == stmt.Token.Tab {
+		p.nextToken()
+		if !p.expectPeek(token.Colon) {
+			return nil
+		}
+		stmt.Finally = p.parseBlockStatement()
+		if stmt.Finally == nil {
+			return nil
+		}
+	}
+
+	if len(stmt.Handlers) == 0 && stmt.Finally == nil {
+		p.errors = append(p.errors, "expected 'except' or 'finally' block after 'try'")
+		return nil
+	}
+
+	return stmt
+}
+
+// except:, except ValueError:, except (KeyError, IndexError) as e:
+func (p *Parser) parseExceptClause() *ast.ExceptClause {
+	clause := &ast.ExceptClause{Token: p.currentToken}
+
+	if !p.peekTokenIs(token.Colon) {
+		p.nextToken()
+		clause.Type = p.parseExpression(Lowest)
+
+		if p.peekTokenIs(token.As) {
+			p.nextToken()
+			if !p.expectPeek(token.Identifier) {
+				return nil
+			}
+			clause.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+		}
+	}
+
+	if !p.expectPeek(token.Colon) {
+		return nil
+	}
+
+	clause.Body = p.parseBlockStatement()
+	if clause.Body == nil {
+		return nil
+	}
+
+	return clause
+}
+
+func (p *Parser) parseRaiseStatement() ast.Statement {
+	stmt := &ast.RaiseStatement{Token: p.currentToken}
+
+	// a bare raise re-raises the exception being handled
+	if p.peekTokenIs(token.ENDL) {
+		p.nextToken()
+		return stmt
+	} else if p.peekTokenIs(token.EOF) {
+		return stmt
+	}
+
+	p.nextToken()
+	stmt.Exception = p.parseExpression(Lowest)
+
+	if p.peekTokenIs(token.ENDL) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAssertStatement() ast.Statement {
+	stmt := &ast.AssertStatement{Token: p.currentToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(Lowest)
+
+	if p.peekTokenIs(token.Comma) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Message = p.parseExpression(Lowest)
+	}
+
+	if p.peekTokenIs(token.ENDL) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	stmt := &ast.ImportStatement{Token: p.currentToken}
+
+	stmt.Modules = p.parseImportNames()
+	if stmt.Modules == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseFromImportStatement() ast.Statement {
+	stmt := &ast.FromImportStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
+	stmt.Module = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(token.Import) {
+		return nil
+	}
+
+	stmt.Names = p.parseImportNames()
+	if stmt.Names == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// Parse the decorators of a function definition, each on its own line
+// before the def
+func (p *Parser) parseDecoratedStatement() ast.Statement {
+	var decorators []*ast.Identifier
+	for p.currentTokenIs(token.At) {
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+		decorators = append(decorators, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+		if !p.expectPeek(token.ENDL) {
+			return nil
+		}
+		p.nextToken()
+	}
+
+	if !p.currentTokenIs(token.Function) {
+		p.errors = append(p.errors, fmt.Sprintf("decorators can only be applied to functions, got %s", p.currentToken.Literal))
+		return nil
+	}
+
+	stmt := p.parseStatement()
+	if exprStmt, ok := stmt.(*ast.ExpressionStatement); ok {
+		if funcLit, ok := exprStmt.Expression.(*ast.FunctionLiteral); ok {
+			funcLit.Decorators = decorators
+		}
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExternStatement() ast.Statement {
+	stmt := &ast.ExternStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.Function) || !p.expectPeek(token.Identifier) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(token.LeftParen) {
+		return nil
+	}
+	stmt.Parameters = p.parseFunctionParameters()
+
+	// the ... of a variadic function is parsed as an unannotated parameter
+	for i, param := range stmt.Parameters {
+		if param == nil || param.Token.Type != token.Ellipsis {
+			continue
+		}
+		if i != len(stmt.Parameters)-1 {
+			p.errors = append(p.errors, fmt.Sprintf("... must be the last parameter of extern function %s", stmt.Name.Value))
+			return nil
+		}
+		stmt.Parameters = stmt.Parameters[:i]
+		stmt.Variadic = true
+	}
+
+	if p.peekTokenIs(token.Arrow) {
+		p.nextToken()
+		p.nextToken()
+		stmt.ReturnType = p.parseExpression(Lowest)
+	} else {
+		stmt.ReturnType = &ast.Identifier{Token: token.Token{}, Value: token.None}
+	}
+
+	if p.peekTokenIs(token.ENDL) || p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// Parse the comma separated names following an 'import' keyword, each can
+// have an alias
+func (p *Parser) parseImportNames() []*ast.ImportName {
+	var names []*ast.ImportName
+
+	for {
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+		name := &ast.ImportName{Name: &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}}
+
+		if p.peekTokenIs(token.As) {
+			p.nextToken()
+			if !p.expectPeek(token.Identifier) {
+				return nil
+			}
+			name.Alias = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+		}
+		names = append(names, name)
+
+		if !p.peekTokenIs(token.Comma) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.peekTokenIs(token.ENDL) || p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return names
+}
+
+func (p *Parser) parseGlobalStatement() ast.Statement {
+	stmt := &ast.GlobalStatement{Token: p.currentToken}
+
+	stmt.Names = p.parseNameList()
+	if stmt.Names == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseNonlocalStatement() ast.Statement {
+	stmt := &ast.NonlocalStatement{Token: p.currentToken}
+
+	stmt.Names = p.parseNameList()
+	if stmt.Names == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// Parse the comma separated names following a 'global' or 'nonlocal' keyword
+func (p *Parser) parseNameList() []*ast.Identifier {
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
+	names := []*ast.Identifier{{Token: p.currentToken, Value: p.currentToken.Literal}}
+
+	for p.peekTokenIs(token.Comma) {
+		p.nextToken()
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+		names = append(names, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+	}
+
+	if p.peekTokenIs(token.ENDL) || p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return names
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.currentToken}
 
@@ -181,6 +676,31 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// Parse an expression, or a tuple if the expression is followed by commas (a, b)
+func (p *Parser) parseExpressionTuple(precedence int) ast.Expression {
+	firstToken := p.currentToken
+	exp := p.parseExpression(precedence)
+
+	if !p.peekTokenIs(token.Comma) {
+		return exp
+	}
+
+	tuple := &ast.TupleLiteral{Token: firstToken, Elements: []ast.Expression{exp}}
+	for p.peekTokenIs(token.Comma) {
+		p.nextToken()
+
+		// allow trailing comma, a, = ...
+		if p.peekTokenIs(token.Assign) || p.peekTokenIs(token.ENDL) || p.peekTokenIs(token.EOF) {
+			break
+		}
+
+		p.nextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(precedence))
+	}
+
+	return tuple
+}
+
 // Prefix expressions
 
 func (p *Parser) parseIdentifier() ast.Expression {
@@ -233,10 +753,35 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	// empty tuple
+	if p.peekTokenIs(token.RightParen) {
+		tuple := &ast.TupleLiteral{Token: p.currentToken}
+		p.nextToken()
+		return tuple
+	}
+
 	// get expression
 	p.nextToken()
+	firstToken := p.currentToken
 	exp := p.parseExpression(Lowest)
 
+	// (a, b) is a tuple and (a,) is a tuple with one element
+	if p.peekTokenIs(token.Comma) {
+		tuple := &ast.TupleLiteral{Token: firstToken, Elements: []ast.Expression{exp}}
+
+		for p.peekTokenIs(token.Comma) {
+			p.nextToken()
+			if p.peekTokenIs(token.RightParen) {
+				break
+			}
+
+			p.nextToken()
+			tuple.Elements = append(tuple.Elements, p.parseExpression(Lowest))
+		}
+
+		exp = tuple
+	}
+
 	if !p.expectPeek(token.RightParen) {
 		return nil
 	}
@@ -256,10 +801,10 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	expression.Consequence = p.parseBlockStatement()
-	p.nextToken()
 
 	// TODO parse else if
-	if p.currentTokenIs(token.Else) {
+	if p.peekTokenIs(token.Else) && p.peekToken.Tab == expression.Token.Tab {
+		p.nextToken()
 
 		if !p.expectPeek(token.Colon) {
 			return nil
@@ -295,19 +840,30 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		return nil
 	}
 
+	// A block must be indented deeper than the line that opens it, an empty
+	// body is written with pass
+	p.skipEmptyLines()
+	if p.peekTokenIs(token.EOF) || p.peekToken.Tab <= block.Token.Tab {
+		p.errors = append(p.errors, fmt.Sprintf("expected an indented block at line %d", block.Token.Row+1))
+		return block
+	}
+
 	p.nextToken()
-	block.Level = p.peekToken.Tab
+	block.Level = p.currentToken.Tab
 
 	for p.currentInLevel(block.Level) && !p.currentTokenIs(token.EOF) {
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
-		// FIXME: code := "def fib(n: int) -> int:\n\ta = 0\n\tb = 1\n\twhile n > 0:\n\t\tn = n - 1\n\t\tb = a + b\n\t\ta = b - a\n\treturn b\nreturn fib(40)"
 
-		if p.peekInLevel(block.Level) {
-			p.nextToken()
+		// The block ends at the first line that is not indented to its level,
+		// that token is left as the peek token for the enclosing block.
+		p.skipEmptyLines()
+		if !p.peekInLevel(block.Level) || p.peekTokenIs(token.EOF) {
+			break
 		}
+		p.nextToken()
 	}
 
 	return block
@@ -326,7 +882,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if p.peekTokenIs(token.Arrow) {
 		p.nextToken()
 		p.nextToken()
-		lit.ReturnType = p.parseIdentifier().(*ast.Identifier)
+		lit.ReturnType = p.parseExpression(Lowest)
 	} else {
 		lit.ReturnType = &ast.Identifier{Token: token.Token{}, Value: token.None}
 	}
@@ -340,6 +896,28 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+// Parse lambda x, y: body, the parameters take their types from the
+// Callable type the lambda is used as
+func (p *Parser) parseLambdaExpression() ast.Expression {
+	lambda := &ast.LambdaExpression{Token: p.currentToken}
+
+	for !p.peekTokenIs(token.Colon) {
+		if len(lambda.Parameters) > 0 && !p.expectPeek(token.Comma) {
+			return nil
+		}
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+		lambda.Parameters = append(lambda.Parameters, &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal})
+	}
+
+	p.nextToken()
+	p.nextToken()
+	lambda.Body = p.parseExpression(Lowest)
+
+	return lambda
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.FunctionParameter {
 	var parameters []*ast.FunctionParameter
 
@@ -370,12 +948,17 @@ func (p *Parser) parseFunctionParameters() []*ast.FunctionParameter {
 func (p *Parser) parseFunctionParameter() *ast.FunctionParameter {
 	param := &ast.FunctionParameter{Token: p.currentToken}
 
+	// a parameter without annotation, the compiler only allows it for self
+	if p.peekTokenIs(token.Comma) || p.peekTokenIs(token.RightParen) {
+		return param
+	}
+
 	if !p.expectPeek(token.Colon) {
 		return nil
 	}
 
 	p.nextToken()
-	param.Type = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+	param.Type = p.parseExpression(Assign)
 
 	if p.peekTokenIs(token.Assign) {
 		p.nextToken()
@@ -387,7 +970,24 @@ func (p *Parser) parseFunctionParameter() *ast.FunctionParameter {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Token: p.currentToken, Value: p.currentToken.Literal}
+	lit := &ast.StringLiteral{Token: p.currentToken}
+
+	// single quoted strings are unquoted as double quoted ones
+	quoted := p.currentToken.Literal
+	if quoted[0] == '\'' {
+		quoted = strings.ReplaceAll(quoted[1:len(quoted)-1], "\\'", "'")
+		quoted = `"` + strings.ReplaceAll(quoted, `"`, `\"`) + `"`
+	}
+
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %s as string", p.currentToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
@@ -425,8 +1025,9 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.currentToken, Left: left}
 
+	// dict[str, int] is indexed by a tuple
 	p.nextToken()
-	exp.Index = p.parseExpression(Lowest)
+	exp.Index = p.parseExpressionTuple(Lowest)
 
 	if !p.expectPeek(token.RightBracket) {
 		return nil
@@ -437,7 +1038,6 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.currentToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
 	for !p.peekTokenIs(token.RightBrace) {
 		p.nextToken()
@@ -450,7 +1050,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(Lowest)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RightBrace) && !p.expectPeek(token.Comma) {
 			return nil
@@ -479,6 +1079,47 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// x is y and x is not y
+func (p *Parser) parseIsExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InfixExpression{
+		Token:    p.currentToken,
+		Operator: p.currentToken.Literal,
+		Left:     left,
+	}
+
+	precedence := p.currentPrecedence()
+	if p.peekTokenIs(token.Not) {
+		p.nextToken()
+		expression.Operator += " " + p.currentToken.Literal
+	}
+
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+	return expression
+}
+
+func (p *Parser) parseAttributeExpression(object ast.Expression) ast.Expression {
+	exp := &ast.AttributeExpression{Token: p.currentToken, Object: object}
+
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
+	exp.Attribute = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	return exp
+}
+
+// Assignment is a statement, reaching '=' while parsing an expression is an error
+func (p *Parser) parseAssignInExpression(left ast.Expression) ast.Expression {
+	msg := fmt.Sprintf("can not assign into %s inside an expression", left.String())
+	p.errors = append(p.errors, msg)
+
+	p.nextToken()
+	p.parseExpression(Assign)
+
+	return left
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.currentToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RightParen)
@@ -492,6 +1133,13 @@ func (p *Parser) nextToken() {
 	p.peekToken = p.l.NextToken()
 }
 
+// Skip new line tokens until the peek token is the first token of a line with code
+func (p *Parser) skipEmptyLines() {
+	for p.peekTokenIs(token.ENDL) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) currentInLevel(level int) bool {
 	return p.currentToken.Tab == level
 }
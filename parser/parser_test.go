@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/hvuhsg/spython/ast"
 	"github.com/hvuhsg/spython/lexer"
 )
 
@@ -28,3 +29,361 @@ func TestWhileLoop(t *testing.T) {
 		t.Error("While loop was not parsed correctly")
 	}
 }
+
+func TestBitwisePrecedence(t *testing.T) {
+	lexer := lexer.New("a | b ^ c & d << 1 + 2\n~a == b")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if program.String() != "(a | (b ^ (c & (d << (1 + 2)))))\n((~a) == b)\n" {
+		t.Errorf("Bitwise operators precedence is wrong, got %q", program.String())
+	}
+}
+
+func TestIfElseInsideFunction(t *testing.T) {
+	lexer := lexer.New("def b():\n\tif 1 > 2:\n\t\ta = 1\n\n\telse:\n\t\ta = 2\n\n\treturn 2\nb()")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 top level statements got %d", len(program.Statements))
+	}
+
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.Body.Statements) != 2 {
+		t.Fatalf("Expected 2 statements in function body got %d", len(function.Body.Statements))
+	}
+
+	ifExp := function.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if ifExp.Alternative == nil || ifExp.Alternative.String() != "\t\ta = 2" {
+		t.Errorf("If else inside function was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestAugmentedAssignment(t *testing.T) {
+	lexer := lexer.New("while n > 0:\n\tn -= 1 + 1\n\tb <<= 2")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if program.String() != "while (n > 0):\n\tn -= (1 + 1)\n\tb <<= 2\n" {
+		t.Errorf("Augmented assignment was not parsed correctly, got %q", program.String())
+	}
+
+	loop := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.WhileExpression)
+	stmt, ok := loop.Consequence.Statements[1].(*ast.AugmentedAssignStatement)
+	if !ok || stmt.Operator != "<<" {
+		t.Errorf("Expected augmented assignment with operator << got %v", loop.Consequence.Statements[1])
+	}
+}
+
+func TestAssignStatement(t *testing.T) {
+	lexer := lexer.New("x = y = 3\na, b = b, a + b\np.x = q.y = 1")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if program.String() != "x = y = 3\n(a, b) = (b, (a + b))\np.x = q.y = 1\n" {
+		t.Errorf("Assignments were not parsed correctly, got %q", program.String())
+	}
+
+	chained := program.Statements[0].(*ast.AssignStatement)
+	if len(chained.Targets) != 2 {
+		t.Errorf("Expected 2 assignment targets got %d", len(chained.Targets))
+	}
+}
+
+func TestAssignInsideExpression(t *testing.T) {
+	lexer := lexer.New("f(a = 1)")
+	parser := New(&lexer)
+	parser.ParseProgram()
+
+	if len(parser.Errors()) != 1 || parser.Errors()[0] != "can not assign into a inside an expression" {
+		t.Errorf("Expecting an assignment inside expression error got %v", parser.Errors())
+	}
+}
+
+func TestAnnotatedAssignment(t *testing.T) {
+	lexer := lexer.New("x: float = 0\ny: int\n")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if program.String() != "x: float = 0\ny: int\n" {
+		t.Errorf("Annotated assignments were not parsed correctly, got %q", program.String())
+	}
+
+	decl := program.Statements[1].(*ast.AnnotatedAssignStatement)
+	if decl.Value != nil {
+		t.Errorf("Expected bare declaration to have no value got %s", decl.Value.String())
+	}
+}
+
+func TestAnnotatedAssignmentBadTarget(t *testing.T) {
+	for _, code := range []string{"): int = 1", "(: int"} {
+		lexer := lexer.New(code)
+		parser := New(&lexer)
+		parser.ParseProgram()
+
+		if len(parser.Errors()) == 0 {
+			t.Errorf("Expecting a parsing error for %q", code)
+		}
+	}
+}
+
+func TestListTypes(t *testing.T) {
+	lexer := lexer.New("def f(xs: list[int], n: int = 1) -> list[list[float]]:\n\treturn [xs[0], [1.5]]")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if program.String() != "def f(xs: (list[int]), n: int = 1) -> (list[(list[float])]):\n\treturn [(xs[0]), [1.5]]\n" {
+		t.Errorf("List types were not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestDictLiteralOrder(t *testing.T) {
+	lexer := lexer.New("d: dict[str, int] = {'z': 1, \"a\": 2, 'm': 3}")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if program.String() != "d: (dict[(str, int)]) = {'z': 1, \"a\": 2, 'm': 3}\n" {
+		t.Errorf("Dict literal was not parsed in source order, got %q", program.String())
+	}
+}
+
+func TestReturnTuple(t *testing.T) {
+	lexer := lexer.New("def f(a: int) -> tuple[int, float]:\n\treturn a, 1.5\nq, r = f(1)")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if program.String() != "def f(a: int) -> (tuple[(int, float)]):\n\treturn (a, 1.5)\n(q, r) = f(1)\n" {
+		t.Errorf("Tuple return was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestClass(t *testing.T) {
+	lexer := lexer.New("class Point:\n\tx: int = 0\n\n\tdef move(self, dx: int):\n\t\tself.x += dx\n\t\treturn\np = Point()")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expected 2 statements got %d", len(program.Statements))
+	}
+
+	class, ok := program.Statements[0].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("Expected a class statement got %T", program.Statements[0])
+	}
+
+	if class.String() != "class Point:\n\tx: int = 0\n\tdef move(self, dx: int) -> None:\n\t\tself.x += dx\n\t\treturn " {
+		t.Errorf("Class was not parsed correctly, got %q", class.String())
+	}
+}
+
+func TestClassBase(t *testing.T) {
+	lexer := lexer.New("class Circle(Shape):\n\tr: float\n")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	class := program.Statements[0].(*ast.ClassStatement)
+	if class.Base == nil || class.Base.String() != "Shape" {
+		t.Fatalf("Expected base class Shape got %v", class.Base)
+	}
+
+	if program.String() != "class Circle(Shape):\n\tr: float\n" {
+		t.Errorf("Class was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestClassModuleBase(t *testing.T) {
+	lexer := lexer.New("class Q(geo.Pt):\n\tr: float\n")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	class := program.Statements[0].(*ast.ClassStatement)
+	if _, ok := class.Base.(*ast.AttributeExpression); !ok || class.Base.String() != "geo.Pt" {
+		t.Fatalf("Expected base class geo.Pt got %v", class.Base)
+	}
+}
+
+func TestNonlocal(t *testing.T) {
+	lexer := lexer.New("def outer():\n\tx = 1\n\tdef inner():\n\t\tnonlocal x, y\n\t\tx = 2\n")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "def outer() -> None:\n\tx = 1\n\tdef inner() -> None:\n\t\tnonlocal x, y\n\t\tx = 2\n"
+	if program.String() != expected {
+		t.Errorf("Nonlocal was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestLambda(t *testing.T) {
+	lexer := lexer.New("f: Callable[[int, int], None] = lambda x, y: print(x + y)\ng = lambda: 1")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "f: (Callable[([int, int], None)]) = lambda x, y: print((x + y))\ng = lambda: 1\n"
+	if program.String() != expected {
+		t.Errorf("Lambda was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	lexer := lexer.New("try:\n\tx = f()\nexcept (KeyError, IndexError) as e:\n\traise\nexcept ValueError:\n\tx = 0\nelse:\n\tx += 1\nfinally:\n\tdone()\nraise ValueError('bad')")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "try:\n\tx = f()\nexcept (KeyError, IndexError) as e:\n\traise\nexcept ValueError:\n\tx = 0\nelse:\n\tx += 1\nfinally:\n\tdone()\nraise ValueError('bad')\n"
+	if program.String() != expected {
+		t.Errorf("Try statement was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestAssertStatement(t *testing.T) {
+	lexer := lexer.New("assert x > 0\nassert len(xs) == 2, 'two items'")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "assert (x > 0)\nassert (len(xs) == 2), 'two items'\n"
+	if program.String() != expected {
+		t.Errorf("Assert statement was not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestPassAndNone(t *testing.T) {
+	lexer := lexer.New("class Empty:\n\tpass\n\ndef f(x: Optional[Node], y: Node | None) -> None:\n\tif x is None:\n\t\tpass\n\treturn y is not None")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "class Empty:\n\tpass\ndef f(x: (Optional[Node]), y: (Node | None)) -> None:\n\tif (x is None):\n\t\tpass\n\treturn (y is not None)\n"
+	if program.String() != expected {
+		t.Errorf("Pass and None were not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestEmptyBlock(t *testing.T) {
+	lexer := lexer.New("def f() -> None:\nx = 1")
+	parser := New(&lexer)
+	parser.ParseProgram()
+
+	errors := parser.Errors()
+	if len(errors) == 0 || errors[0] != "expected an indented block at line 1" {
+		t.Errorf("Expecting an indented block error got %v", errors)
+	}
+}
+
+func TestTryWithoutHandler(t *testing.T) {
+	lexer := lexer.New("try:\n\tx = 1\ny = 2")
+	parser := New(&lexer)
+	parser.ParseProgram()
+
+	if len(parser.Errors()) == 0 {
+		t.Fatalf("Expecting a parsing error for a try statement without except or finally")
+	}
+}
+
+func TestImportStatement(t *testing.T) {
+	lexer := lexer.New("import shapes\nimport geometry as geo, util\nfrom shapes import Circle, area as circle_area\nx: shapes.Circle = shapes.Circle(1)")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "import shapes\nimport geometry as geo, util\nfrom shapes import Circle, area as circle_area\nx: shapes.Circle = shapes.Circle(1)\n"
+	if program.String() != expected {
+		t.Errorf("Import statements were not parsed correctly, got %q", program.String())
+	}
+}
+
+func TestExternStatement(t *testing.T) {
+	lexer := lexer.New("extern def sqrt(x: float) -> float\nextern def printf(format: str, ...) -> c_int\nextern def abort()")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	expected := "extern def sqrt(x: float) -> float\nextern def printf(format: str, ...) -> c_int\nextern def abort() -> None\n"
+	if program.String() != expected {
+		t.Errorf("Extern statements were not parsed correctly, got %q", program.String())
+	}
+
+	if extern := program.Statements[1].(*ast.ExternStatement); !extern.Variadic || len(extern.Parameters) != 1 {
+		t.Errorf("printf should be variadic with one fixed parameter")
+	}
+}
+
+func TestDecorator(t *testing.T) {
+	lexer := lexer.New("@export\ndef area(w: int, h: int) -> int:\n\treturn w * h\nx = area(2, 3)")
+	parser := New(&lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) != 0 {
+		t.Fatalf("Got parsing errors %v", parser.Errors())
+	}
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Expecting 2 statements got %d", len(program.Statements))
+	}
+
+	funcLit := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(funcLit.Decorators) != 1 || funcLit.Decorators[0].Value != "export" {
+		t.Errorf("Expecting the function to be decorated with export, got %v", funcLit.Decorators)
+	}
+}
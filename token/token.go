@@ -35,6 +35,25 @@ const (
 	Or       = "||"
 	And      = "&&"
 
+	BitAnd     = "&"
+	BitOr      = "|"
+	BitXor     = "^"
+	Tilde      = "~"
+	LeftShift  = "<<"
+	RightShift = ">>"
+
+	// Augmented assignment
+	PlusAssign       = "+="
+	MinusAssign      = "-="
+	AsteriskAssign   = "*="
+	SlashAssign      = "/="
+	ModAssign        = "%="
+	BitAndAssign     = "&="
+	BitOrAssign      = "|="
+	BitXorAssign     = "^="
+	LeftShiftAssign  = "<<="
+	RightShiftAssign = ">>="
+
 	LessThan         = "<"
 	LessThenEqual    = "<="
 	GreaterThan      = ">"
@@ -44,7 +63,10 @@ const (
 	Comma     = ","
 	Semicolon = ";"
 	Colon     = ":"
+	Dot       = "."
+	Ellipsis  = "..."
 	Arrow     = "->"
+	At        = "@"
 
 	LeftParen    = "("
 	RightParen   = ")"
@@ -62,4 +84,21 @@ const (
 	Return   = "Return"
 	For      = "For"
 	While    = "while"
+	Global   = "global"
+	Nonlocal = "nonlocal"
+	Lambda   = "lambda"
+	In       = "in"
+	Class    = "class"
+	Try      = "try"
+	Except   = "except"
+	Finally  = "finally"
+	Raise    = "raise"
+	As       = "as"
+	Assert   = "assert"
+	Pass     = "pass"
+	Is       = "is"
+	Not      = "not"
+	Import   = "import"
+	From     = "from"
+	Extern   = "extern"
 )